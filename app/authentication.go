@@ -6,12 +6,15 @@ import (
 	"golang.org/x/oauth2"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/opensentry/aap/config"
 )
 
-func AuthenticationRequired(logKey string, accessTokenKey string) gin.HandlerFunc {
+func AuthenticationRequired(env *Environment) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 
-		log := c.MustGet(logKey).(*logrus.Entry)
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
 		log = log.WithFields(logrus.Fields{
 			"func": "AuthenticationRequired",
 		})
@@ -28,15 +31,36 @@ func AuthenticationRequired(logKey string, accessTokenKey string) gin.HandlerFun
 				TokenType:   split[0],
 			}
 
+			// JWT access tokens can be verified without asking hydra. Opaque tokens are left for introspection.
+			if IsLocalVerificationEnabled() && IsJwtAccessToken(token.AccessToken) {
+				claims, err := VerifyAccessToken(env, token.AccessToken, config.GetString("id"))
+				if err != nil {
+					log.Debug(err.Error())
+					c.JSON(http.StatusUnauthorized, JsonError{ErrorCode: ERROR_INVALID_ACCESS_TOKEN, Error: "Invalid access token."})
+					c.Abort()
+					return
+				}
+
+				token.Expiry = time.Unix(claims.Expire, 0)
+			}
+
 			// See #2 of QTNA
 			// https://godoc.org/golang.org/x/oauth2#Token.Valid
 			if token.Valid() == true {
 
-				// See #5 of QTNA
-				log.WithFields(logrus.Fields{"fixme": 1, "qtna": 5}).Debug("Missing check against token-revoked-list to check if token is revoked")
+				// See #5 of QTNA. Opaque tokens are checked by the judge after introspection.
+				if IsJwtAccessToken(token.AccessToken) {
+					revokableToken, err := decodeRevokableToken(token.AccessToken)
+					if err != nil || env.Revocations.IsRevoked(revokableToken) {
+						log.WithFields(logrus.Fields{"qtna": 5}).Debug("Access token revoked")
+						c.JSON(http.StatusUnauthorized, JsonError{ErrorCode: ERROR_INVALID_ACCESS_TOKEN, Error: "Invalid access token."})
+						c.Abort()
+						return
+					}
+				}
 
 				log.Debug("Authenticated")
-				c.Set(accessTokenKey, token)
+				c.Set(env.Constants.AccessTokenKey, token)
 				c.Next() // Authentication successful, continue.
 				return
 			}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/opensentry/aap/config"
 	"github.com/opensentry/aap/gateway/aap"
+	"github.com/opensentry/aap/utils"
 )
 
 func AuthorizationRequired(env *Environment, requiredScopes ...string) gin.HandlerFunc {
@@ -50,7 +52,7 @@ func AuthorizationRequired(env *Environment, requiredScopes ...string) gin.Handl
 		defer tx.Close() // rolls back if not already committed/rolled back
 		defer session.Close()
 
-		judgeVerdict, err := Judge(tx, accessToken, iPublisher, iScopes, iOwners, iCaller, hydraClient, env.OAuth2Delegator.IntrospectTokenUrl)
+		judgeVerdict, err := Judge(env, tx, accessToken, iPublisher, iScopes, iOwners, iCaller, hydraClient)
 		if err != nil {
 			log.Debug(err.Error())
 			c.AbortWithStatus(http.StatusInternalServerError)
@@ -68,6 +70,13 @@ func AuthorizationRequired(env *Environment, requiredScopes ...string) gin.Handl
 			return
 		}
 
+		if judgeVerdict.Error == REASON_STEP_UP_REQUIRED {
+			log.Debug("Step-up required")
+			c.Header("WWW-Authenticate", stepUpChallenge(judgeVerdict.StepUps))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, JsonError{ErrorCode: ERROR_STEP_UP_REQUIRED, Error: judgeVerdict.Reason})
+			return
+		}
+
 		// Deny by default
 		log.Debug("Unauthorized")
 		c.AbortWithStatusJSON(http.StatusForbidden, JsonError{ErrorCode: ERROR_MISSING_REQUIRED_SCOPES, Error: judgeVerdict.Reason})
@@ -76,3 +85,37 @@ func AuthorizationRequired(env *Environment, requiredScopes ...string) gin.Handl
 	}
 	return gin.HandlerFunc(fn)
 }
+
+// stepUpChallenge tells the client how the subject must authenticate again, see RFC 9470. Accepted acr values are those accepted by all step-ups, max age is the lowest.
+func stepUpChallenge(stepUps []aap.VerdictStepUp) string {
+	var acrValues []string
+	var acrRequired bool
+	var maxAge int64
+	for _, stepUp := range stepUps {
+		if len(stepUp.Acr) > 0 && !acrRequired {
+			acrRequired = true
+			acrValues = stepUp.Acr
+		} else if len(stepUp.Acr) > 0 {
+			var common []string
+			for _, acr := range acrValues {
+				if utils.StringInSlice(acr, stepUp.Acr) {
+					common = append(common, acr)
+				}
+			}
+			acrValues = common
+		}
+
+		if stepUp.MaxAge > 0 && (maxAge == 0 || stepUp.MaxAge < maxAge) {
+			maxAge = stepUp.MaxAge
+		}
+	}
+
+	challenge := `Bearer error="insufficient_user_authentication"`
+	if len(acrValues) > 0 {
+		challenge += fmt.Sprintf(`, acr_values="%s"`, strings.Join(acrValues, " "))
+	}
+	if maxAge > 0 {
+		challenge += fmt.Sprintf(`, max_age=%d`, maxAge)
+	}
+	return challenge
+}
@@ -0,0 +1,167 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+
+	"github.com/opensentry/aap/config"
+	"github.com/opensentry/aap/gateway/aap"
+)
+
+type verdictCacheEntry struct {
+	JudgeVerdict JudgeVerdict
+	Token        RevokableToken // Revocations are checked on every hit
+	Expire       int64
+}
+
+// In process cache of judge verdicts. Entries live until the token expires, but no longer than judge.cache.ttl seconds.
+// Any change to grants, shadows, publishes, subscriptions or consents, including rejected and expired consents, invalidates the entire cache on every aap instance.
+type VerdictCache struct {
+	sync.RWMutex
+	entries     map[string]verdictCacheEntry
+	hits        uint64
+	misses      uint64
+	generation  uint64 // Bumped on every clear. Verdicts judged from a graph read before a clear are not cached
+	unbroadcast uint32 // 1 = the last invalidation did not reach the other aap instances
+}
+
+type VerdictCacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+}
+
+func NewVerdictCache() *VerdictCache {
+	return &VerdictCache{entries: make(map[string]verdictCacheEntry)}
+}
+
+func IsVerdictCacheEnabled() bool {
+	return config.GetInt("judge.cache.enabled") == 1
+}
+
+func verdictCacheKey(rawToken string, iPublisher aap.Identity, iScopes []aap.Scope, iOwners []aap.Identity, iCaller aap.Identity) string {
+	var scopes []string
+	for _, scope := range iScopes {
+		scopes = append(scopes, scope.Name)
+	}
+	sort.Strings(scopes)
+
+	var owners []string
+	for _, owner := range iOwners {
+		owners = append(owners, owner.Id)
+	}
+	sort.Strings(owners)
+
+	// Never keep the raw access token in memory longer than needed
+	hash := sha256.Sum256([]byte(rawToken))
+	return strings.Join([]string{hex.EncodeToString(hash[:]), iPublisher.Id, strings.Join(scopes, " "), strings.Join(owners, " "), iCaller.Id}, "|")
+}
+
+func (vc *VerdictCache) Get(key string) (entry verdictCacheEntry, found bool) {
+	vc.RLock()
+	entry, found = vc.entries[key]
+	vc.RUnlock()
+
+	if found && entry.Expire > time.Now().Unix() {
+		atomic.AddUint64(&vc.hits, 1)
+		return entry, true
+	}
+
+	atomic.AddUint64(&vc.misses, 1)
+	return verdictCacheEntry{}, false
+}
+
+// Generation must be read before judging from the graph, and given to Set when caching the verdict.
+func (vc *VerdictCache) Generation() uint64 {
+	return atomic.LoadUint64(&vc.generation)
+}
+
+// Set caches the verdict, unless the cache was cleared since generation was read. The verdict might then be judged from a graph that has since changed.
+func (vc *VerdictCache) Set(key string, entry verdictCacheEntry, generation uint64) {
+	now := time.Now().Unix()
+
+	ttl := int64(config.GetInt("judge.cache.ttl"))
+	if entry.Expire <= 0 || entry.Expire > now+ttl {
+		entry.Expire = now + ttl
+	}
+
+	vc.Lock()
+	defer vc.Unlock()
+
+	if atomic.LoadUint64(&vc.generation) != generation {
+		return
+	}
+
+	if len(vc.entries) >= config.GetInt("judge.cache.size") {
+		for k, e := range vc.entries {
+			if e.Expire <= now {
+				delete(vc.entries, k)
+			}
+		}
+
+		// Still full, start over
+		if len(vc.entries) >= config.GetInt("judge.cache.size") {
+			vc.entries = make(map[string]verdictCacheEntry)
+		}
+	}
+
+	vc.entries[key] = entry
+}
+
+func (vc *VerdictCache) Clear() {
+	vc.Lock()
+	vc.entries = make(map[string]verdictCacheEntry)
+	atomic.AddUint64(&vc.generation, 1)
+	vc.Unlock()
+}
+
+func (vc *VerdictCache) Stats() VerdictCacheStats {
+	vc.RLock()
+	entries := len(vc.entries)
+	vc.RUnlock()
+
+	return VerdictCacheStats{
+		Hits:    atomic.LoadUint64(&vc.hits),
+		Misses:  atomic.LoadUint64(&vc.misses),
+		Entries: entries,
+	}
+}
+
+// InvalidateVerdicts must be called after committing changes that can change the outcome of a judgement.
+func InvalidateVerdicts(env *Environment) {
+	env.VerdictCache.Clear()
+
+	err := env.Nats.Publish("aap.verdicts.invalidated", []byte("{}"))
+	if err != nil {
+		// Retried by RebroadcastVerdictInvalidation
+		atomic.StoreUint32(&env.VerdictCache.unbroadcast, 1)
+	}
+}
+
+// RebroadcastVerdictInvalidation publishes the last invalidation again, if it did not reach the other aap instances.
+func RebroadcastVerdictInvalidation(env *Environment) error {
+	if !atomic.CompareAndSwapUint32(&env.VerdictCache.unbroadcast, 1, 0) {
+		return nil
+	}
+
+	err := env.Nats.Publish("aap.verdicts.invalidated", []byte("{}"))
+	if err != nil {
+		atomic.StoreUint32(&env.VerdictCache.unbroadcast, 1)
+		return err
+	}
+	return nil
+}
+
+// SubscribeToVerdictInvalidations clears the cache when any aap instance changed something the judge depends on.
+func SubscribeToVerdictInvalidations(env *Environment) (*nats.Subscription, error) {
+	return env.Nats.Subscribe("aap.verdicts.invalidated", func(m *nats.Msg) {
+		env.VerdictCache.Clear()
+	})
+}
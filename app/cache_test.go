@@ -0,0 +1,103 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/opensentry/aap/gateway/aap"
+)
+
+func newTestVerdictCache() *VerdictCache {
+	viper.Set("judge.cache.ttl", 60)
+	viper.Set("judge.cache.size", 10)
+	return NewVerdictCache()
+}
+
+func TestVerdictCacheSkipsVerdictsJudgedBeforeClear(t *testing.T) {
+	vc := newTestVerdictCache()
+	expire := time.Now().Unix() + 30
+
+	// Judge read the graph, then a change committed and cleared the cache
+	generation := vc.Generation()
+	vc.Clear()
+	vc.Set("stale", verdictCacheEntry{Expire: expire}, generation)
+
+	if _, found := vc.Get("stale"); found {
+		t.Error("verdict judged before the clear must not be cached")
+	}
+
+	vc.Set("fresh", verdictCacheEntry{Expire: expire}, vc.Generation())
+
+	if _, found := vc.Get("fresh"); !found {
+		t.Error("verdict judged after the clear must be cached")
+	}
+}
+
+func TestVerdictCacheExpire(t *testing.T) {
+	vc := newTestVerdictCache()
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name   string
+		expire int64
+		found  bool
+	}{
+		{name: "expired", expire: now - 1, found: false},
+		{name: "unknown expire capped at ttl", expire: 0, found: true},
+		{name: "before ttl", expire: now + 30, found: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vc.Set(tt.name, verdictCacheEntry{Expire: tt.expire}, vc.Generation())
+
+			entry, found := vc.Get(tt.name)
+			if found != tt.found {
+				t.Errorf("found = %v, want %v", found, tt.found)
+			}
+
+			if found && entry.Expire > now+60 {
+				t.Errorf("expire %d outlives ttl", entry.Expire)
+			}
+		})
+	}
+}
+
+func TestVerdictCacheKey(t *testing.T) {
+	publisher := aap.Identity{Id: "publisher-id"}
+	caller := aap.Identity{Id: "caller-id"}
+	scopes := []aap.Scope{{Name: "read"}, {Name: "write"}}
+	owners := []aap.Identity{{Id: "a"}, {Id: "b"}}
+
+	key := verdictCacheKey("token", publisher, scopes, owners, caller)
+
+	if strings.Contains(key, "token") {
+		t.Error("key must not contain the raw access token")
+	}
+
+	tests := []struct {
+		name string
+		key  string
+		same bool
+	}{
+		{name: "same request", key: verdictCacheKey("token", publisher, scopes, owners, caller), same: true},
+		{name: "scopes in other order", key: verdictCacheKey("token", publisher, []aap.Scope{{Name: "write"}, {Name: "read"}}, owners, caller), same: true},
+		{name: "owners in other order", key: verdictCacheKey("token", publisher, scopes, []aap.Identity{{Id: "b"}, {Id: "a"}}, caller), same: true},
+		{name: "other token", key: verdictCacheKey("other", publisher, scopes, owners, caller), same: false},
+		{name: "other publisher", key: verdictCacheKey("token", aap.Identity{Id: "other-id"}, scopes, owners, caller), same: false},
+		{name: "fewer scopes", key: verdictCacheKey("token", publisher, scopes[:1], owners, caller), same: false},
+		{name: "fewer owners", key: verdictCacheKey("token", publisher, scopes, owners[:1], caller), same: false},
+		{name: "other caller", key: verdictCacheKey("token", publisher, scopes, owners, aap.Identity{Id: "other-id"}), same: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if (tt.key == key) != tt.same {
+				t.Errorf("same key = %v, want %v", tt.key == key, tt.same)
+			}
+		})
+	}
+}
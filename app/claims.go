@@ -0,0 +1,110 @@
+package app
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/opensentry/aap/config"
+	"github.com/opensentry/aap/gateway/aap"
+)
+
+// Token claims projecting the grants of the subject, so resource servers only needing coarse checks can decide without calling the judge.
+// Only direct grants are projected, not grants inherited from shadowed identities, so the projection never grants more than the judge.
+const CLAIM_GRANTS = "grants"
+const CLAIM_GRANTS_REV = "grants_rev"             // Revision of the grants of the subject when the token was issued. Tokens with an older revision than announced in aap.grant events are stale
+const CLAIM_GRANTS_TRUNCATED = "grants_truncated" // The grants did not fit the size budget. Use the judge
+
+func IsGrantsClaimEnabled() bool {
+	return config.GetInt("tokens.claims.grants.enabled") == 1
+}
+
+// GrantsClaims projects the valid grants of the subject on the granted scopes by the granted audiences (publishers) into claims.
+// Grants are keyed by publisher and scope, with the owners they are granted on behalf of unless disabled or not fitting the size budget.
+// The scopes alone are used when owners does not fit. If that does not fit either, grants are left out and truncated is claimed.
+func GrantsClaims(env *Environment, iSubject aap.Identity, grantScopes []string, grantAudiences []string) (claims map[string]interface{}, err error) {
+	session, tx, err := aap.BeginReadTx(env.Driver)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Close()
+	defer session.Close()
+
+	rev, err := aap.FetchGrantsRevision(tx, iSubject)
+	if err != nil {
+		return nil, err
+	}
+
+	claims = map[string]interface{}{
+		CLAIM_GRANTS_REV: rev,
+	}
+
+	if len(grantScopes) <= 0 {
+		return claims, nil
+	}
+
+	var iFilterScopes []aap.Scope
+	for _, scope := range grantScopes {
+		iFilterScopes = append(iFilterScopes, aap.Scope{Name: scope})
+	}
+
+	var iFilterPublishers []aap.Identity
+	for _, audience := range grantAudiences {
+		iFilterPublishers = append(iFilterPublishers, aap.Identity{Id: audience})
+	}
+
+	grants, err := aap.FetchGrants(tx, iSubject, iFilterScopes, iFilterPublishers, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return projectGrantsClaims(claims, grants, time.Now().Unix())
+}
+
+// projectGrantsClaims adds the grants valid at now to claims, fitted to the size budget of tokens.claims.grants.budget bytes.
+func projectGrantsClaims(claims map[string]interface{}, grants []aap.Grant, now int64) (map[string]interface{}, error) {
+	withOwners := make(map[string]map[string][]string)
+	withoutOwners := make(map[string][]string)
+	for _, grant := range grants {
+		if grant.GrantRule.NotBefore > now || (grant.GrantRule.Expire > 0 && grant.GrantRule.Expire <= now) {
+			continue
+		}
+
+		publisher := grant.Publisher.Id
+		scope := grant.Scope.Name
+
+		if withOwners[publisher] == nil {
+			withOwners[publisher] = make(map[string][]string)
+		}
+		if _, exists := withOwners[publisher][scope]; !exists {
+			withoutOwners[publisher] = append(withoutOwners[publisher], scope)
+		}
+		withOwners[publisher][scope] = append(withOwners[publisher][scope], grant.OnBehalfOf.Id)
+	}
+
+	if len(withoutOwners) <= 0 {
+		return claims, nil
+	}
+
+	budget := config.GetInt("tokens.claims.grants.budget")
+
+	var projections []interface{}
+	if config.GetInt("tokens.claims.grants.owners") == 1 {
+		projections = append(projections, withOwners)
+	}
+	projections = append(projections, withoutOwners)
+
+	for _, projection := range projections {
+		encoded, err := json.Marshal(projection)
+		if err != nil {
+			return nil, err
+		}
+
+		if budget <= 0 || len(encoded) <= budget {
+			claims[CLAIM_GRANTS] = projection
+			return claims, nil
+		}
+	}
+
+	claims[CLAIM_GRANTS_TRUNCATED] = true
+	return claims, nil
+}
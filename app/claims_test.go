@@ -0,0 +1,71 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/opensentry/aap/gateway/aap"
+)
+
+func TestProjectGrantsClaims(t *testing.T) {
+	defer viper.Reset()
+
+	now := int64(1000)
+	grant := func(publisher string, scope string, onBehalfOf string, rule aap.GrantRule) aap.Grant {
+		return aap.Grant{Publisher: aap.Identity{Id: publisher}, Scope: aap.Scope{Name: scope}, OnBehalfOf: aap.Identity{Id: onBehalfOf}, GrantRule: rule}
+	}
+
+	grants := []aap.Grant{
+		grant("p", "read", "a", aap.GrantRule{NotBefore: 1}),
+		grant("p", "read", "b", aap.GrantRule{NotBefore: 1, Expire: 2000}),
+		grant("p", "write", "a", aap.GrantRule{NotBefore: 1}),
+		grant("p", "delete", "a", aap.GrantRule{NotBefore: 2000}),           // Not yet valid
+		grant("p", "admin", "a", aap.GrantRule{NotBefore: 1, Expire: 1000}), // Expired
+	}
+
+	withOwners := map[string]map[string][]string{"p": {"read": {"a", "b"}, "write": {"a"}}}
+	withoutOwners := map[string][]string{"p": {"read", "write"}}
+
+	tests := []struct {
+		name          string
+		grants        []aap.Grant
+		owners        int
+		budget        int
+		wantGrants    interface{}
+		wantTruncated bool
+	}{
+		{name: "no valid grants", grants: grants[3:], owners: 1},
+		{name: "with owners", grants: grants, owners: 1, wantGrants: withOwners},
+		{name: "owners disabled", grants: grants, owners: 0, wantGrants: withoutOwners},
+		{name: "owners do not fit", grants: grants, owners: 1, budget: 30, wantGrants: withoutOwners}, // {"p":["read","write"]} is 22 bytes
+		{name: "owners fit exactly", grants: grants, owners: 1, budget: 38, wantGrants: withOwners},   // {"p":{"read":["a","b"],"write":["a"]}} is 38 bytes
+		{name: "scopes fit exactly", grants: grants, owners: 1, budget: 22, wantGrants: withoutOwners},
+		{name: "nothing fits", grants: grants, owners: 1, budget: 21, wantTruncated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("tokens.claims.grants.owners", tt.owners)
+			viper.Set("tokens.claims.grants.budget", tt.budget)
+
+			claims, err := projectGrantsClaims(map[string]interface{}{CLAIM_GRANTS_REV: int64(3)}, tt.grants, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claims[CLAIM_GRANTS_REV] != int64(3) {
+				t.Errorf("grants_rev = %v, want 3", claims[CLAIM_GRANTS_REV])
+			}
+
+			if got, exists := claims[CLAIM_GRANTS]; exists != (tt.wantGrants != nil) || (exists && !reflect.DeepEqual(got, tt.wantGrants)) {
+				t.Errorf("grants = %v, want %v", got, tt.wantGrants)
+			}
+
+			if truncated := claims[CLAIM_GRANTS_TRUNCATED] == true; truncated != tt.wantTruncated {
+				t.Errorf("truncated = %v, want %v", truncated, tt.wantTruncated)
+			}
+		})
+	}
+}
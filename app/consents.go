@@ -0,0 +1,116 @@
+package app
+
+import (
+	"github.com/sirupsen/logrus"
+	"time"
+
+	"github.com/opensentry/aap/config"
+	"github.com/opensentry/aap/gateway/aap"
+)
+
+// ConsentLifetime is the default lifetime in seconds of consents to scopes published by the publisher. 0 = never expires.
+func ConsentLifetime(publisher string) int64 {
+	key := "consents.publishers." + publisher + ".lifetime"
+	if publisher != "" && config.IsSet(key) {
+		return int64(config.GetInt(key))
+	}
+	return int64(config.GetInt("consents.lifetime"))
+}
+
+// ConsentExpire returns exp, or the default expire of consents to the publisher when exp is not given.
+func ConsentExpire(publisher string, nbf int64, exp int64) int64 {
+	if exp > 0 {
+		return exp
+	}
+
+	lifetime := ConsentLifetime(publisher)
+	if lifetime <= 0 {
+		return 0
+	}
+
+	from := time.Now().Unix()
+	if nbf > from {
+		from = nbf
+	}
+	return from + lifetime
+}
+
+// RunConsentSweeper deletes expired consents until stop is closed and emits an event for each of them.
+func RunConsentSweeper(env *Environment, log *logrus.Entry, stop <-chan struct{}) {
+	interval := time.Duration(config.GetInt("consents.sweeper.interval")) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			swept, err := sweepExpiredConsents(env)
+			if err != nil {
+				log.Debug(err.Error())
+				break
+			}
+
+			if swept > 0 {
+				log.WithFields(logrus.Fields{"consents": swept}).Debug("Swept expired consents")
+			}
+
+			if swept < int64(config.GetInt("consents.sweeper.batch")) {
+				break
+			}
+		}
+	}
+}
+
+func sweepExpiredConsents(env *Environment) (swept int64, err error) {
+	session, tx, err := aap.BeginWriteTx(env.Driver)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	consents, err := aap.DeleteExpiredConsents(tx, int64(config.GetInt("consents.sweeper.batch")))
+	if err != nil {
+		return 0, err
+	}
+
+	if len(consents) <= 0 {
+		return 0, nil
+	}
+
+	var events []aap.Event
+	for _, consent := range consents {
+		events = append(events, NewSystemEvent(aap.EVENT_CONSENT_EXPIRED, aap.EventConsent{
+			Identity:   consent.Identity.Id,
+			Subscriber: consent.Subscriber.Id,
+			Publisher:  consent.Publisher.Id,
+			Scope:      consent.Scope.Name,
+			NotBefore:  consent.ConsentRule.NotBefore,
+			Expire:     consent.ConsentRule.Expire,
+			Automatic:  consent.ConsentRule.Automatic,
+		}))
+	}
+
+	err = StageEvents(tx, events)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	InvalidateVerdicts(env)
+	env.Outbox.Notify()
+	return int64(len(consents)), nil
+}
@@ -35,6 +35,11 @@ type Environment struct {
 	Driver          neo4j.Driver
 	Constants       *EnvironmentConstants
 	Nats            *nats.Conn
+	Revocations     *RevocationList
+	VerdictCache    *VerdictCache
+	Outbox          *OutboxRelay
+	Webhooks        *WebhookDispatcher
+	Usage           *UsageTracker
 }
 
 func ProcessMethodOverride(r *gin.Engine) gin.HandlerFunc {
@@ -0,0 +1,190 @@
+package app
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+	"github.com/sirupsen/logrus"
+	"time"
+
+	"github.com/opensentry/aap/config"
+	"github.com/opensentry/aap/gateway/aap"
+)
+
+// NewEvent wraps data in the event envelope. The actor and request id are taken from the request.
+func NewEvent(env *Environment, c *gin.Context, eventType string, data interface{}) aap.Event {
+	event := NewSystemEvent(eventType, data)
+
+	if sub, exists := c.Get("sub"); exists {
+		event.Actor = sub.(string)
+	}
+	event.RequestId = c.GetString(env.Constants.RequestIdKey)
+
+	return event
+}
+
+// NewSystemEvent wraps data in the event envelope, for changes made by aap itself and not caused by a request.
+func NewSystemEvent(eventType string, data interface{}) aap.Event {
+	uuid4, _ := uuid.NewV4()
+
+	return aap.Event{
+		Id:        uuid4.String(),
+		Type:      eventType,
+		Version:   aap.EVENT_SCHEMA_VERSION,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	}
+}
+
+// StageEvents writes events to the outbox using the transaction of the change. Events of rolled back changes are never published.
+// Webhook deliveries are queued in the same transaction, so no event is lost between the outbox and the webhooks.
+func StageEvents(tx neo4j.Transaction, events []aap.Event) error {
+	if len(events) <= 0 {
+		return nil
+	}
+
+	webhooks, err := aap.FetchWebhooks(tx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		outboxEvent, err := aap.CreateOutboxEvent(tx, event)
+		if err != nil {
+			return err
+		}
+
+		err = queueWebhookDeliveries(tx, webhooks, event, outboxEvent)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Publishes outbox events on nats with at-least-once delivery. Consumers must deduplicate on the event id.
+type OutboxRelay struct {
+	notify chan struct{}
+}
+
+func NewOutboxRelay() *OutboxRelay {
+	return &OutboxRelay{notify: make(chan struct{}, 1)}
+}
+
+// Notify wakes the relay up, call it after committing staged events.
+func (o *OutboxRelay) Notify() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+		// Already notified
+	}
+}
+
+// Run relays until stop is closed. Polls every outbox.relay.interval seconds, in case a notification was missed or an attempt failed.
+func (o *OutboxRelay) Run(env *Environment, log *logrus.Entry, stop <-chan struct{}) {
+	interval := time.Duration(config.GetInt("outbox.relay.interval")) * time.Second
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-o.notify:
+			// Staged events may have queued webhook deliveries
+			env.Webhooks.Notify()
+		}
+
+		err := RebroadcastVerdictInvalidation(env)
+		if err != nil {
+			log.Debug(err.Error())
+		}
+
+		for {
+			relayed, err := relayOutboxEvents(env)
+			if err != nil {
+				log.Debug(err.Error())
+				break
+			}
+
+			// Outbox drained
+			if relayed < int64(config.GetInt("outbox.relay.batch")) {
+				break
+			}
+		}
+	}
+}
+
+func relayOutboxEvents(env *Environment) (relayed int64, err error) {
+	session, tx, err := aap.BeginWriteTx(env.Driver)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	events, err := aap.FetchPendingOutboxEvents(tx, int64(config.GetInt("outbox.relay.batch")))
+	if err != nil {
+		return 0, err
+	}
+
+	if len(events) <= 0 {
+		return 0, nil
+	}
+
+	var published []aap.OutboxEvent
+	for _, event := range events {
+		err := env.Nats.Publish(event.Type, []byte(event.Payload))
+		if err != nil {
+			err = rescheduleOutboxEvent(tx, event, err)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		published = append(published, event)
+	}
+
+	// Only when nats acknowledged receiving the events they can leave the outbox
+	err = env.Nats.FlushTimeout(time.Duration(config.GetInt("outbox.relay.timeout")) * time.Second)
+	for _, event := range published {
+		if err != nil {
+			e := rescheduleOutboxEvent(tx, event, err)
+			if e != nil {
+				return 0, e
+			}
+			continue
+		}
+
+		e := aap.DeleteOutboxEvent(tx, event)
+		if e != nil {
+			return 0, e
+		}
+	}
+
+	if err != nil {
+		// Events are rescheduled, wait for the next attempt.
+		return 0, tx.Commit()
+	}
+
+	return int64(len(events)), tx.Commit()
+}
+
+// Exponential backoff, capped at outbox.relay.backoff.max seconds
+func rescheduleOutboxEvent(tx neo4j.Transaction, event aap.OutboxEvent, cause error) error {
+	backoff := int64(config.GetInt("outbox.relay.backoff.min"))
+	max := int64(config.GetInt("outbox.relay.backoff.max"))
+	for i := int64(0); i < event.Attempts && backoff < max; i++ {
+		backoff = backoff * 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+
+	return aap.RescheduleOutboxEvent(tx, event, time.Now().Unix()+backoff, cause.Error())
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+
+	"github.com/opensentry/aap/gateway/aap"
+)
+
+// Structured trace of a judge verdict
+type Explanation struct {
+	Reason        string
+	Introspection ExplanationIntrospection
+	Scopes        []ExplanationScope
+	Owners        []aap.Identity // Owners of the accepted grants
+	MissingScopes []aap.Scope
+}
+
+type ExplanationIntrospection struct {
+	Active  bool
+	Subject aap.Identity
+	Client  aap.Identity
+	Reason  string
+}
+
+type ExplanationScope struct {
+	Scope     aap.Scope
+	Published bool
+	Granted   bool
+	Grants    []ExplanationGrant
+}
+
+type ExplanationGrant struct {
+	Path      []aap.Identity
+	Owner     aap.Identity
+	GrantRule aap.GrantRule
+	Accepted  bool
+	Rejected  string // Why the grant did not satisfy the scope
+}
+
+const (
+	REJECTED_NOT_YET_VALID       = "not_yet_valid"
+	REJECTED_EXPIRED             = "expired"
+	REJECTED_SHADOW_NOT_VALID    = "shadow_not_valid"
+	REJECTED_OWNER_NOT_REQUESTED = "owner_not_requested"
+)
+
+// Explain traces the verdict returned by Judge. Judge decides, Explain only describes the decision.
+func Explain(tx neo4j.Transaction, judgeVerdict JudgeVerdict, iPublisher aap.Identity, iScopes []aap.Scope, iOwners []aap.Identity) (explanation Explanation, err error) {
+
+	explanation.Reason = judgeVerdict.Reason
+	explanation.Introspection = ExplanationIntrospection{
+		Active:  judgeVerdict.Introspection.Subject.Id != "",
+		Subject: judgeVerdict.Introspection.Subject,
+		Client:  judgeVerdict.Introspection.Client,
+	}
+
+	if !explanation.Introspection.Active {
+		explanation.Introspection.Reason = judgeVerdict.Reason
+		explanation.MissingScopes = iScopes
+		return explanation, nil
+	}
+
+	// Judge owners defaults to the requestor and always includes the publisher
+	if len(iOwners) <= 0 {
+		iOwners = []aap.Identity{judgeVerdict.Introspection.Subject}
+	}
+	iOwners = append(iOwners, iPublisher)
+
+	requestedOwners := make(map[string]bool)
+	for _, owner := range iOwners {
+		requestedOwners[owner.Id] = true
+	}
+
+	traces, err := aap.TraceJudge(tx, iPublisher, judgeVerdict.Introspection.Subject, iScopes)
+	if err != nil {
+		return Explanation{}, err
+	}
+
+	var scopes []ExplanationScope
+	scopeIndex := make(map[string]int)
+	seenOwners := make(map[string]bool)
+
+	for _, trace := range traces {
+		i, exists := scopeIndex[trace.Scope.Name]
+		if !exists {
+			scopes = append(scopes, ExplanationScope{Scope: trace.Scope, Published: trace.Published})
+			i = len(scopes) - 1
+			scopeIndex[trace.Scope.Name] = i
+		}
+
+		// No grant found for the scope
+		if trace.Owner.Id == "" {
+			continue
+		}
+
+		grant := explainGrant(trace, requestedOwners)
+		if grant.Accepted {
+			scopes[i].Granted = true
+
+			if !seenOwners[trace.Owner.Id] {
+				seenOwners[trace.Owner.Id] = true
+				explanation.Owners = append(explanation.Owners, trace.Owner)
+			}
+		}
+
+		scopes[i].Grants = append(scopes[i].Grants, grant)
+	}
+
+	for _, scope := range scopes {
+		if !scope.Granted {
+			explanation.MissingScopes = append(explanation.MissingScopes, scope.Scope)
+		}
+	}
+
+	explanation.Scopes = scopes
+	return explanation, nil
+}
+
+// explainGrant tells if the traced grant satisfies its scope, and if not why. Mirrors the filters of Judge.
+func explainGrant(trace aap.VerdictTrace, requestedOwners map[string]bool) (grant ExplanationGrant) {
+	grant = ExplanationGrant{
+		Path:      trace.Path,
+		Owner:     trace.Owner,
+		GrantRule: trace.GrantRule,
+	}
+
+	if trace.GrantRule.NotBefore > trace.Now {
+		grant.Rejected = REJECTED_NOT_YET_VALID
+	} else if trace.GrantRule.Expire != 0 && trace.GrantRule.Expire <= trace.Now {
+		grant.Rejected = REJECTED_EXPIRED
+	} else if !trace.ShadowsValid {
+		grant.Rejected = REJECTED_SHADOW_NOT_VALID
+	} else if !requestedOwners[trace.Owner.Id] {
+		grant.Rejected = REJECTED_OWNER_NOT_REQUESTED
+	} else {
+		grant.Accepted = true
+	}
+
+	return grant
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/opensentry/aap/gateway/aap"
+)
+
+func TestExplainGrant(t *testing.T) {
+	requestedOwners := map[string]bool{"owner-id": true}
+	owner := aap.Identity{Id: "owner-id"}
+
+	tests := []struct {
+		name         string
+		trace        aap.VerdictTrace
+		wantAccepted bool
+		wantRejected string
+	}{
+		{name: "valid", trace: aap.VerdictTrace{Owner: owner, GrantRule: aap.GrantRule{NotBefore: 50}, ShadowsValid: true, Now: 100}, wantAccepted: true},
+		{name: "valid until", trace: aap.VerdictTrace{Owner: owner, GrantRule: aap.GrantRule{NotBefore: 50, Expire: 101}, ShadowsValid: true, Now: 100}, wantAccepted: true},
+		{name: "not yet valid", trace: aap.VerdictTrace{Owner: owner, GrantRule: aap.GrantRule{NotBefore: 101}, ShadowsValid: true, Now: 100}, wantRejected: REJECTED_NOT_YET_VALID},
+		{name: "expired", trace: aap.VerdictTrace{Owner: owner, GrantRule: aap.GrantRule{Expire: 100}, ShadowsValid: true, Now: 100}, wantRejected: REJECTED_EXPIRED},
+		{name: "shadow not valid", trace: aap.VerdictTrace{Owner: owner, ShadowsValid: false, Now: 100}, wantRejected: REJECTED_SHADOW_NOT_VALID},
+		{name: "owner not requested", trace: aap.VerdictTrace{Owner: aap.Identity{Id: "other-id"}, ShadowsValid: true, Now: 100}, wantRejected: REJECTED_OWNER_NOT_REQUESTED},
+		{name: "expired wins over owner", trace: aap.VerdictTrace{Owner: aap.Identity{Id: "other-id"}, GrantRule: aap.GrantRule{Expire: 1}, ShadowsValid: true, Now: 100}, wantRejected: REJECTED_EXPIRED},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grant := explainGrant(tt.trace, requestedOwners)
+
+			if grant.Accepted != tt.wantAccepted {
+				t.Errorf("accepted = %v, want %v", grant.Accepted, tt.wantAccepted)
+			}
+
+			if grant.Rejected != tt.wantRejected {
+				t.Errorf("rejected = %s, want %s", grant.Rejected, tt.wantRejected)
+			}
+		})
+	}
+}
+
+func TestExplainInactiveToken(t *testing.T) {
+	scopes := []aap.Scope{{Name: "read"}}
+
+	// Inactive tokens are explained without tracing grants
+	explanation, err := Explain(nil, JudgeVerdict{Reason: "Token not active"}, aap.Identity{Id: "publisher-id"}, scopes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if explanation.Introspection.Active {
+		t.Error("introspection active, want inactive")
+	}
+
+	if explanation.Introspection.Reason != "Token not active" {
+		t.Errorf("reason = %s, want Token not active", explanation.Introspection.Reason)
+	}
+
+	if len(explanation.MissingScopes) != 1 || explanation.MissingScopes[0].Name != "read" {
+		t.Errorf("missing scopes = %v, want [read]", explanation.MissingScopes)
+	}
+}
@@ -5,10 +5,13 @@ import (
 	"github.com/neo4j/neo4j-go-driver/neo4j"
 	"golang.org/x/oauth2"
 	"strings"
+	"time"
 
 	hydra "github.com/charmixer/hydra/client"
 
+	"github.com/opensentry/aap/config"
 	"github.com/opensentry/aap/gateway/aap"
+	"github.com/opensentry/aap/utils"
 )
 
 type Introspection struct {
@@ -21,69 +24,168 @@ type JudgeVerdict struct {
 	Introspection Introspection
 	Verdict       aap.Verdict
 	Reason        string
+	Error         string              // Machine readable reason, eg. step_up_required
+	StepUps       []aap.VerdictStepUp // Step-up authentication not satisfied by the token
+}
+
+// The token is granted the scopes, but the subject must authenticate again as required by the publisher
+const REASON_STEP_UP_REQUIRED = "step_up_required"
+
+// What to judge. Empty Caller and Owners defaults to the subject of the access token.
+type JudgeRequest struct {
+	Token     *oauth2.Token
+	Publisher aap.Identity
+	Scopes    []aap.Scope
+	Owners    []aap.Identity
+	Caller    aap.Identity
+}
+
+// The outcome of introspecting an access token once, shared by all judge requests using the token
+type tokenIntrospection struct {
+	Active    bool
+	Reason    string // Reason to deny regardless of scopes
+	Scopes    []string
+	Audiences []string
+	Token     RevokableToken
+	Acr       string
+	Amr       []string
+	AuthTime  int64
+	Expire    int64
 }
 
 func denyWithReason(msg string, introspection Introspection) (deny JudgeVerdict) {
 	return JudgeVerdict{Introspection: introspection, Reason: msg, Verdict: aap.Verdict{}}
 }
 
-func Judge(tx neo4j.Transaction, token *oauth2.Token, iPublisher aap.Identity, iScopes []aap.Scope, iOwners []aap.Identity, iCaller aap.Identity, hydraClient *hydra.HydraClient, introspectTokenUrl string) (judgeVerdict JudgeVerdict, err error) {
-
-	isCallerSameAsRequestor := false
-	if iCaller.Id == "" {
-		isCallerSameAsRequestor = true
+func Judge(env *Environment, tx neo4j.Transaction, token *oauth2.Token, iPublisher aap.Identity, iScopes []aap.Scope, iOwners []aap.Identity, iCaller aap.Identity, hydraClient *hydra.HydraClient) (judgeVerdict JudgeVerdict, err error) {
+	judgeVerdicts, err := JudgeMany(env, tx, []JudgeRequest{
+		{Token: token, Publisher: iPublisher, Scopes: iScopes, Owners: iOwners, Caller: iCaller},
+	}, hydraClient)
+	if err != nil {
+		return JudgeVerdict{}, err
 	}
 
-	isOwnersSameAsRequestor := false
-	if len(iOwners) <= 0 {
-		isOwnersSameAsRequestor = true
+	return judgeVerdicts[0], nil
+}
+
+// JudgeMany introspects each distinct access token once and judges all requests passing introspection in one database round trip.
+// Verdicts are returned in the order of the requests.
+func JudgeMany(env *Environment, tx neo4j.Transaction, iRequests []JudgeRequest, hydraClient *hydra.HydraClient) (rJudgeVerdicts []JudgeVerdict, err error) {
+	rJudgeVerdicts = make([]JudgeVerdict, len(iRequests))
+
+	isCacheEnabled := IsVerdictCacheEnabled()
+	generation := env.VerdictCache.Generation()
+	keys := make([]string, len(iRequests))
+
+	var pending []int
+	for index, r := range iRequests {
+		if isCacheEnabled {
+			keys[index] = verdictCacheKey(r.Token.AccessToken, r.Publisher, r.Scopes, r.Owners, r.Caller)
+
+			entry, found := env.VerdictCache.Get(keys[index])
+			if found {
+				// See #5 of QTNA. The token might have been revoked after the verdict was cached.
+				if env.Revocations.IsRevoked(entry.Token) {
+					rJudgeVerdicts[index] = denyWithReason("Access token revoked", Introspection{})
+					continue
+				}
+				rJudgeVerdicts[index] = entry.JudgeVerdict
+				continue
+			}
+		}
+
+		pending = append(pending, index)
 	}
 
-	var _s []string
-	for _, iScope := range iScopes {
-		if iScope.Name != "" {
-			_s = append(_s, iScope.Name)
+	// Perform introspection (or local verification) once per access token
+	introspections := make(map[string]tokenIntrospection)
+	for _, index := range pending {
+		rawToken := iRequests[index].Token.AccessToken
+		if _, exists := introspections[rawToken]; exists {
+			continue
+		}
+
+		ti, err := introspect(env, hydraClient, rawToken)
+		if err != nil {
+			return nil, err
 		}
+		introspections[rawToken] = ti
 	}
-	scopes := strings.Join(_s, " ")
 
-	// Perform introspection using the access token
-	introspectRequest := hydra.IntrospectRequest{
-		Token: token.AccessToken,
-		Scope: scopes,
+	// Resolve the audiences of all tokens judged with audience checks in one round trip
+	var audiences []string
+	for _, index := range pending {
+		r := iRequests[index]
+		if IsAudienceRelaxed(r.Publisher.Id) {
+			continue
+		}
+
+		for _, aud := range introspections[r.Token.AccessToken].Audiences {
+			if !utils.StringInSlice(aud, audiences) {
+				audiences = append(audiences, aud)
+			}
+		}
 	}
-	introspectResponse, err := hydra.IntrospectToken(introspectTokenUrl, hydraClient, introspectRequest)
+
+	resourceServers, err := aap.FetchResourceServersByAudiences(tx, audiences)
 	if err != nil {
-		return JudgeVerdict{}, err
+		return nil, err
 	}
 
-	// Check scopes. (is done by hydra according to doc)
-	// https://www.ory.sh/docs/hydra/sdk/api#introspect-oauth2-tokens
-	if introspectResponse.Active == true {
+	var queries []aap.JudgeQuery
+	var queryIndexes []int
+	judgeIntrospections := make(map[int]Introspection)
+
+	for _, index := range pending {
+		r := iRequests[index]
+		ti := introspections[r.Token.AccessToken]
+
+		var _s []string
+		for _, iScope := range r.Scopes {
+			if iScope.Name != "" {
+				_s = append(_s, iScope.Name)
+			}
+		}
+		scopes := strings.Join(_s, " ")
+
+		// Check scopes. Hydra only answers active if the token is granted all requested scopes, so do the same.
+		// https://www.ory.sh/docs/hydra/sdk/api#introspect-oauth2-tokens
+		var missingScopes bool
+		for _, scope := range _s {
+			if !utils.StringInSlice(scope, ti.Scopes) {
+				missingScopes = true
+			}
+		}
 
-		if introspectResponse.TokenType != "access_token" {
-			return denyWithReason("Invalid token. Hint: Token is not an access_token", Introspection{}), nil
+		if ti.Active == false || missingScopes {
+			rJudgeVerdicts[index] = denyWithReason(fmt.Sprintf("Missing required scopes. Hint: Access token is missing required oauth2 scopes: %s", scopes), Introspection{})
+			continue
 		}
 
-		if introspectResponse.Sub == "" {
-			return denyWithReason("Missing token sub", Introspection{}), nil
+		if ti.Reason != "" {
+			rJudgeVerdicts[index] = denyWithReason(ti.Reason, Introspection{})
+			continue
 		}
-		requestorId := introspectResponse.Sub
 
-		if introspectResponse.ClientId == "" {
-			return denyWithReason("Missing token client_id", Introspection{}), nil
+		// A token issued for one resource server must not be accepted by another
+		if !IsAudienceRelaxed(r.Publisher.Id) {
+			if !acceptsAudience(ti.Audiences, r.Publisher, resourceServers) {
+				rJudgeVerdicts[index] = denyWithReason(fmt.Sprintf("Invalid audience. Hint: Access token was not issued for the publisher: %s", r.Publisher.Id), Introspection{})
+				continue
+			}
 		}
-		clientId := introspectResponse.ClientId
 
-		iClient := aap.Identity{Id: clientId}
-		iRequestor := aap.Identity{Id: requestorId}
+		iClient := aap.Identity{Id: ti.Token.ClientId}
+		iRequestor := aap.Identity{Id: ti.Token.Subject}
 
-		if isCallerSameAsRequestor == true {
+		iCaller := r.Caller
+		if iCaller.Id == "" {
 			iCaller = iRequestor
 		}
 
-		if isOwnersSameAsRequestor == true {
-			iOwners = append(iOwners, iRequestor)
+		iOwners := r.Owners
+		if len(iOwners) <= 0 {
+			iOwners = []aap.Identity{iRequestor}
 		}
 
 		introspection := Introspection{Subject: iRequestor, Client: iClient, Caller: iCaller}
@@ -92,34 +194,202 @@ func Judge(tx neo4j.Transaction, token *oauth2.Token, iPublisher aap.Identity, i
 		if scopes == "" {
 			// Token authenticated, return subject!
 			verdictAuthenticated := aap.Verdict{
-				Publisher:       iPublisher,
+				Publisher:       r.Publisher,
 				Requestor:       iRequestor,
-				RequestedScopes: iScopes,
-				GrantedScopes:   iScopes,
+				RequestedScopes: r.Scopes,
+				GrantedScopes:   r.Scopes,
 				MissingScopes:   []aap.Scope{},
 				Owners:          iOwners,
 				Granted:         true,
 			}
-			return JudgeVerdict{Introspection: introspection, Verdict: verdictAuthenticated}, nil
+			rJudgeVerdicts[index] = JudgeVerdict{Introspection: introspection, Verdict: verdictAuthenticated}
+			if isCacheEnabled && ti.Expire > 0 {
+				env.VerdictCache.Set(keys[index], verdictCacheEntry{JudgeVerdict: rJudgeVerdicts[index], Token: ti.Token, Expire: ti.Expire}, generation)
+			}
+			continue
 		}
 
-		verdict, err := aap.Judge(tx, iPublisher, iRequestor, iScopes, iOwners)
-		if err != nil {
-			return denyWithReason("Server error occurred", introspection), err
-		}
+		queries = append(queries, aap.JudgeQuery{Publisher: r.Publisher, Requestor: iRequestor, Scopes: r.Scopes, Owners: iOwners, Client: iClient})
+		queryIndexes = append(queryIndexes, index)
+		judgeIntrospections[index] = introspection
+	}
+
+	if len(queries) <= 0 {
+		trackUsage(env, rJudgeVerdicts)
+		return rJudgeVerdicts, nil
+	}
+
+	verdicts, err := aap.JudgeMany(tx, queries)
+	if err != nil {
+		return nil, err
+	}
+
+	for q, verdict := range verdicts {
+		index := queryIndexes[q]
+		introspection := judgeIntrospections[index]
+
+		ti := introspections[iRequests[index].Token.AccessToken]
+		expire := ti.Expire
 
 		if verdict.Granted == true {
-			// Authorized!
-			return JudgeVerdict{Introspection: introspection, Verdict: verdict}, nil
+			unsatisfied := unsatisfiedStepUps(verdict.StepUps, ti, time.Now().Unix())
+			if len(unsatisfied) > 0 {
+				verdict.Granted = false
+				rJudgeVerdicts[index] = JudgeVerdict{Introspection: introspection, Verdict: verdict, Error: REASON_STEP_UP_REQUIRED, StepUps: unsatisfied, Reason: fmt.Sprintf("Step-up required. Hint: Subject must authenticate again to use scopes: %s", joinStepUpScopes(unsatisfied))}
+			} else {
+				// Authorized!
+				rJudgeVerdicts[index] = JudgeVerdict{Introspection: introspection, Verdict: verdict}
+
+				// The verdict must not outlive the consents it depends on
+				if verdict.ConsentsExpire > 0 && expire > 0 && verdict.ConsentsExpire < expire {
+					expire = verdict.ConsentsExpire
+				}
+
+				// The verdict must not outlive the max age of the authentication
+				for _, stepUp := range verdict.StepUps {
+					if stepUp.MaxAge > 0 && expire > 0 && ti.AuthTime+stepUp.MaxAge < expire {
+						expire = ti.AuthTime + stepUp.MaxAge
+					}
+				}
+			}
+		} else if len(verdict.MissingSubscriptions) > 0 {
+			rJudgeVerdicts[index] = JudgeVerdict{Introspection: introspection, Verdict: verdict, Reason: fmt.Sprintf("Missing subscriptions. Hint: Client is not subscribed to required scopes at the publisher: %s", joinScopes(verdict.MissingSubscriptions))}
+		} else if len(verdict.MissingConsents) > 0 {
+			rJudgeVerdicts[index] = JudgeVerdict{Introspection: introspection, Verdict: verdict, Reason: fmt.Sprintf("Missing consents. Hint: Subject has not consented to the client using required scopes: %s", joinScopes(verdict.MissingConsents))}
+		} else {
+			var _missingScopes []string
+			for _, scope := range verdict.MissingScopes {
+				_missingScopes = append(_missingScopes, scope.Name)
+			}
+
+			rJudgeVerdicts[index] = denyWithReason(fmt.Sprintf("Missing grants. Hint: Access token is missing required grants: %s", strings.Join(_missingScopes, " ")), introspection)
+		}
+
+		// Only verdicts of active tokens are cached, since only they have a known expire.
+		if isCacheEnabled && expire > 0 {
+			env.VerdictCache.Set(keys[index], verdictCacheEntry{JudgeVerdict: rJudgeVerdicts[index], Token: ti.Token, Expire: expire}, generation)
 		}
+	}
+
+	trackUsage(env, rJudgeVerdicts)
+	return rJudgeVerdicts, nil
+}
+
+// introspect answers QTNA #2, #3 (scopes are checked per request) and #5 for an access token.
+func introspect(env *Environment, hydraClient *hydra.HydraClient, rawToken string) (ti tokenIntrospection, err error) {
+	introspectResponse, err := introspectAccessToken(env, hydraClient, rawToken, "")
+	if err != nil {
+		return tokenIntrospection{}, err
+	}
+
+	if introspectResponse.Active == false {
+		return tokenIntrospection{Active: false}, nil
+	}
+
+	ti = tokenIntrospection{
+		Active:    true,
+		Scopes:    strings.Split(introspectResponse.Scope, " "),
+		Audiences: introspectResponse.Aud,
+		Acr:       introspectResponse.Ext.Acr,
+		Amr:       introspectResponse.Ext.Amr,
+		AuthTime:  introspectResponse.Ext.AuthTime,
+	}
+
+	if introspectResponse.TokenType != "access_token" {
+		ti.Reason = "Invalid token. Hint: Token is not an access_token"
+		return ti, nil
+	}
+
+	if introspectResponse.Sub == "" {
+		ti.Reason = "Missing token sub"
+		return ti, nil
+	}
+
+	if introspectResponse.ClientId == "" {
+		ti.Reason = "Missing token client_id"
+		return ti, nil
+	}
 
-		var _missingScopes []string
-		for _, scope := range verdict.MissingScopes {
-			_missingScopes = append(_missingScopes, scope.Name)
+	// See #5 of QTNA. Checked here as well, since the token might never have passed AuthenticationRequired.
+	ti.Token = RevokableToken{Subject: introspectResponse.Sub, ClientId: introspectResponse.ClientId, Scopes: ti.Scopes, IssuedAt: introspectResponse.Iat}
+	if IsJwtAccessToken(rawToken) {
+		decodedToken, err := decodeRevokableToken(rawToken)
+		if err == nil {
+			ti.Token.Jti = decodedToken.Jti
 		}
+	}
+	if env.Revocations.IsRevoked(ti.Token) {
+		ti.Reason = "Access token revoked"
+		return ti, nil
+	}
 
-		return denyWithReason(fmt.Sprintf("Missing grants. Hint: Access token is missing required grants: %s", strings.Join(_missingScopes, " ")), introspection), nil
+	ti.Expire = introspectResponse.Exp
+	return ti, nil
+}
+
+// IsAudienceRelaxed is true if tokens are judged for the publisher regardless of their aud. Override per publisher with judge.publishers.<publisher id>.audience.relax
+func IsAudienceRelaxed(publisher string) bool {
+	key := "judge.publishers." + publisher + ".audience.relax"
+	if publisher != "" && config.IsSet(key) {
+		return config.GetInt(key) == 1
 	}
+	return config.GetInt("judge.audience.relax") == 1
+}
 
-	return denyWithReason(fmt.Sprintf("Missing required scopes. Hint: Access token is missing required oauth2 scopes: %s", scopes), Introspection{}), nil
+// acceptsAudience is true if the publisher is one of the token audiences, either by id or by the aud of the publisher as resource server.
+func acceptsAudience(audiences []string, iPublisher aap.Identity, resourceServers map[string]aap.ResourceServer) bool {
+	for _, aud := range audiences {
+		if aud == iPublisher.Id {
+			return true
+		}
+
+		if rs, exists := resourceServers[aud]; exists && rs.Id == iPublisher.Id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unsatisfiedStepUps returns the step-ups not satisfied by the authentication of the subject, as claimed by the token.
+// Acr must be any of the accepted, all amr must be present and the subject must have authenticated within max age.
+func unsatisfiedStepUps(stepUps []aap.VerdictStepUp, ti tokenIntrospection, now int64) (unsatisfied []aap.VerdictStepUp) {
+	for _, stepUp := range stepUps {
+		satisfied := true
+
+		if len(stepUp.Acr) > 0 && !utils.StringInSlice(ti.Acr, stepUp.Acr) {
+			satisfied = false
+		}
+
+		for _, amr := range stepUp.Amr {
+			if !utils.StringInSlice(amr, ti.Amr) {
+				satisfied = false
+			}
+		}
+
+		if stepUp.MaxAge > 0 && (ti.AuthTime <= 0 || now-ti.AuthTime > stepUp.MaxAge) {
+			satisfied = false
+		}
+
+		if !satisfied {
+			unsatisfied = append(unsatisfied, stepUp)
+		}
+	}
+	return unsatisfied
+}
+
+func joinStepUpScopes(stepUps []aap.VerdictStepUp) string {
+	var scopes []aap.Scope
+	for _, stepUp := range stepUps {
+		scopes = append(scopes, stepUp.Scope)
+	}
+	return joinScopes(scopes)
+}
+
+func joinScopes(scopes []aap.Scope) string {
+	var names []string
+	for _, scope := range scopes {
+		names = append(names, scope.Name)
+	}
+	return strings.Join(names, " ")
 }
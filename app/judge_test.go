@@ -0,0 +1,113 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/opensentry/aap/gateway/aap"
+)
+
+func TestIsAudienceRelaxed(t *testing.T) {
+	tests := []struct {
+		name      string
+		global    interface{}
+		publisher interface{}
+		want      bool
+	}{
+		{name: "enforced by default", want: false},
+		{name: "relaxed globally", global: 1, want: true},
+		{name: "relaxed for the publisher", publisher: 1, want: true},
+		{name: "enforced for the publisher when relaxed globally", global: 1, publisher: 0, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			viper.SetDefault("judge.audience.relax", 0)
+
+			if tt.global != nil {
+				viper.Set("judge.audience.relax", tt.global)
+			}
+			if tt.publisher != nil {
+				viper.Set("judge.publishers.publisher-id.audience.relax", tt.publisher)
+			}
+
+			if got := IsAudienceRelaxed("publisher-id"); got != tt.want {
+				t.Errorf("IsAudienceRelaxed = %v, want %v", got, tt.want)
+			}
+
+			if got := IsAudienceRelaxed("other-id"); tt.global == nil && got {
+				t.Error("relaxing one publisher must not relax others")
+			}
+		})
+	}
+}
+
+func TestAcceptsAudience(t *testing.T) {
+	resourceServers := map[string]aap.ResourceServer{
+		"https://api.example.com":   {Id: "publisher-id", Audience: "https://api.example.com"},
+		"https://other.example.com": {Id: "other-id", Audience: "https://other.example.com"},
+	}
+
+	tests := []struct {
+		name      string
+		audiences []string
+		want      bool
+	}{
+		{name: "no audiences", audiences: nil, want: false},
+		{name: "publisher id", audiences: []string{"publisher-id"}, want: true},
+		{name: "publisher aud", audiences: []string{"https://api.example.com"}, want: true},
+		{name: "among others", audiences: []string{"https://other.example.com", "https://api.example.com"}, want: true},
+		{name: "issued for another publisher", audiences: []string{"https://other.example.com", "other-id"}, want: false},
+		{name: "unknown aud", audiences: []string{"https://unknown.example.com"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := acceptsAudience(tt.audiences, aap.Identity{Id: "publisher-id"}, resourceServers); got != tt.want {
+				t.Errorf("acceptsAudience = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnsatisfiedStepUps(t *testing.T) {
+	now := int64(1000)
+
+	mfa := aap.VerdictStepUp{Scope: aap.Scope{Name: "transfer"}, Acr: []string{"mfa", "hwk"}}
+	otpAndPwd := aap.VerdictStepUp{Scope: aap.Scope{Name: "delete"}, Amr: []string{"otp", "pwd"}}
+	recent := aap.VerdictStepUp{Scope: aap.Scope{Name: "settings"}, MaxAge: 300}
+
+	tests := []struct {
+		name            string
+		stepUps         []aap.VerdictStepUp
+		ti              tokenIntrospection
+		wantUnsatisfied []string
+	}{
+		{name: "no step-ups", ti: tokenIntrospection{}},
+		{name: "accepted acr", stepUps: []aap.VerdictStepUp{mfa}, ti: tokenIntrospection{Acr: "hwk"}},
+		{name: "other acr", stepUps: []aap.VerdictStepUp{mfa}, ti: tokenIntrospection{Acr: "pwd"}, wantUnsatisfied: []string{"transfer"}},
+		{name: "no acr", stepUps: []aap.VerdictStepUp{mfa}, ti: tokenIntrospection{}, wantUnsatisfied: []string{"transfer"}},
+		{name: "all amr", stepUps: []aap.VerdictStepUp{otpAndPwd}, ti: tokenIntrospection{Amr: []string{"pwd", "otp", "sms"}}},
+		{name: "some amr", stepUps: []aap.VerdictStepUp{otpAndPwd}, ti: tokenIntrospection{Amr: []string{"pwd"}}, wantUnsatisfied: []string{"delete"}},
+		{name: "authenticated within max age", stepUps: []aap.VerdictStepUp{recent}, ti: tokenIntrospection{AuthTime: now - 300}},
+		{name: "authenticated too long ago", stepUps: []aap.VerdictStepUp{recent}, ti: tokenIntrospection{AuthTime: now - 301}, wantUnsatisfied: []string{"settings"}},
+		{name: "auth time unknown", stepUps: []aap.VerdictStepUp{recent}, ti: tokenIntrospection{}, wantUnsatisfied: []string{"settings"}},
+		{name: "only unsatisfied are returned", stepUps: []aap.VerdictStepUp{mfa, otpAndPwd, recent}, ti: tokenIntrospection{Acr: "mfa", Amr: []string{"pwd"}, AuthTime: now}, wantUnsatisfied: []string{"delete"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, stepUp := range unsatisfiedStepUps(tt.stepUps, tt.ti, now) {
+				got = append(got, stepUp.Scope.Name)
+			}
+
+			if strings.Join(got, " ") != strings.Join(tt.wantUnsatisfied, " ") {
+				t.Errorf("unsatisfied = %v, want %v", got, tt.wantUnsatisfied)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package app
+
+import (
+	"strings"
+
+	E "github.com/opensentry/aap/client/errors"
+	"github.com/opensentry/aap/config"
+	"github.com/opensentry/aap/gateway/aap"
+
+	bulky "github.com/charmixer/bulky/client"
+)
+
+// PreferredLocales returns the language tags to try in order of preference. An explicit locale wins over the ui_locales of the consent request.
+// Each tag is followed by its primary language, eg. da-DK by da, and the chain ends with the configured fallback locales.
+func PreferredLocales(locale string, uiLocales []string) (locales []string) {
+	var candidates []string
+	if locale != "" {
+		candidates = append(candidates, locale)
+	}
+	candidates = append(candidates, uiLocales...)
+	candidates = append(candidates, config.GetStringSlice("locales.fallback")...)
+
+	for _, candidate := range candidates {
+		tag := strings.ToLower(strings.TrimSpace(candidate))
+		if tag == "" {
+			continue
+		}
+
+		locales = appendLocale(locales, tag)
+
+		if i := strings.Index(tag, "-"); i > 0 {
+			locales = appendLocale(locales, tag[:i])
+		}
+	}
+
+	return locales
+}
+
+func appendLocale(locales []string, tag string) []string {
+	for _, l := range locales {
+		if l == tag {
+			return locales
+		}
+	}
+	return append(locales, tag)
+}
+
+// LocalizePublishRule returns the title and description of the publish rule in the first of the locales it has text for.
+// The untranslated text is written in locales.default and used when no translation matches.
+func LocalizePublishRule(rule aap.PublishRule, locales []string) (title string, description string, locale string) {
+	defaultLocale := strings.ToLower(config.GetString("locales.default"))
+
+	for _, l := range locales {
+		if l == defaultLocale {
+			break
+		}
+
+		if t, exists := rule.Translations[l]; exists {
+			return t.Title, t.Description, l
+		}
+	}
+
+	return rule.Title, rule.Description, defaultLocale
+}
+
+// LocalizeResponses rewrites the error messages of bulky responses into the first of the locales an error message exists for.
+func LocalizeResponses(responses []interface{}, locales []string) {
+	for _, response := range responses {
+		r, ok := response.(*bulky.Response)
+		if !ok || r == nil {
+			continue
+		}
+
+		for i, e := range r.Errors {
+			if message, exists := E.Localize(e.Code, locales); exists {
+				r.Errors[i].Error = message
+			}
+		}
+	}
+}
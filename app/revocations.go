@@ -0,0 +1,172 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+
+	"github.com/opensentry/aap/gateway/aap"
+	"github.com/opensentry/aap/utils"
+)
+
+// The token properties a revocation can match on
+type RevokableToken struct {
+	Jti      string
+	Subject  string
+	ClientId string
+	Scopes   []string // Unknown if empty
+	IssuedAt int64
+}
+
+// In memory copy of the non expired revocations. Kept in sync with other aap instances using nats.
+type RevocationList struct {
+	sync.RWMutex
+	revocations map[string]aap.Revocation
+}
+
+func NewRevocationList() *RevocationList {
+	return &RevocationList{revocations: make(map[string]aap.Revocation)}
+}
+
+func (l *RevocationList) Add(revocation aap.Revocation) {
+	l.Lock()
+	defer l.Unlock()
+
+	// Prune expired revocations, they cannot match a valid token anymore.
+	now := time.Now().Unix()
+	for id, r := range l.revocations {
+		if r.Expire <= now {
+			delete(l.revocations, id)
+		}
+	}
+
+	l.revocations[revocation.Id] = revocation
+}
+
+// IsRevoked answers QTNA #5. A revocation matches if all of its non empty fields equals the token.
+// Revocations with scopes only match tokens carrying any of them. Tokens with unknown scopes are matched regardless.
+// Revocations without jti only affect tokens issued before (or at) the time of revocation, tokens issued afterwards are not revoked.
+func (l *RevocationList) IsRevoked(token RevokableToken) bool {
+	l.RLock()
+	defer l.RUnlock()
+
+	now := time.Now().Unix()
+	for _, r := range l.revocations {
+		if r.Expire <= now {
+			continue
+		}
+
+		if r.Jti == "" && r.Subject == "" && r.ClientId == "" {
+			continue
+		}
+
+		if r.Jti != "" && r.Jti != token.Jti {
+			continue
+		}
+
+		if r.Subject != "" && r.Subject != token.Subject {
+			continue
+		}
+
+		if r.ClientId != "" && r.ClientId != token.ClientId {
+			continue
+		}
+
+		if len(r.Scopes) > 0 && len(token.Scopes) > 0 && !carriesAnyScope(token, r.Scopes) {
+			continue
+		}
+
+		if r.Jti == "" && token.IssuedAt > r.RevokedAt {
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// LoadRevocations fills the revocation list from the database. Called on start up.
+func LoadRevocations(env *Environment) error {
+	session, tx, err := aap.BeginReadTx(env.Driver)
+	if err != nil {
+		return err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	revocations, err := aap.FetchRevocations(tx, aap.Revocation{})
+	if err != nil {
+		return err
+	}
+
+	for _, revocation := range revocations {
+		env.Revocations.Add(revocation)
+	}
+
+	return tx.Commit()
+}
+
+// SubscribeToRevocations adds revocations created by any aap instance to the revocation list.
+func SubscribeToRevocations(env *Environment) (*nats.Subscription, error) {
+	return env.Nats.Subscribe(aap.EVENT_REVOCATION_CREATED, func(m *nats.Msg) {
+		var event struct {
+			Data aap.EventRevocation `json:"data"`
+		}
+		err := json.Unmarshal(m.Data, &event)
+		if err != nil || event.Data.Id == "" {
+			return
+		}
+
+		env.Revocations.Add(aap.Revocation{
+			Id:        event.Data.Id,
+			Jti:       event.Data.Jti,
+			Subject:   event.Data.Subject,
+			ClientId:  event.Data.ClientId,
+			Scopes:    event.Data.Scopes,
+			RevokedAt: event.Data.RevokedAt,
+			Expire:    event.Data.Expire,
+		})
+	})
+}
+
+// decodeRevokableToken reads the payload of a JWT access token without verifying it. Only use the result for revocation checks, never to grant access.
+func decodeRevokableToken(rawToken string) (token RevokableToken, err error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return RevokableToken{}, errors.New("Not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return RevokableToken{}, err
+	}
+
+	var c struct {
+		Jti      string   `json:"jti"`
+		Sub      string   `json:"sub"`
+		ClientId string   `json:"client_id"`
+		Scp      []string `json:"scp"`
+		Iat      int64    `json:"iat"`
+	}
+	err = json.Unmarshal(payload, &c)
+	if err != nil {
+		return RevokableToken{}, err
+	}
+
+	return RevokableToken{Jti: c.Jti, Subject: c.Sub, ClientId: c.ClientId, Scopes: c.Scp, IssuedAt: c.Iat}, nil
+}
+
+func carriesAnyScope(token RevokableToken, scopes []string) bool {
+	for _, scope := range scopes {
+		if utils.StringInSlice(scope, token.Scopes) {
+			return true
+		}
+	}
+	return false
+}
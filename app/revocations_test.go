@@ -0,0 +1,124 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/opensentry/aap/gateway/aap"
+)
+
+func TestIsRevoked(t *testing.T) {
+	now := time.Now().Unix()
+
+	token := RevokableToken{Jti: "jti", Subject: "subject-id", ClientId: "client-id", Scopes: []string{"openid", "read:things"}, IssuedAt: now - 60}
+
+	tests := []struct {
+		name       string
+		revocation aap.Revocation
+		token      RevokableToken
+		want       bool
+	}{
+		{
+			name:       "subject and client",
+			revocation: aap.Revocation{Subject: "subject-id", ClientId: "client-id", RevokedAt: now, Expire: now + 60},
+			token:      token,
+			want:       true,
+		},
+		{
+			name:       "other client",
+			revocation: aap.Revocation{Subject: "subject-id", ClientId: "other-id", RevokedAt: now, Expire: now + 60},
+			token:      token,
+			want:       false,
+		},
+		{
+			name:       "other subject",
+			revocation: aap.Revocation{Subject: "other-id", ClientId: "client-id", RevokedAt: now, Expire: now + 60},
+			token:      token,
+			want:       false,
+		},
+		{
+			name:       "withdrawn scope carried by the token",
+			revocation: aap.Revocation{Subject: "subject-id", ClientId: "client-id", Scopes: []string{"read:things"}, RevokedAt: now, Expire: now + 60},
+			token:      token,
+			want:       true,
+		},
+		{
+			name:       "withdrawn scope not carried by the token",
+			revocation: aap.Revocation{Subject: "subject-id", ClientId: "client-id", Scopes: []string{"write:things"}, RevokedAt: now, Expire: now + 60},
+			token:      token,
+			want:       false,
+		},
+		{
+			name:       "withdrawn scope and token with unknown scopes",
+			revocation: aap.Revocation{Subject: "subject-id", ClientId: "client-id", Scopes: []string{"write:things"}, RevokedAt: now, Expire: now + 60},
+			token:      RevokableToken{Subject: "subject-id", ClientId: "client-id", IssuedAt: now - 60},
+			want:       true,
+		},
+		{
+			name:       "token issued after the revocation",
+			revocation: aap.Revocation{Subject: "subject-id", ClientId: "client-id", RevokedAt: now - 120, Expire: now + 60},
+			token:      token,
+			want:       false,
+		},
+		{
+			name:       "jti regardless of issued at",
+			revocation: aap.Revocation{Jti: "jti", RevokedAt: now - 120, Expire: now + 60},
+			token:      token,
+			want:       true,
+		},
+		{
+			name:       "expired revocation",
+			revocation: aap.Revocation{Subject: "subject-id", ClientId: "client-id", RevokedAt: now, Expire: now - 1},
+			token:      token,
+			want:       false,
+		},
+		{
+			name:       "empty revocation",
+			revocation: aap.Revocation{RevokedAt: now, Expire: now + 60},
+			token:      token,
+			want:       false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRevocationList()
+			tt.revocation.Id = "revocation-id"
+			l.Add(tt.revocation)
+
+			if got := l.IsRevoked(tt.token); got != tt.want {
+				t.Errorf("IsRevoked = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeRevokableToken(t *testing.T) {
+	claims, _ := json.Marshal(map[string]interface{}{
+		"jti":       "jti",
+		"sub":       "subject-id",
+		"client_id": "client-id",
+		"scp":       []string{"openid", "read:things"},
+		"iat":       1600000000,
+	})
+	rawToken := "e30." + base64.RawURLEncoding.EncodeToString(claims) + ".signature"
+
+	token, err := decodeRevokableToken(rawToken)
+	if err != nil {
+		t.Fatalf("decodeRevokableToken: %v", err)
+	}
+
+	if token.Jti != "jti" || token.Subject != "subject-id" || token.ClientId != "client-id" || token.IssuedAt != 1600000000 {
+		t.Errorf("token = %+v", token)
+	}
+
+	if len(token.Scopes) != 2 || token.Scopes[1] != "read:things" {
+		t.Errorf("scopes = %v, want [openid read:things]", token.Scopes)
+	}
+
+	if _, err := decodeRevokableToken("opaque"); err == nil {
+		t.Error("expected error on opaque token")
+	}
+}
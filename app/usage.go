@@ -0,0 +1,110 @@
+package app
+
+import (
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+
+	"github.com/opensentry/aap/config"
+	"github.com/opensentry/aap/gateway/aap"
+)
+
+type clientUsageKey struct {
+	Subject  string
+	ClientId string
+}
+
+// In memory record of when subjects used clients since the last flush. Judging happens far too often to write each use to the database.
+type UsageTracker struct {
+	sync.Mutex
+	usages map[clientUsageKey]int64
+}
+
+func NewUsageTracker() *UsageTracker {
+	return &UsageTracker{usages: make(map[clientUsageKey]int64)}
+}
+
+func (t *UsageTracker) Touch(subject string, clientId string) {
+	if subject == "" || clientId == "" {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.usages[clientUsageKey{Subject: subject, ClientId: clientId}] = time.Now().Unix()
+}
+
+func (t *UsageTracker) drain() (usages []aap.ClientUsage) {
+	t.Lock()
+	defer t.Unlock()
+
+	for key, at := range t.usages {
+		usages = append(usages, aap.ClientUsage{Identity: aap.Identity{Id: key.Subject}, Subscriber: aap.Identity{Id: key.ClientId}, LastUsedAt: at})
+	}
+	t.usages = make(map[clientUsageKey]int64)
+
+	return usages
+}
+
+// trackUsage records the subject and client of granted verdicts as in use.
+func trackUsage(env *Environment, judgeVerdicts []JudgeVerdict) {
+	if env.Usage == nil {
+		return
+	}
+
+	for _, v := range judgeVerdicts {
+		if v.Verdict.Granted {
+			env.Usage.Touch(v.Introspection.Subject.Id, v.Introspection.Client.Id)
+		}
+	}
+}
+
+// RunUsageFlusher writes the tracked client usages to the database until stop is closed.
+func RunUsageFlusher(env *Environment, log *logrus.Entry, stop <-chan struct{}) {
+	interval := time.Duration(config.GetInt("apps.usage.interval")) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			err := flushUsages(env)
+			if err != nil {
+				log.Debug(err.Error())
+			}
+			return
+		case <-ticker.C:
+		}
+
+		err := flushUsages(env)
+		if err != nil {
+			log.Debug(err.Error())
+		}
+	}
+}
+
+func flushUsages(env *Environment) (err error) {
+	usages := env.Usage.drain()
+	if len(usages) <= 0 {
+		return nil
+	}
+
+	session, tx, err := aap.BeginWriteTx(env.Driver)
+	if err != nil {
+		return err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	err = aap.UpdateClientUsages(tx, usages)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"strings"
+
+	hydra "github.com/charmixer/hydra/client"
+	oidc "github.com/coreos/go-oidc"
+
+	"github.com/opensentry/aap/config"
+	"github.com/opensentry/aap/gateway/aap"
+)
+
+// Claims of a JWT formatted access token issued by hydra
+type AccessTokenClaims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ClientId  string
+	Jti       string
+	Scopes    []string
+	Expire    int64
+	NotBefore int64
+	IssuedAt  int64
+	Ext       aap.HydraIntrospectExt // Session claims of the access token
+}
+
+// Local verification of JWT access tokens is opt-in. Opaque tokens are always introspected.
+func IsLocalVerificationEnabled() bool {
+	return config.GetInt("oauth2.tokens.verify.local") == 1
+}
+
+// A JWT consists of three base64url encoded parts separated by dots. Opaque hydra tokens have one dot only.
+func IsJwtAccessToken(rawToken string) bool {
+	return strings.Count(rawToken, ".") == 2
+}
+
+// VerifyAccessToken checks signature (using the JWKS of the provider), iss, exp and nbf of a JWT access token.
+// If audience is not empty the token aud must contain it.
+func VerifyAccessToken(env *Environment, rawToken string, audience string) (claims AccessTokenClaims, err error) {
+	if env.Provider == nil {
+		return AccessTokenClaims{}, errors.New("Missing oidc provider")
+	}
+
+	verifier := env.Provider.Verifier(&oidc.Config{
+		ClientID:          audience,
+		SkipClientIDCheck: audience == "",
+	})
+
+	token, err := verifier.Verify(context.Background(), rawToken)
+	if err != nil {
+		return AccessTokenClaims{}, err
+	}
+
+	var c struct {
+		ClientId  string   `json:"client_id"`
+		Jti       string   `json:"jti"`
+		Scp       []string `json:"scp"`
+		Scope     string   `json:"scope"`
+		NotBefore int64    `json:"nbf"`
+
+		Ext aap.HydraIntrospectExt `json:"ext"`
+	}
+	err = token.Claims(&c)
+	if err != nil {
+		return AccessTokenClaims{}, err
+	}
+
+	scopes := c.Scp
+	if len(scopes) <= 0 && c.Scope != "" {
+		scopes = strings.Split(c.Scope, " ")
+	}
+
+	claims = AccessTokenClaims{
+		Issuer:    token.Issuer,
+		Subject:   token.Subject,
+		Audience:  token.Audience,
+		ClientId:  c.ClientId,
+		Jti:       c.Jti,
+		Scopes:    scopes,
+		Expire:    token.Expiry.Unix(),
+		NotBefore: c.NotBefore,
+		IssuedAt:  token.IssuedAt.Unix(),
+		Ext:       c.Ext,
+	}
+	return claims, nil
+}
+
+// introspectAccessToken answers QTNA #2. JWT access tokens are verified locally if enabled, everything else is introspected by hydra.
+// Local verification is translated into the hydra introspection response, so the judge does not care which one answered.
+// Scopes are checked by the judge.
+func introspectAccessToken(env *Environment, hydraClient *hydra.HydraClient, rawToken string, audience string) (introspectResponse aap.HydraIntrospectResponse, err error) {
+
+	if IsLocalVerificationEnabled() && IsJwtAccessToken(rawToken) {
+		claims, err := VerifyAccessToken(env, rawToken, audience)
+		if err != nil {
+			// Invalid tokens are inactive, not errors.
+			return aap.HydraIntrospectResponse{IntrospectResponse: hydra.IntrospectResponse{Active: false}}, nil
+		}
+
+		return aap.HydraIntrospectResponse{
+			IntrospectResponse: hydra.IntrospectResponse{
+				Active:    true,
+				Aud:       claims.Audience,
+				ClientId:  claims.ClientId,
+				Exp:       claims.Expire,
+				Iat:       claims.IssuedAt,
+				Iss:       claims.Issuer,
+				Nbf:       claims.NotBefore,
+				Scope:     strings.Join(claims.Scopes, " "),
+				Sub:       claims.Subject,
+				TokenType: "access_token",
+			},
+			Ext: claims.Ext,
+		}, nil
+	}
+
+	introspectRequest := hydra.IntrospectRequest{
+		Token: rawToken,
+	}
+	return aap.IntrospectHydraToken(env.OAuth2Delegator.IntrospectTokenUrl, introspectRequest)
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	oidc "github.com/coreos/go-oidc"
+	"github.com/spf13/viper"
+)
+
+// newTestProvider serves the discovery document and the key set of an issuer signing with key
+func newTestProvider(t *testing.T, key *rsa.PrivateKey) (*httptest.Server, *oidc.Provider) {
+	t.Helper()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"issuer":                                server.URL,
+				"jwks_uri":                              server.URL + "/jwks",
+				"authorization_endpoint":                server.URL + "/auth",
+				"token_endpoint":                        server.URL + "/token",
+				"id_token_signing_alg_values_supported": []string{"RS256"},
+			})
+		case "/jwks":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"keys": []map[string]string{{
+					"kty": "RSA",
+					"alg": "RS256",
+					"use": "sig",
+					"kid": "test",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				}},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	provider, err := oidc.NewProvider(context.Background(), server.URL)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	return server, provider
+}
+
+func signTestToken(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestIsJwtAccessToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{name: "jwt", token: "header.payload.signature", want: true},
+		{name: "opaque hydra token", token: "token.signature"},
+		{name: "no dots", token: "token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsJwtAccessToken(tt.token); got != tt.want {
+				t.Errorf("IsJwtAccessToken(%s) = %v, want %v", tt.token, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntrospectAccessTokenLocally(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("oauth2.tokens.verify.local", 1)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, provider := newTestProvider(t, key)
+	defer server.Close()
+
+	env := &Environment{Provider: provider}
+	now := time.Now().Unix()
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":       server.URL,
+			"sub":       "subject-id",
+			"aud":       []string{"aap-id"},
+			"client_id": "client-id",
+			"scp":       []string{"read", "write"},
+			"iat":       now,
+			"nbf":       now,
+			"exp":       now + 60,
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name       string
+		key        *rsa.PrivateKey
+		claims     map[string]interface{}
+		audience   string
+		wantActive bool
+		wantScope  string
+	}{
+		{name: "scopes in scp", key: key, claims: claims(nil), audience: "aap-id", wantActive: true, wantScope: "read write"},
+		{name: "scopes in scope", key: key, claims: claims(map[string]interface{}{"scp": nil, "scope": "read write"}), audience: "aap-id", wantActive: true, wantScope: "read write"},
+		{name: "any audience", key: key, claims: claims(nil), wantActive: true, wantScope: "read write"},
+		{name: "other audience", key: key, claims: claims(nil), audience: "other-id"},
+		{name: "expired", key: key, claims: claims(map[string]interface{}{"exp": now - 60}), audience: "aap-id"},
+		{name: "other issuer", key: key, claims: claims(map[string]interface{}{"iss": "https://other.example.com"}), audience: "aap-id"},
+		{name: "signed by other key", key: otherKey, claims: claims(nil), audience: "aap-id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rawToken := signTestToken(t, tt.key, tt.claims)
+
+			introspection, err := introspectAccessToken(env, nil, rawToken, tt.audience)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if introspection.Active != tt.wantActive {
+				t.Fatalf("active = %v, want %v", introspection.Active, tt.wantActive)
+			}
+			if !tt.wantActive {
+				return
+			}
+
+			if introspection.Scope != tt.wantScope {
+				t.Errorf("scope = %s, want %s", introspection.Scope, tt.wantScope)
+			}
+
+			if introspection.Sub != "subject-id" || introspection.ClientId != "client-id" {
+				t.Errorf("sub = %s, client_id = %s, want subject-id and client-id", introspection.Sub, introspection.ClientId)
+			}
+		})
+	}
+}
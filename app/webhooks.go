@@ -0,0 +1,318 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+	"github.com/sirupsen/logrus"
+
+	"github.com/opensentry/aap/config"
+	"github.com/opensentry/aap/gateway/aap"
+)
+
+const (
+	WEBHOOK_HEADER_EVENT     = "X-Aap-Event"
+	WEBHOOK_HEADER_DELIVERY  = "X-Aap-Delivery"
+	WEBHOOK_HEADER_SIGNATURE = "X-Aap-Signature" // t=<unix timestamp>,v1=<hex hmac-sha256 of "<timestamp>.<body>" keyed with the webhook secret>
+)
+
+// SignWebhookPayload signs the timestamp and body, so receivers can reject both forged and replayed payloads.
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	ts := strconv.FormatInt(timestamp, 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// IsWebhookEventMatch matches an event type against the event filter of a webhook. Supports * for all events and prefix.* patterns like aap.consent.*
+func IsWebhookEventMatch(filter []string, eventType string) bool {
+	for _, f := range filter {
+		if f == "*" || f == eventType {
+			return true
+		}
+
+		if strings.HasSuffix(f, ".*") && strings.HasPrefix(eventType, strings.TrimSuffix(f, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsWebhookEventVisible tells if the event concerns the publisher the webhook is registered on behalf of.
+// Events not concerning a single publisher, like entities, scopes, shadows, revocations and rejections of a whole consent challenge, are never delivered to webhooks.
+func IsWebhookEventVisible(webhook aap.Webhook, event aap.Event) bool {
+	if webhook.Publisher.Id == "" {
+		return false
+	}
+
+	var publisher string
+	switch data := event.Data.(type) {
+	case aap.EventPublish:
+		publisher = data.Publisher
+	case aap.EventGrant:
+		publisher = data.Publisher
+	case aap.EventSubscription:
+		publisher = data.Publisher
+	case aap.EventConsent:
+		publisher = data.Publisher
+	}
+
+	return publisher == webhook.Publisher.Id
+}
+
+func queueWebhookDeliveries(tx neo4j.Transaction, webhooks []aap.Webhook, event aap.Event, outboxEvent aap.OutboxEvent) error {
+	for _, webhook := range webhooks {
+		if !IsWebhookEventMatch(webhook.Events, outboxEvent.Type) {
+			continue
+		}
+
+		if !IsWebhookEventVisible(webhook, event) {
+			continue
+		}
+
+		err := aap.CreateWebhookDelivery(tx, webhook, outboxEvent)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delivers queued webhook deliveries with at-least-once delivery. Receivers must deduplicate on the event id of the payload.
+type WebhookDispatcher struct {
+	notify chan struct{}
+	client *http.Client
+}
+
+func NewWebhookDispatcher() *WebhookDispatcher {
+	return &WebhookDispatcher{
+		notify: make(chan struct{}, 1),
+		client: &http.Client{},
+	}
+}
+
+// Notify wakes the dispatcher up, call it after queueing deliveries.
+func (w *WebhookDispatcher) Notify() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+		// Already notified
+	}
+}
+
+// Run delivers until stop is closed. Polls every webhooks.delivery.interval seconds to pick up retries.
+func (w *WebhookDispatcher) Run(env *Environment, log *logrus.Entry, stop <-chan struct{}) {
+	interval := time.Duration(config.GetInt("webhooks.delivery.interval")) * time.Second
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	w.client.Timeout = time.Duration(config.GetInt("webhooks.delivery.timeout")) * time.Second
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := pruneWebhookDeliveries(env)
+			if err != nil {
+				log.Debug(err.Error())
+			}
+		case <-w.notify:
+		}
+
+		for {
+			delivered, err := w.deliver(env, log)
+			if err != nil {
+				log.Debug(err.Error())
+				break
+			}
+
+			// Nothing more due
+			if delivered < int64(config.GetInt("webhooks.delivery.batch")) {
+				break
+			}
+		}
+	}
+}
+
+// Claims a batch in one transaction, attempts the deliveries outside of it and records each attempt in its own transaction.
+// Holding a transaction across webhook calls would keep the deliveries locked for as long as the slowest receivers take to respond.
+func (w *WebhookDispatcher) deliver(env *Environment, log *logrus.Entry) (delivered int64, err error) {
+	batch := int64(config.GetInt("webhooks.delivery.batch"))
+
+	// Attempts are made one by one, so the lease must cover a batch of timeouts
+	lease := batch*int64(config.GetInt("webhooks.delivery.timeout")) + int64(config.GetInt("webhooks.delivery.interval"))
+
+	deliveries, err := claimWebhookDeliveries(env, batch, time.Now().Unix()+lease)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, delivery := range deliveries {
+		attempt := w.attempt(delivery)
+
+		if attempt.Error != "" {
+			log.WithFields(logrus.Fields{"webhook_id": delivery.Webhook.Id, "delivery_id": delivery.Id}).Debug(attempt.Error)
+		}
+
+		err = recordWebhookDeliveryAttempt(env, delivery, attempt)
+		if err != nil {
+			// Delivery is attempted again when the lease runs out
+			log.WithFields(logrus.Fields{"webhook_id": delivery.Webhook.Id, "delivery_id": delivery.Id}).Debug(err.Error())
+		}
+	}
+
+	return int64(len(deliveries)), nil
+}
+
+func claimWebhookDeliveries(env *Environment, batch int64, leaseUntil int64) (deliveries []aap.WebhookDelivery, err error) {
+	session, tx, err := aap.BeginWriteTx(env.Driver)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	deliveries, err = aap.ClaimPendingWebhookDeliveries(tx, batch, leaseUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, tx.Commit()
+}
+
+func (w *WebhookDispatcher) attempt(delivery aap.WebhookDelivery) (attempt aap.WebhookDeliveryAttempt) {
+	started := time.Now()
+	attempt.At = started.Unix()
+
+	body := []byte(delivery.Payload)
+
+	req, err := http.NewRequest("POST", delivery.Webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WEBHOOK_HEADER_EVENT, delivery.EventType)
+	req.Header.Set(WEBHOOK_HEADER_DELIVERY, delivery.Id)
+	req.Header.Set(WEBHOOK_HEADER_SIGNATURE, SignWebhookPayload(delivery.Webhook.Secret, attempt.At, body))
+
+	res, err := w.client.Do(req)
+	attempt.Duration = time.Since(started).Nanoseconds() / int64(time.Millisecond)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer res.Body.Close()
+
+	attempt.StatusCode = int64(res.StatusCode)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("Unexpected status code %d", res.StatusCode)
+	}
+
+	return attempt
+}
+
+// Failed attempts are retried with exponential backoff capped at webhooks.delivery.backoff.max seconds. After webhooks.delivery.attempts the delivery is dead lettered.
+func recordWebhookDeliveryAttempt(env *Environment, delivery aap.WebhookDelivery, attempt aap.WebhookDeliveryAttempt) error {
+	status := aap.WEBHOOK_DELIVERY_PENDING
+	var nextAttemptAt int64
+
+	if attempt.Error == "" {
+		status = aap.WEBHOOK_DELIVERY_DELIVERED
+	} else if delivery.Attempts+1 >= int64(config.GetInt("webhooks.delivery.attempts")) {
+		status = aap.WEBHOOK_DELIVERY_DEAD
+	} else {
+		nextAttemptAt = time.Now().Unix() + webhookDeliveryBackoff(delivery.Attempts)
+	}
+
+	session, tx, err := aap.BeginWriteTx(env.Driver)
+	if err != nil {
+		return err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	err = aap.CreateWebhookDeliveryAttempt(tx, delivery, attempt, status, nextAttemptAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// webhookDeliveryBackoff returns the seconds to wait before the next attempt, doubling webhooks.delivery.backoff.min for every failed attempt until webhooks.delivery.backoff.max.
+func webhookDeliveryBackoff(attempts int64) int64 {
+	backoff := int64(config.GetInt("webhooks.delivery.backoff.min"))
+	max := int64(config.GetInt("webhooks.delivery.backoff.max"))
+	for i := int64(0); i < attempts && backoff < max; i++ {
+		backoff = backoff * 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// Prunes delivered and dead lettered deliveries with their attempts, once older than webhooks.retention.delivered and webhooks.retention.dead seconds. A retention of 0 keeps them forever.
+func pruneWebhookDeliveries(env *Environment) (err error) {
+	retentions := map[string]int64{
+		aap.WEBHOOK_DELIVERY_DELIVERED: int64(config.GetInt("webhooks.retention.delivered")),
+		aap.WEBHOOK_DELIVERY_DEAD:      int64(config.GetInt("webhooks.retention.dead")),
+	}
+
+	batch := int64(config.GetInt("webhooks.delivery.batch"))
+	now := time.Now().Unix()
+
+	for status, retention := range retentions {
+		if retention <= 0 {
+			continue
+		}
+
+		for {
+			deleted, err := deleteWebhookDeliveries(env, status, now-retention, batch)
+			if err != nil {
+				return err
+			}
+
+			if deleted < batch {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func deleteWebhookDeliveries(env *Environment, status string, before int64, limit int64) (deleted int64, err error) {
+	session, tx, err := aap.BeginWriteTx(env.Driver)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	deleted, err = aap.DeleteWebhookDeliveries(tx, status, before, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, tx.Commit()
+}
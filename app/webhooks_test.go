@@ -0,0 +1,109 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/opensentry/aap/gateway/aap"
+)
+
+func TestIsWebhookEventMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		filter    []string
+		eventType string
+		want      bool
+	}{
+		{name: "all", filter: []string{"*"}, eventType: aap.EVENT_CONSENT_CREATED, want: true},
+		{name: "exact", filter: []string{aap.EVENT_CONSENT_CREATED}, eventType: aap.EVENT_CONSENT_CREATED, want: true},
+		{name: "prefix", filter: []string{"aap.consent.*"}, eventType: aap.EVENT_CONSENT_DELETED, want: true},
+		{name: "other prefix", filter: []string{"aap.consent.*"}, eventType: aap.EVENT_GRANT_CREATED},
+		{name: "prefix is not a partial word", filter: []string{"aap.con.*"}, eventType: aap.EVENT_CONSENT_CREATED},
+		{name: "no filter", eventType: aap.EVENT_CONSENT_CREATED},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsWebhookEventMatch(tt.filter, tt.eventType); got != tt.want {
+				t.Errorf("IsWebhookEventMatch(%v, %s) = %v, want %v", tt.filter, tt.eventType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsWebhookEventVisible(t *testing.T) {
+	webhook := aap.Webhook{Id: "webhook-id", Publisher: aap.Identity{Id: "publisher-id"}}
+
+	tests := []struct {
+		name    string
+		webhook aap.Webhook
+		data    interface{}
+		want    bool
+	}{
+		{name: "consent of publisher", webhook: webhook, data: aap.EventConsent{Identity: "subject-id", Subscriber: "client-id", Publisher: "publisher-id"}, want: true},
+		{name: "consent of other publisher", webhook: webhook, data: aap.EventConsent{Identity: "subject-id", Subscriber: "client-id", Publisher: "other-id"}},
+		{name: "rejected consent challenge", webhook: webhook, data: aap.EventConsent{Identity: "subject-id", Subscriber: "client-id"}},
+		{name: "subscription of publisher", webhook: webhook, data: aap.EventSubscription{Subscriber: "client-id", Publisher: "publisher-id"}, want: true},
+		{name: "grant of publisher", webhook: webhook, data: aap.EventGrant{Identity: "identity-id", Publisher: "publisher-id"}, want: true},
+		{name: "publish of publisher", webhook: webhook, data: aap.EventPublish{Publisher: "publisher-id"}, want: true},
+		{name: "revocation", webhook: webhook, data: aap.EventRevocation{Subject: "subject-id", ClientId: "client-id"}},
+		{name: "shadow", webhook: webhook, data: aap.EventShadow{Identity: "identity-id", Shadow: "shadow-id"}},
+		{name: "webhook without publisher", webhook: aap.Webhook{Id: "legacy-id"}, data: aap.EventConsent{Identity: "subject-id"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsWebhookEventVisible(tt.webhook, aap.Event{Data: tt.data}); got != tt.want {
+				t.Errorf("IsWebhookEventVisible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookDeliveryBackoff(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("webhooks.delivery.backoff.min", 10)
+	viper.Set("webhooks.delivery.backoff.max", 100)
+
+	tests := []struct {
+		attempts int64
+		want     int64
+	}{
+		{attempts: 0, want: 10},
+		{attempts: 1, want: 20},
+		{attempts: 2, want: 40},
+		{attempts: 3, want: 80},
+		{attempts: 4, want: 100},
+		{attempts: 1000, want: 100}, // Stops doubling at the cap, so it cannot overflow
+	}
+
+	for _, tt := range tests {
+		if got := webhookDeliveryBackoff(tt.attempts); got != tt.want {
+			t.Errorf("webhookDeliveryBackoff(%d) = %d, want %d", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	body := []byte(`{"id":"event-id"}`)
+
+	signature := SignWebhookPayload("secret", 1600000000, body)
+	if !strings.HasPrefix(signature, "t=1600000000,v1=") {
+		t.Errorf("signature = %s, want t=1600000000,v1=<hmac>", signature)
+	}
+
+	if SignWebhookPayload("secret", 1600000000, body) != signature {
+		t.Error("signature is not deterministic")
+	}
+
+	if SignWebhookPayload("other", 1600000000, body) == signature {
+		t.Error("signature does not depend on the secret")
+	}
+
+	// Replays with a new timestamp must not reuse the signature
+	if SignWebhookPayload("secret", 1600000001, body) == signature {
+		t.Error("signature does not depend on the timestamp")
+	}
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	bulky "github.com/charmixer/bulky/client"
+)
+
+// /apps are the clients a subject has consented to act on its behalf. Always the subject of the access token calling aap.
+
+type AppScope struct {
+	Scope       string `json:"scope" validate:"required"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	ConsentedAt int64  `json:"consented_at,omitempty"` // 0 = unknown, consent given before consent history was recorded
+	NotBefore   int64  `json:"nbf,omitempty"`
+	Expire      int64  `json:"exp,omitempty"`
+	Automatic   bool   `json:"is_automatic"`
+}
+
+type AppPublisher struct {
+	Publisher string     `json:"publisher_id" validate:"required,uuid"`
+	Scopes    []AppScope `json:"scopes" validate:"dive"`
+}
+
+type App struct {
+	ClientId   string         `json:"client_id" validate:"required,uuid"`
+	ClientName string         `json:"client_name,omitempty"`
+	LastUsedAt int64          `json:"last_used_at,omitempty"` // 0 = not used since usage tracking began
+	Publishers []AppPublisher `json:"publishers" validate:"dive"`
+}
+
+type ReadAppsResponse []App
+type ReadAppsRequest struct {
+	ClientId string `json:"client_id,omitempty" validate:"omitempty,uuid"`
+	Locale   string `json:"locale,omitempty"` // Language tag of titles and descriptions, eg. da
+}
+
+type DeleteAppsResponse struct {
+	ClientId        string `json:"client_id" validate:"required,uuid"`
+	Consents        int64  `json:"consents_deleted"`
+	Revocation      string `json:"revocation_id" validate:"required,uuid"` // Revocation of the tokens issued to the client on behalf of the subject
+	SessionsRevoked bool   `json:"sessions_revoked"`                       // False if hydra could not be told to forget the consent sessions, see sessions_error
+	SessionsError   string `json:"sessions_error,omitempty"`
+}
+type DeleteAppsRequest struct {
+	ClientId string `json:"client_id" validate:"required,uuid"`
+}
+
+func ReadApps(client *AapClient, url string, requests []ReadAppsRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "GET", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
+func DeleteApps(client *AapClient, url string, requests []DeleteAppsRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "DELETE", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
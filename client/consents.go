@@ -9,6 +9,9 @@ type Consent struct {
 	Subscriber string `json:"subscriber_id" validate:"required,uuid"` // OAuth2:Client
 	Publisher  string `json:"publisher_id"  validate:"required,uuid"` // OAuth2:Resource Server
 	Scope      string `json:"scope"         validate:"required"`      // OAuth2:Scope, published by the resource server
+	NotBefore  int64  `json:"nbf"           validate:"gte=0"`
+	Expire     int64  `json:"exp"           validate:"eq=0|gtefield=NotBefore"` // 0 = never expires
+	Automatic  bool   `json:"is_automatic"`                                     // Given by aap, because the subscriber is trusted
 }
 
 type CreateConsentsResponse Consent
@@ -17,6 +20,8 @@ type CreateConsentsRequest struct {
 	Subscriber string `json:"subscriber_id" validate:"required,uuid"` // OAuth2:Client
 	Publisher  string `json:"publisher_id"  validate:"required,uuid"` // OAuth2:Resource Server
 	Scope      string `json:"scope"         validate:"required"`      // OAuth2:Scope, published by the resource server
+	NotBefore  int64  `json:"nbf,omitempty" validate:"gte=0"`
+	Expire     int64  `json:"exp,omitempty" validate:"eq=0|gtefield=NotBefore"` // Defaults to the consent lifetime of the publisher
 }
 
 type ReadConsentsResponse []Consent
@@ -27,7 +32,15 @@ type ReadConsentsRequest struct {
 	Scopes     []string `json:"scopes,omitempty"        validate:"omitempty"`      // OAuth2:Scope, published by the resource server
 }
 
-type DeleteConsentsResponse Consent
+type DeleteConsentsResponse struct {
+	Consent
+
+	// Outcome of revoking access already given by the consent. Hydra sessions are revoked after the consent is deleted, so a failure does not fail the request.
+	Revocation      string   `json:"revocation_id,omitempty" validate:"omitempty,uuid"` // Revokes the access tokens of the subscriber issued to the subject until now, carrying any of the revoked scopes
+	RevokedScopes   []string `json:"revoked_scopes,omitempty"`                          // The withdrawn scopes of the subject and subscriber in the same bulk request
+	SessionsRevoked bool     `json:"sessions_revoked"`                                  // Hydra has no scoped session revocation. It ends every token of the subscriber for the subject, not only tokens carrying the revoked scopes
+	SessionsError   string   `json:"sessions_error,omitempty"`
+}
 type DeleteConsentsRequest struct {
 	Reference  string `json:"reference_id"  validate:"required,uuid"` // OAuth2:Subject
 	Subscriber string `json:"subscriber_id" validate:"required,uuid"` // OAuth2:Client
@@ -40,7 +53,15 @@ type ConsentRequest struct {
 	Audience    string
 	Title       string
 	Description string
+	Locale      string // Language tag of title and description
 	Consented   bool
+	Required    bool // Subscribed as required by the client, cannot be deselected
+	Trusted     bool // Subscribed by a trusted client, consented automatically
+}
+
+type ConsentAuthorization struct {
+	Scope    string `json:"scope"       validate:"required"`
+	Audience string `json:"audience_id" validate:"required,uuid"` // OAuth2:Resource Server
 }
 
 type Authorization struct {
@@ -61,16 +82,22 @@ type Authorization struct {
 type CreateConsentsAuthorizeResponse Authorization
 type CreateConsentsAuthorizeRequest struct {
 	Challenge string `json:"challenge" validate:"required"`
+	Locale    string `json:"locale,omitempty"` // Language tag preferred over the ui_locales of the consent request, eg. da
+
+	// The consent requests accepted by the subject. Only these are consented and granted. When omitted the consents already given are granted.
+	Consents []ConsentAuthorization `json:"consents,omitempty" validate:"omitempty,dive"`
 }
 
 type ReadConsentsAuthorizeResponse Authorization
 type ReadConsentsAuthorizeRequest struct {
 	Challenge string `json:"challenge" validate:"required"`
+	Locale    string `json:"locale,omitempty"` // Language tag preferred over the ui_locales of the consent request, eg. da
 }
 
 type CreateConsentsRejectResponse Authorization
 type CreateConsentsRejectRequest struct {
 	Challenge string `json:"challenge" validate:"required"`
+	Locale    string `json:"locale,omitempty"` // Language tag preferred over the ui_locales of the consent request, eg. da
 }
 
 func CreateConsents(client *AapClient, url string, requests []CreateConsentsRequest) (status int, responses bulky.Responses, err error) {
@@ -132,3 +159,97 @@ func CreateConsentsReject(client *AapClient, url string, requests []CreateConsen
 
 	return status, responses, nil
 }
+
+type ConsentHistory struct {
+	Id             string `json:"id"                        validate:"required,uuid"`
+	Action         string `json:"action"                    validate:"required,oneof=granted withdrawn expired rejected"`
+	At             int64  `json:"at"                        validate:"gte=0"`
+	Reference      string `json:"reference_id"              validate:"required,uuid"`
+	Subscriber     string `json:"subscriber_id,omitempty"   validate:"omitempty,uuid"`
+	SubscriberName string `json:"subscriber_name,omitempty"`
+	Publisher      string `json:"publisher_id,omitempty"    validate:"omitempty,uuid"`
+	PublisherName  string `json:"publisher_name,omitempty"`
+	Scope          string `json:"scope,omitempty"`
+	Title          string `json:"title,omitempty"`       // Title of the scope shown to the subject at the time
+	Description    string `json:"description,omitempty"` // Description of the scope shown to the subject at the time
+	NotBefore      int64  `json:"nbf,omitempty"`
+	Expire         int64  `json:"exp,omitempty"`
+	Automatic      bool   `json:"is_automatic,omitempty"`
+	Challenge      string `json:"challenge,omitempty"`
+}
+
+type ReadConsentsHistoryResponse []ConsentHistory
+type ReadConsentsHistoryRequest struct {
+	Reference  string `json:"reference_id"            validate:"required,uuid"`
+	Subscriber string `json:"subscriber_id,omitempty" validate:"omitempty,uuid"`
+	Publisher  string `json:"publisher_id,omitempty"  validate:"omitempty,uuid"`
+}
+
+// Kantara Initiative Consent Receipt Specification v1.1
+type ConsentReceipt struct {
+	Version          string                        `json:"version"`
+	Jurisdiction     string                        `json:"jurisdiction"`
+	ConsentTimestamp int64                         `json:"consentTimestamp"`
+	CollectionMethod string                        `json:"collectionMethod"`
+	ConsentReceiptId string                        `json:"consentReceiptID" validate:"required,uuid"`
+	PublicKey        string                        `json:"publicKey,omitempty"`
+	Language         string                        `json:"language,omitempty"`
+	PiiPrincipalId   string                        `json:"piiPrincipalId" validate:"required,uuid"`
+	PiiControllers   []ConsentReceiptPiiController `json:"piiControllers"`
+	PolicyUrl        string                        `json:"policyUrl"`
+	Services         []ConsentReceiptService       `json:"services"`
+	Sensitive        bool                          `json:"sensitive"`
+	SpiCat           []string                      `json:"spiCat"`
+}
+
+type ConsentReceiptPiiController struct {
+	PiiController    string            `json:"piiController"`
+	OnBehalf         bool              `json:"onBehalf,omitempty"`
+	Contact          string            `json:"contact"`
+	Address          map[string]string `json:"address"`
+	Email            string            `json:"email"`
+	Phone            string            `json:"phone"`
+	PiiControllerUrl string            `json:"piiControllerUrl,omitempty"`
+}
+
+type ConsentReceiptService struct {
+	Service  string                  `json:"service"`
+	Purposes []ConsentReceiptPurpose `json:"purposes"`
+}
+
+type ConsentReceiptPurpose struct {
+	Purpose              string   `json:"purpose"`
+	PurposeCategory      []string `json:"purposeCategory"`
+	ConsentType          string   `json:"consentType"`
+	PiiCategory          []string `json:"piiCategory"`
+	PrimaryPurpose       bool     `json:"primaryPurpose"`
+	Termination          string   `json:"termination"`
+	ThirdPartyDisclosure bool     `json:"thirdPartyDisclosure"`
+	ThirdPartyName       string   `json:"thirdPartyName,omitempty"`
+}
+
+type ReadConsentsReceiptsResponse ConsentReceipt
+type ReadConsentsReceiptsRequest struct {
+	Reference  string `json:"reference_id"            validate:"required,uuid"`
+	Subscriber string `json:"subscriber_id,omitempty" validate:"omitempty,uuid"`
+}
+
+func ReadConsentsHistory(client *AapClient, url string, requests []ReadConsentsHistoryRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "GET", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
+func ReadConsentsReceipts(client *AapClient, url string, requests []ReadConsentsReceiptsRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "GET", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
@@ -27,6 +27,63 @@ type Verdict struct {
 
 	// Introspected
 	Identity string `json:"identity_id"  validate:"omitempty,uuid"` // Subject access_token.sub
+
+	// Granted scopes inherited from shadowed identities
+	Shadows []VerdictShadow `json:"shadows,omitempty" validate:"omitempty,dive"`
+
+	// Only judged when subscriptions are enforced. Scopes the token client is not subscribed to, or the subject has not consented the client to use
+	MissingSubscriptions []string `json:"missing_subscriptions,omitempty"`
+	MissingConsents      []string `json:"missing_consents,omitempty"`
+
+	// Machine readable reason of denial, eg. step_up_required
+	Error   string          `json:"error,omitempty"`
+	StepUps []VerdictStepUp `json:"step_ups,omitempty" validate:"omitempty,dive"` // Authentication the subject must perform again when step_up_required
+
+	// Decision trace, only when requested using explain
+	Explanation *VerdictExplanation `json:"explanation,omitempty" validate:"omitempty"`
+}
+
+type VerdictStepUp struct {
+	Scope  string   `json:"scope"                validate:"required"`
+	Acr    []string `json:"acr_values,omitempty"`
+	Amr    []string `json:"amr_values,omitempty"`
+	MaxAge int64    `json:"max_age,omitempty"`
+}
+
+type VerdictShadow struct {
+	Scope  string `json:"scope"     validate:"required"`
+	Shadow string `json:"shadow_id" validate:"required,uuid"` // Identity which supplied the grant
+}
+
+type VerdictExplanation struct {
+	Reason        string                          `json:"reason,omitempty"`
+	Introspection VerdictExplanationIntrospection `json:"introspection"`
+	Scopes        []VerdictExplanationScope       `json:"scopes"`
+	Owners        []string                        `json:"owners"         validate:"omitempty,dive,uuid"` // Owners of the accepted grants
+	MissingScopes []string                        `json:"missing_scopes"`
+}
+
+type VerdictExplanationIntrospection struct {
+	Active   bool   `json:"is_active"`
+	Subject  string `json:"sub,omitempty"       validate:"omitempty,uuid"`
+	ClientId string `json:"client_id,omitempty" validate:"omitempty,uuid"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type VerdictExplanationScope struct {
+	Scope     string                    `json:"scope"`
+	Published bool                      `json:"is_published"`
+	Granted   bool                      `json:"is_granted"`
+	Grants    []VerdictExplanationGrant `json:"grants"`
+}
+
+type VerdictExplanationGrant struct {
+	Path      []string `json:"path"      validate:"omitempty,dive,uuid"` // From the requestor through shadowed identities to the identity holding the grant
+	Owner     string   `json:"owner_id"  validate:"required,uuid"`
+	NotBefore int64    `json:"nbf"`
+	Expire    int64    `json:"exp"`
+	Accepted  bool     `json:"is_accepted"`
+	Rejected  string   `json:"rejected_reason,omitempty"` // not_yet_valid, expired, shadow_not_valid or owner_not_requested
 }
 
 // AAP requires all calls to be HTTP override post. This prevenst leaking of access token into by accident into access log like with normal GET requests.
@@ -36,6 +93,15 @@ type ReadEntitiesJudgeRequest struct {
 	Publisher   string   `json:"publisher_id"     validate:"required,uuid"` // Resource Server Audience
 	Scope       string   `json:"scope"            validate:"required"`
 	Owners      []string `json:"owners,omitempty" validate:"omitempty,dive,uuid"` // Resource Owners (often publisher or Subject)
+	Explain     bool     `json:"explain,omitempty"`                               // Return a decision trace with the verdict
+}
+
+// Counters of the judge verdict cache, since start of the aap instance answering.
+type ReadEntitiesJudgeCacheResponse struct {
+	Enabled bool   `json:"is_enabled"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
 }
 
 func CreateEntities(client *AapClient, url string, requests []CreateEntitiesRequest) (status int, responses bulky.Responses, err error) {
@@ -1,6 +1,8 @@
 package errors
 
 import (
+	"strconv"
+
 	bulky "github.com/charmixer/bulky/errors"
 )
 
@@ -13,6 +15,15 @@ const INTERNAL_SERVER_ERROR = 5
 const CONSENT_NOT_FOUND = 10
 const NO_SUBSCRIPTIONS = 11
 const INVALID_SCOPES = 12
+const PUBLISH_HAS_DEPENDENTS = 13
+const SCOPE_NOT_FOUND = 14
+const MAY_GRANT_REQUIRED = 15
+const REQUIRED_SCOPES = 16
+const SUBSCRIPTION_NOT_FOUND = 17
+const APPROVAL_NOT_ALLOWED = 18
+const NO_CONSENTS = 19
+const TRUST_NOT_ALLOWED = 20
+const WEBHOOK_NOT_ALLOWED = 21
 
 func InitRestErrors() {
 	bulky.AppendErrors(
@@ -29,6 +40,145 @@ func InitRestErrors() {
 				"en":  "Invalid scopes",
 				"dev": "Invalid scopes. Hint: Atleast one requested scope is not subscribed for any audience.",
 			},
+			PUBLISH_HAS_DEPENDENTS: {
+				"en":  "Published scope is in use",
+				"dev": "Published scope is in use. Hint: Grants, subscriptions or consents depend on the publishing, use cascade to delete them as well.",
+			},
+			SCOPE_NOT_FOUND: {
+				"en":  "Not found",
+				"dev": "Scope not found",
+			},
+			MAY_GRANT_REQUIRED: {
+				"en":  "Not allowed to grant scope",
+				"dev": "Not allowed to grant scope. Hint: Requestor is missing a valid may grant (mg:<scope>) grant on behalf of the owner from the publisher.",
+			},
+			REQUIRED_SCOPES: {
+				"en":  "Required scopes must be accepted",
+				"dev": "Required scopes must be accepted. Hint: Atleast one consent request of a required subscription is missing from the accepted consents.",
+			},
+			SUBSCRIPTION_NOT_FOUND: {
+				"en":  "Not found",
+				"dev": "Subscription not found",
+			},
+			APPROVAL_NOT_ALLOWED: {
+				"en":  "Not allowed to approve subscriptions",
+				"dev": "Not allowed to approve subscriptions. Hint: Requestor is missing a grant of aap:update:subscriptions:approvals on behalf of the publisher.",
+			},
+			NO_CONSENTS: {
+				"en":  "No consents accepted",
+				"dev": "No consents accepted. Hint: Accept atleast one consent request or reject the consent challenge.",
+			},
+			TRUST_NOT_ALLOWED: {
+				"en":  "Not allowed to trust subscriptions",
+				"dev": "Not allowed to trust subscriptions. Hint: Requestor is missing a grant of aap:update:subscriptions:trust on behalf of the publisher.",
+			},
+			WEBHOOK_NOT_ALLOWED: {
+				"en":  "Not allowed to manage webhooks of the publisher",
+				"dev": "Not allowed to manage webhooks of the publisher. Hint: Requestor is missing a grant of the webhooks scope of the endpoint on behalf of the publisher of the webhook.",
+			},
 		},
 	)
+
+	appendTranslations(
+		map[int]map[string]string{
+			bulky.INTERNAL_SERVER_ERROR: {
+				"da": "Der opstod en intern fejl. Vent venligst til den er rettet, før du prøver igen",
+				"de": "Ein interner Fehler ist aufgetreten. Bitte warten Sie, bis er behoben ist, bevor Sie es erneut versuchen",
+			},
+			bulky.EMPTY_REQUEST_NOT_ALLOWED: {
+				"da": "Tom forespørgsel er ikke tilladt",
+				"de": "Leere Anfrage nicht erlaubt",
+			},
+			bulky.MAX_REQUESTS_EXCEEDED: {
+				"da": "Maksimalt antal forespørgsler overskredet",
+				"de": "Maximale Anzahl von Anfragen überschritten",
+			},
+			bulky.OPERATION_ABORTED: {
+				"da": "Handlingen blev afbrudt på grund af andre fejl",
+				"de": "Vorgang wegen anderer Fehler abgebrochen",
+			},
+			CONSENT_NOT_FOUND: {
+				"da": "Ikke fundet",
+				"de": "Nicht gefunden",
+			},
+			NO_SUBSCRIPTIONS: {
+				"da": "Ingen abonnementer",
+				"de": "Keine Abonnements",
+			},
+			INVALID_SCOPES: {
+				"da": "Ugyldige rettigheder",
+				"de": "Ungültige Berechtigungen",
+			},
+			PUBLISH_HAS_DEPENDENTS: {
+				"da": "Den udgivne rettighed er i brug",
+				"de": "Die veröffentlichte Berechtigung wird verwendet",
+			},
+			SCOPE_NOT_FOUND: {
+				"da": "Ikke fundet",
+				"de": "Nicht gefunden",
+			},
+			MAY_GRANT_REQUIRED: {
+				"da": "Ikke tilladt at tildele rettigheden",
+				"de": "Nicht berechtigt, die Berechtigung zu erteilen",
+			},
+			REQUIRED_SCOPES: {
+				"da": "Påkrævede rettigheder skal accepteres",
+				"de": "Erforderliche Berechtigungen müssen akzeptiert werden",
+			},
+			SUBSCRIPTION_NOT_FOUND: {
+				"da": "Ikke fundet",
+				"de": "Nicht gefunden",
+			},
+			APPROVAL_NOT_ALLOWED: {
+				"da": "Ikke tilladt at godkende abonnementer",
+				"de": "Nicht berechtigt, Abonnements zu genehmigen",
+			},
+			NO_CONSENTS: {
+				"da": "Ingen samtykker accepteret",
+				"de": "Keine Einwilligungen akzeptiert",
+			},
+			TRUST_NOT_ALLOWED: {
+				"da": "Ikke tilladt at betro abonnementer",
+				"de": "Nicht berechtigt, Abonnements zu vertrauen",
+			},
+			WEBHOOK_NOT_ALLOWED: {
+				"da": "Ikke tilladt at administrere udgiverens webhooks",
+				"de": "Nicht berechtigt, die Webhooks des Herausgebers zu verwalten",
+			},
+		},
+	)
+}
+
+// appendTranslations adds messages in more languages to errors already registered
+func appendTranslations(translations map[int]map[string]string) {
+	for code, messages := range translations {
+		e, exists := bulky.MAP[code]
+		if !exists {
+			panic("Error code '" + strconv.Itoa(code) + "' not defined")
+		}
+
+		for locale, message := range messages {
+			e[locale] = message
+		}
+	}
+}
+
+// Localize returns the message of the error in the first of the locales it exists in. Input validation errors are never localized as their messages are specific to the failed field.
+func Localize(code int, locales []string) (message string, exists bool) {
+	if code == bulky.INPUT_VALIDATION_FAILED {
+		return "", false
+	}
+
+	e, exists := bulky.MAP[code]
+	if !exists {
+		return "", false
+	}
+
+	for _, locale := range locales {
+		if message, exists = e[locale]; exists {
+			return message, true
+		}
+	}
+
+	return "", false
 }
@@ -7,11 +7,24 @@ import (
 // /scopes
 
 type Publish struct {
-	Publisher      string   `json:"publisher_id" validate:"required,uuid"`
-	Scope          string   `json:"scope" validate:"required"`
-	MayGrantScopes []string `json:"may_grant_scopes" validate:"omitempty"`
-	Title          string   `json:"title"`
-	Description    string   `json:"description"`
+	Publisher        string                        `json:"publisher_id" validate:"required,uuid"`
+	Scope            string                        `json:"scope" validate:"required"`
+	MayGrantScopes   []string                      `json:"may_grant_scopes" validate:"omitempty"`
+	Title            string                        `json:"title"`
+	Description      string                        `json:"description"`
+	Translations     map[string]PublishTranslation `json:"translations,omitempty"`
+	ApprovalRequired bool                          `json:"is_approval_required"`
+
+	// Step-up authentication required to use the scope
+	Acr    []string `json:"acr_values,omitempty"`
+	Amr    []string `json:"amr_values,omitempty"`
+	MaxAge int64    `json:"max_age,omitempty"`
+}
+
+// Title and description of a publishing in another language, keyed by language tag (BCP 47), eg. da or de-AT
+type PublishTranslation struct {
+	Title       string `json:"title" validate:"required"`
+	Description string `json:"description" validate:"required"`
 }
 
 type CreatePublishesResponse Publish
@@ -20,6 +33,15 @@ type CreatePublishesRequest struct {
 	Scope       string `json:"scope" validate:"required,excludes= "`
 	Title       string `json:"title" validate:"required"`
 	Description string `json:"description" validate:"required"`
+
+	Translations map[string]PublishTranslation `json:"translations,omitempty" validate:"omitempty,dive,keys,required,excludesall= :,endkeys"`
+
+	ApprovalRequired bool `json:"is_approval_required,omitempty"` // Subscriptions are pending until approved on behalf of the publisher
+
+	// Step-up authentication required to use the scope. Tokens not satisfying it are denied with step_up_required
+	Acr    []string `json:"acr_values,omitempty" validate:"omitempty,dive,required"` // Any of the authentication context classes, eg. urn:mace:incommon:iap:silver
+	Amr    []string `json:"amr_values,omitempty" validate:"omitempty,dive,required"` // All of the authentication methods, eg. pwd otp
+	MaxAge int64    `json:"max_age,omitempty"    validate:"gte=0"`                   // Max seconds since the subject authenticated
 }
 
 type UpdatePublishesResponse Publish
@@ -27,6 +49,20 @@ type UpdatePublishesRequest struct {
 	Publisher string `json:"publisher_id,omitempty" validate:"omitempty,uuid"`
 }
 
+type DeletePublishesResponse struct {
+	Publisher       string   `json:"publisher_id" validate:"required,uuid"`
+	Scope           string   `json:"scope" validate:"required"`
+	Grants          int64    `json:"grants_deleted"`
+	Subscriptions   int64    `json:"subscriptions_deleted"`
+	Consents        int64    `json:"consents_deleted"`
+	HydraSyncFailed []string `json:"hydra_sync_failed,omitempty"` // Subscribers whose hydra clients could not be synced and still hold the scope
+}
+type DeletePublishesRequest struct {
+	Publisher string `json:"publisher_id" validate:"required,uuid"`
+	Scope     string `json:"scope" validate:"required"`
+	Cascade   bool   `json:"cascade"` // Delete grants, subscriptions and consents depending on the publishing instead of refusing
+}
+
 type ReadPublishesResponse []Publish
 type ReadPublishesRequest struct {
 	Publisher string   `json:"publisher_id,omitempty" validate:"omitempty,uuid"`
@@ -62,3 +98,13 @@ func UpdatePublishes(client *AapClient, url string, requests []UpdatePublishesRe
 
 	return status, responses, nil
 }
+
+func DeletePublishes(client *AapClient, url string, requests []DeletePublishesRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "DELETE", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
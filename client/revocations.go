@@ -0,0 +1,49 @@
+package client
+
+import (
+	bulky "github.com/charmixer/bulky/client"
+)
+
+type Revocation struct {
+	Id        string `json:"id" validate:"required,uuid"`
+	Jti       string `json:"jti,omitempty"`
+	Subject   string `json:"sub,omitempty" validate:"omitempty,uuid"`
+	ClientId  string `json:"client_id,omitempty" validate:"omitempty,uuid"`
+	RevokedAt int64  `json:"revoked_at" validate:"gte=0"`
+	Expire    int64  `json:"exp" validate:"gte=0"`
+}
+
+type CreateRevocationsResponse Revocation
+type CreateRevocationsRequest struct {
+	Jti      string `json:"jti,omitempty" validate:"required_without_all=Subject ClientId"`
+	Subject  string `json:"sub,omitempty" validate:"omitempty,uuid"`
+	ClientId string `json:"client_id,omitempty" validate:"omitempty,uuid"`
+	Expire   int64  `json:"exp,omitempty" validate:"gte=0"` // Expire of the revoked token(s). Defaults to now + max access token lifetime
+}
+
+type ReadRevocationsResponse []Revocation
+type ReadRevocationsRequest struct {
+	Jti      string `json:"jti,omitempty"`
+	Subject  string `json:"sub,omitempty" validate:"omitempty,uuid"`
+	ClientId string `json:"client_id,omitempty" validate:"omitempty,uuid"`
+}
+
+func CreateRevocations(client *AapClient, url string, requests []CreateRevocationsRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "POST", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
+func ReadRevocations(client *AapClient, url string, requests []ReadRevocationsRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "GET", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
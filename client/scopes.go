@@ -7,7 +7,11 @@ import (
 // /scopes
 
 type Scope struct {
-	Scope string `json:"scope" validate:"required"`
+	Scope       string `json:"scope" validate:"required"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Sensitivity string `json:"sensitivity,omitempty" validate:"omitempty,oneof=public internal confidential restricted"`
+	Deprecated  bool   `json:"deprecated"`
 }
 
 type CreateScopesResponse Scope
@@ -16,8 +20,14 @@ type CreateScopesRequest struct {
 }
 
 type UpdateScopesResponse Scope
+
+// Only the given fields are updated, omitted fields keep their value
 type UpdateScopesRequest struct {
-	Scope string `json:"scope" validate:"required"`
+	Scope       string  `json:"scope" validate:"required"`
+	Title       *string `json:"title,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Sensitivity *string `json:"sensitivity,omitempty" validate:"omitempty,oneof=public internal confidential restricted"`
+	Deprecated  *bool   `json:"deprecated,omitempty"`
 }
 
 type ReadScopesResponse []Scope
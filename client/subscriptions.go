@@ -8,6 +8,11 @@ type Subscription struct {
 	Subscriber string `json:"subscriber_id" validate:"required,uuid"`
 	Publisher  string `json:"publisher_id" validate:"required,uuid"`
 	Scope      string `json:"scope" validate:"required"`
+	Required   bool   `json:"is_required"`
+	Trusted    bool   `json:"is_trusted"`
+	State      string `json:"state" validate:"required,oneof=pending approved rejected"` // Only approved subscriptions can be requested by the subscriber
+	DecidedBy  string `json:"decided_by,omitempty" validate:"omitempty,uuid"`
+	DecidedAt  int64  `json:"decided_at,omitempty"`
 }
 
 type CreateSubscriptionsResponse Subscription
@@ -15,20 +20,38 @@ type CreateSubscriptionsRequest struct {
 	Subscriber string `json:"subscriber_id" validate:"required,uuid"`
 	Publisher  string `json:"publisher_id" validate:"required,uuid"`
 	Scope      string `json:"scope" validate:"required"`
+	Required   bool   `json:"is_required,omitempty"` // The subject cannot deselect the scope when consenting
+	Trusted    bool   `json:"is_trusted,omitempty"`  // First party subscriber. Consent is given automatically without prompting the subject. Requires aap:update:subscriptions:trust on behalf of the publisher
 }
 
-type DeleteSubscriptionsResponse struct{}
-type DeleteSubscriptionsRequest struct {
+type DeleteSubscriptionsResponse struct {
 	Subscriber string `json:"subscriber_id" validate:"required,uuid"`
 	Publisher  string `json:"publisher_id" validate:"required,uuid"`
 	Scope      string `json:"scope" validate:"required"`
+	Deleted    bool   `json:"is_deleted"`       // False if no subscription existed
+	Consents   int64  `json:"consents_deleted"` // Number of consents deleted with the subscription
+}
+type DeleteSubscriptionsRequest struct {
+	Subscriber     string `json:"subscriber_id" validate:"required,uuid"`
+	Publisher      string `json:"publisher_id" validate:"required,uuid"`
+	Scope          string `json:"scope" validate:"required"`
+	DeleteConsents bool   `json:"delete_consents"` // Also delete consents given to the subscriber on the scope
 }
 
 type ReadSubscriptionsResponse []Subscription
 type ReadSubscriptionsRequest struct {
-	Subscriber string   `json:"subscriber_id" validate:"required,uuid"`
+	Subscriber string   `json:"subscriber_id,omitempty" validate:"omitempty,uuid"`
 	Publisher  string   `json:"publisher_id,omitempty" validate:"omitempty,uuid"`
 	Scopes     []string `json:"scopes" validate:"omitempty"`
+	State      string   `json:"state,omitempty" validate:"omitempty,oneof=pending approved rejected"` // Use pending to list subscriptions awaiting approval
+}
+
+type UpdateSubscriptionsApprovalsResponse Subscription
+type UpdateSubscriptionsApprovalsRequest struct {
+	Subscriber string `json:"subscriber_id" validate:"required,uuid"`
+	Publisher  string `json:"publisher_id" validate:"required,uuid"`
+	Scope      string `json:"scope" validate:"required"`
+	State      string `json:"state" validate:"required,oneof=approved rejected"`
 }
 
 func CreateSubscriptions(client *AapClient, url string, requests []CreateSubscriptionsRequest) (status int, responses bulky.Responses, err error) {
@@ -60,3 +83,13 @@ func ReadSubscriptions(client *AapClient, url string, requests []ReadSubscriptio
 
 	return status, responses, nil
 }
+
+func UpdateSubscriptionsApprovals(client *AapClient, url string, requests []UpdateSubscriptionsApprovalsRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "PUT", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	bulky "github.com/charmixer/bulky/client"
+)
+
+type Webhook struct {
+	Id        string   `json:"id" validate:"required,uuid"`
+	Url       string   `json:"url" validate:"required,url"`
+	Events    []string `json:"events" validate:"required,min=1"`
+	Publisher string   `json:"publisher_id" validate:"required,uuid"`
+	CreatedAt int64    `json:"created_at" validate:"gte=0"`
+}
+
+type CreateWebhooksResponse Webhook
+type CreateWebhooksRequest struct {
+	Url       string   `json:"url" validate:"required,url"`
+	Events    []string `json:"events" validate:"required,min=1,dive,required"` // Event types, eg. aap.consent.created, aap.consent.* or *
+	Secret    string   `json:"secret" validate:"required,min=16"`              // Key of the HMAC-SHA256 signature sent in X-Aap-Signature
+	Publisher string   `json:"publisher_id" validate:"required,uuid"`          // Registered on behalf of, only events concerning the publisher are delivered
+}
+
+type ReadWebhooksResponse []Webhook
+type ReadWebhooksRequest struct {
+	Id string `json:"id,omitempty" validate:"omitempty,uuid"`
+}
+
+type DeleteWebhooksResponse struct {
+	Id string `json:"id" validate:"required,uuid"`
+}
+type DeleteWebhooksRequest struct {
+	Id string `json:"id" validate:"required,uuid"`
+}
+
+type WebhookDeliveryAttempt struct {
+	At         int64  `json:"at"`
+	StatusCode int64  `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Duration   int64  `json:"duration"` // Milliseconds
+}
+
+type WebhookDelivery struct {
+	Id            string                   `json:"id" validate:"required,uuid"`
+	Webhook       string                   `json:"webhook_id" validate:"required,uuid"`
+	EventId       string                   `json:"event_id" validate:"required"`
+	EventType     string                   `json:"event_type" validate:"required"`
+	Status        string                   `json:"status" validate:"required,oneof=pending delivered dead"`
+	Attempts      int64                    `json:"attempts" validate:"gte=0"`
+	NextAttemptAt int64                    `json:"next_attempt_at,omitempty"`
+	CreatedAt     int64                    `json:"created_at" validate:"gte=0"`
+	AttemptLog    []WebhookDeliveryAttempt `json:"attempt_log,omitempty"`
+}
+
+type ReadWebhookDeliveriesResponse []WebhookDelivery
+type ReadWebhookDeliveriesRequest struct {
+	Webhook string `json:"webhook_id,omitempty" validate:"omitempty,uuid"`
+	Status  string `json:"status,omitempty" validate:"omitempty,oneof=pending delivered dead"` // Use dead to inspect the dead letter list
+}
+
+type CreateWebhookDeliveriesReplayResponse struct {
+	Webhook  string `json:"webhook_id" validate:"required,uuid"`
+	Replayed int64  `json:"replayed" validate:"gte=0"`
+}
+type CreateWebhookDeliveriesReplayRequest struct {
+	Webhook    string   `json:"webhook_id" validate:"required,uuid"`
+	Deliveries []string `json:"deliveries,omitempty" validate:"omitempty,dive,uuid"` // Dead deliveries to replay. Defaults to all dead deliveries of the webhook
+}
+
+func CreateWebhooks(client *AapClient, url string, requests []CreateWebhooksRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "POST", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
+func ReadWebhooks(client *AapClient, url string, requests []ReadWebhooksRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "GET", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
+func DeleteWebhooks(client *AapClient, url string, requests []DeleteWebhooksRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "DELETE", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
+func ReadWebhookDeliveries(client *AapClient, url string, requests []ReadWebhookDeliveriesRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "GET", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
+
+func CreateWebhookDeliveriesReplay(client *AapClient, url string, requests []CreateWebhookDeliveriesReplayRequest) (status int, responses bulky.Responses, err error) {
+	status, err = handleRequest(client, requests, "POST", url, &responses)
+
+	if err != nil {
+		return status, nil, err
+	}
+
+	return status, responses, nil
+}
@@ -8,6 +8,43 @@ import (
 func setDefaults() {
 	viper.SetDefault("config.app.path", "./app.yml")
 	viper.SetDefault("config.discovery.path", "./discovery.yml")
+
+	viper.SetDefault("hydra.private.endpoints.consentSessions", "/oauth2/auth/sessions/consent")
+
+	viper.SetDefault("judge.shadows.depth", 3)         // Max number of shadow grant rules followed when judging
+	viper.SetDefault("judge.requests.max", 50)         // Max judge requests in one call to /entities/judge
+	viper.SetDefault("judge.subscriptions.enforce", 0) // 1 = the token client must be subscribed, and for subjects consented, to the judged scopes at the publisher
+	viper.SetDefault("judge.audience.relax", 0)        // 1 = judge tokens regardless of their aud, opting out of the check. Override per publisher with judge.publishers.<publisher id>.audience.relax
+	viper.SetDefault("judge.cache.enabled", 1)
+	viper.SetDefault("judge.cache.ttl", 60) // Max seconds a verdict is cached. Entries never outlive the access token
+	viper.SetDefault("judge.cache.size", 10000)
+	viper.SetDefault("outbox.relay.interval", 5) // Seconds between polls of the event outbox
+	viper.SetDefault("outbox.relay.batch", 100)
+	viper.SetDefault("outbox.relay.timeout", 5) // Seconds to wait for nats to acknowledge a batch
+	viper.SetDefault("outbox.relay.backoff.min", 1)
+	viper.SetDefault("outbox.relay.backoff.max", 300)
+	viper.SetDefault("webhooks.delivery.interval", 5) // Seconds between polls for due webhook deliveries
+	viper.SetDefault("webhooks.delivery.batch", 100)
+	viper.SetDefault("webhooks.delivery.timeout", 10)        // Seconds to wait for a webhook to respond
+	viper.SetDefault("webhooks.delivery.attempts", 8)        // Failed attempts before a delivery is dead lettered
+	viper.SetDefault("webhooks.retention.delivered", 604800) // Seconds to keep delivered deliveries and their attempts. 0 = kept forever
+	viper.SetDefault("webhooks.retention.dead", 2592000)     // Seconds to keep dead lettered deliveries for replays. 0 = kept forever
+	viper.SetDefault("webhooks.delivery.backoff.min", 5)
+	viper.SetDefault("webhooks.delivery.backoff.max", 3600)
+	viper.SetDefault("consents.lifetime", 0)          // Seconds a consent lives when not given an exp. 0 = never expires. Override per publisher with consents.publishers.<publisher id>.lifetime
+	viper.SetDefault("consents.sweeper.interval", 60) // Seconds between sweeps for expired consents
+	viper.SetDefault("consents.sweeper.batch", 100)
+	viper.SetDefault("consents.receipts.language", "en")
+	viper.SetDefault("consents.receipts.collection", "OAuth2 consent challenge") // How consents are collected, shown as collectionMethod in receipts
+	viper.SetDefault("apps.usage.interval", 60)                                  // Seconds between writes of when subjects last used clients
+	viper.SetDefault("locales.default", "en")                                    // Language of untranslated texts, eg. publish titles and descriptions
+	viper.SetDefault("locales.fallback", []string{"en"})                         // Language tags tried when none of the preferred locales of the subject has a translation
+	viper.SetDefault("tokens.claims.grants.enabled", 0)                          // 1 = project the grants of the subject into tokens when consent is accepted
+	viper.SetDefault("tokens.claims.grants.owners", 1)                           // 1 = project the owners of the grants as well, if they fit the budget
+	viper.SetDefault("tokens.claims.grants.budget", 2048)                        // Max bytes of the projected grants. 0 = no limit
+	viper.SetDefault("tokens.claims.grants.id_token", 0)                         // 1 = project the grants into the id token as well as the access token
+	viper.SetDefault("revocations.ttl", 3600)                                    // Seconds a revocation lives when the expire of the revoked token(s) is unknown. Should be at least the access token lifespan
+	viper.SetDefault("oauth2.tokens.verify.local", 0)                            // 1 = verify JWT access tokens using the provider JWKS instead of introspection
 }
 
 func GetInt(key string) int {
@@ -18,6 +55,10 @@ func GetIntStrict(key string) int {
 	return viper.GetInt(key)
 }
 
+func IsSet(key string) bool {
+	return viper.IsSet(key)
+}
+
 func GetString(key string) string {
 	return viper.GetString(key)
 }
@@ -26,6 +67,10 @@ func GetStringStrict(key string) string {
 	return viper.GetString(key)
 }
 
+func GetStringMapString(key string) map[string]string {
+	return viper.GetStringMapString(key)
+}
+
 func GetStringSlice(key string) []string {
 	return viper.GetStringSlice(key)
 }
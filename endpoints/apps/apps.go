@@ -0,0 +1,350 @@
+package apps
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+
+	"github.com/opensentry/aap/app"
+	"github.com/opensentry/aap/client"
+	E "github.com/opensentry/aap/client/errors"
+	"github.com/opensentry/aap/config"
+	"github.com/opensentry/aap/gateway/aap"
+
+	hydra "github.com/charmixer/hydra/client"
+
+	bulky "github.com/charmixer/bulky/server"
+)
+
+type publisherScope struct {
+	Publisher, Scope string
+}
+
+func GetApps(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "GetApps",
+		})
+
+		var requests []client.ReadAppsRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := aap.BeginReadTx(env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			iOwner := aap.Identity{Id: c.MustGet("sub").(string)}
+
+			clientsUrl := config.GetString("hydra.private.url") + config.GetString("hydra.private.endpoints.clients")
+			clientNames := make(map[string]string)
+
+			for _, request := range iRequests {
+				var r client.ReadAppsRequest
+				if request.Input != nil {
+					r = request.Input.(client.ReadAppsRequest)
+				}
+
+				iFilterSubscriber := aap.Identity{Id: r.ClientId}
+				locales := app.PreferredLocales(r.Locale, nil)
+
+				dbConsents, err := aap.FetchConsents(tx, iOwner, iFilterSubscriber, aap.Identity{}, nil)
+				if err != nil {
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+					log.Debug(err.Error())
+					return
+				}
+
+				dbPublishes, err := aap.FetchPublishes(tx, aap.Identity{}, nil)
+				if err != nil {
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+					log.Debug(err.Error())
+					return
+				}
+				publishes := make(map[publisherScope]aap.Publish)
+				for _, p := range dbPublishes {
+					publishes[publisherScope{p.Publisher.Id, p.Scope.Name}] = p
+				}
+
+				dbHistory, err := aap.FetchConsentHistory(tx, iOwner, iFilterSubscriber, aap.Identity{})
+				if err != nil {
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+					log.Debug(err.Error())
+					return
+				}
+				// History is ordered by time, so the last grant wins
+				consentedAt := make(map[string]map[publisherScope]int64)
+				for _, h := range dbHistory {
+					if h.Action != aap.CONSENT_HISTORY_GRANTED {
+						continue
+					}
+					if consentedAt[h.Subscriber.Id] == nil {
+						consentedAt[h.Subscriber.Id] = make(map[publisherScope]int64)
+					}
+					consentedAt[h.Subscriber.Id][publisherScope{h.Publisher.Id, h.Scope.Name}] = h.At
+				}
+
+				dbUsages, err := aap.FetchClientUsages(tx, iOwner, iFilterSubscriber)
+				if err != nil {
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+					log.Debug(err.Error())
+					return
+				}
+				lastUsedAt := make(map[string]int64)
+				for _, u := range dbUsages {
+					lastUsedAt[u.Subscriber.Id] = u.LastUsedAt
+				}
+
+				var ok = client.ReadAppsResponse{}
+				apps := make(map[string]int)
+				publishers := make(map[string]map[string]int)
+
+				for _, consent := range dbConsents {
+					clientId := consent.Subscriber.Id
+
+					a, exists := apps[clientId]
+					if !exists {
+						name, known := clientNames[clientId]
+						if !known {
+							hydraClient, err := hydra.ReadClient(clientsUrl, clientId)
+							if err != nil {
+								log.WithFields(logrus.Fields{"client_id": clientId}).Debug(err.Error()) // The app is still listed, only without a name
+							}
+							name = hydraClient.Name
+							clientNames[clientId] = name
+						}
+
+						ok = append(ok, client.App{
+							ClientId:   clientId,
+							ClientName: name,
+							LastUsedAt: lastUsedAt[clientId],
+							Publishers: []client.AppPublisher{},
+						})
+						a = len(ok) - 1
+						apps[clientId] = a
+						publishers[clientId] = make(map[string]int)
+					}
+
+					p, exists := publishers[clientId][consent.Publisher.Id]
+					if !exists {
+						ok[a].Publishers = append(ok[a].Publishers, client.AppPublisher{Publisher: consent.Publisher.Id, Scopes: []client.AppScope{}})
+						p = len(ok[a].Publishers) - 1
+						publishers[clientId][consent.Publisher.Id] = p
+					}
+
+					key := publisherScope{consent.Publisher.Id, consent.Scope.Name}
+					title, description, _ := app.LocalizePublishRule(publishes[key].Rule, locales)
+
+					ok[a].Publishers[p].Scopes = append(ok[a].Publishers[p].Scopes, client.AppScope{
+						Scope:       consent.Scope.Name,
+						Title:       title,
+						Description: description,
+						ConsentedAt: consentedAt[clientId][key],
+						NotBefore:   consent.ConsentRule.NotBefore,
+						Expire:      consent.ConsentRule.Expire,
+						Automatic:   consent.ConsentRule.Automatic,
+					})
+				}
+
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+			}
+
+			bulky.OutputValidateRequests(iRequests)
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{EnableEmptyRequest: true})
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// DeleteApps withdraws every consent the subject has given the client and revokes the access already given, like DELETE /consents does per consent.
+func DeleteApps(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "DeleteApps",
+		})
+
+		var requests []client.DeleteAppsRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Create a new HTTP client to perform the request, to prevent serialization
+		hydraClient := hydra.NewHydraClient(env.OAuth2Delegator.Config)
+
+		var createdRevocations []aap.Revocation
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := aap.BeginWriteTx(env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			var events []aap.Event
+
+			iOwner := aap.Identity{Id: c.MustGet("sub").(string)}
+
+			var revocations []aap.Revocation
+			oks := make(map[*bulky.Request]client.DeleteAppsResponse)
+
+			for _, request := range iRequests {
+				r := request.Input.(client.DeleteAppsRequest)
+
+				iSubscriber := aap.Identity{Id: r.ClientId}
+
+				dbConsents, err := aap.FetchConsents(tx, iOwner, iSubscriber, aap.Identity{}, nil)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(dbConsents) <= 0 {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.CONSENT_NOT_FOUND)
+					return
+				}
+
+				for _, consent := range dbConsents {
+					_, err := aap.DeleteConsent(tx, consent.Identity, consent.Subscriber, consent.Publisher, consent.Scope)
+					if err != nil {
+						e := tx.Rollback()
+						if e != nil {
+							log.Debug(e.Error())
+						}
+						bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+						request.Output = bulky.NewInternalErrorResponse(request.Index)
+						log.Debug(err.Error())
+						return
+					}
+
+					events = append(events, app.NewEvent(env, c, aap.EVENT_CONSENT_DELETED, aap.EventConsent{
+						Identity:   consent.Identity.Id,
+						Subscriber: consent.Subscriber.Id,
+						Publisher:  consent.Publisher.Id,
+						Scope:      consent.Scope.Name,
+						NotBefore:  consent.ConsentRule.NotBefore,
+						Expire:     consent.ConsentRule.Expire,
+						Automatic:  consent.ConsentRule.Automatic,
+					}))
+				}
+
+				revocation, err := aap.CreateRevocation(tx, aap.Revocation{
+					Subject:  iOwner.Id,
+					ClientId: iSubscriber.Id,
+					Expire:   time.Now().Unix() + int64(config.GetInt("revocations.ttl")),
+				})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+				revocations = append(revocations, revocation)
+
+				events = append(events, app.NewEvent(env, c, aap.EVENT_REVOCATION_CREATED, aap.EventRevocation{
+					Id:        revocation.Id,
+					Jti:       revocation.Jti,
+					Subject:   revocation.Subject,
+					ClientId:  revocation.ClientId,
+					RevokedAt: revocation.RevokedAt,
+					Expire:    revocation.Expire,
+				}))
+
+				ok := client.DeleteAppsResponse{
+					ClientId:   iSubscriber.Id,
+					Consents:   int64(len(dbConsents)),
+					Revocation: revocation.Id,
+				}
+				oks[request] = ok
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				err = app.StageEvents(tx, events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+
+				err = tx.Commit()
+				if err != nil {
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+				app.InvalidateVerdicts(env)
+				createdRevocations = revocations
+
+				for request, ok := range oks {
+					err := aap.RevokeHydraConsentSessions(hydraClient, iOwner, aap.Identity{Id: ok.ClientId})
+					if err != nil {
+						log.WithFields(logrus.Fields{"subject": iOwner.Id, "client_id": ok.ClientId}).Debug(err.Error())
+						ok.SessionsError = err.Error()
+					}
+					ok.SessionsRevoked = ok.SessionsError == ""
+					request.Output = bulky.NewOkResponse(request.Index, ok)
+				}
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{})
+
+		// Revoke in this instance right away, the other instances are told by the outbox relay
+		for _, revocation := range createdRevocations {
+			env.Revocations.Add(revocation)
+		}
+		env.Outbox.Notify()
+
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
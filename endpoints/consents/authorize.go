@@ -5,13 +5,16 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/opensentry/aap/app"
 	"github.com/opensentry/aap/client"
 	E "github.com/opensentry/aap/client/errors"
 	"github.com/opensentry/aap/config"
 	"github.com/opensentry/aap/gateway/aap"
+	"github.com/opensentry/aap/utils"
 
 	bulky "github.com/charmixer/bulky/server"
 
@@ -46,6 +49,13 @@ type ConsentChallenge struct {
 
 	RequestedScopes    []string
 	RequestedAudiences []string
+
+	UiLocales []string
+
+	// Authentication of the subject, claimed in access tokens for step-up
+	Acr      string
+	Amr      []string
+	AuthTime int64
 }
 
 func GetAuthorize(env *app.Environment) gin.HandlerFunc {
@@ -66,9 +76,12 @@ func GetAuthorize(env *app.Environment) gin.HandlerFunc {
 		// Create a new HTTP client to perform the request, to prevent serialization
 		hydraClient := hydra.NewHydraClient(env.OAuth2Delegator.Config)
 
+		// Preferred locales of the subject, used for consent texts and error messages
+		var locales = app.PreferredLocales("", nil)
+
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := aap.BeginReadTx(env.Driver)
+			session, tx, err := aap.BeginWriteTx(env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -81,8 +94,9 @@ func GetAuthorize(env *app.Environment) gin.HandlerFunc {
 				r := request.Input.(client.ReadConsentsAuthorizeRequest)
 
 				log = log.WithFields(logrus.Fields{"challenge": r.Challenge})
+				locales = app.PreferredLocales(r.Locale, nil)
 
-				hydraConsentResponse, err := hydra.GetConsent(config.GetString("hydra.private.url")+config.GetString("hydra.private.endpoints.consent"), hydraClient, r.Challenge)
+				hydraConsentResponse, err := aap.GetHydraConsent(hydraClient, r.Challenge)
 				if err != nil {
 					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
 					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
@@ -91,6 +105,7 @@ func GetAuthorize(env *app.Environment) gin.HandlerFunc {
 				}
 
 				consentChallenge := parseConsentChallenge(hydraConsentResponse)
+				locales = app.PreferredLocales(r.Locale, consentChallenge.UiLocales)
 
 				// Prepare db lookup filters based on consent challenge.
 				iFilterOwner := aap.Identity{Id: consentChallenge.Subject}
@@ -107,7 +122,7 @@ func GetAuthorize(env *app.Environment) gin.HandlerFunc {
 					}
 				}
 
-				consentRequests, subscribedScopes, consentedScopes, consentedAudiences, err := fetchConsentRequests(tx, iFilterOwner, iFilterSubscriber, iFilterPublishers, iFilterScopes)
+				consentRequests, subscribedScopes, consentedScopes, consentedAudiences, consentsExpire, err := fetchConsentRequests(tx, iFilterOwner, iFilterSubscriber, iFilterPublishers, iFilterScopes, locales)
 				if err != nil {
 					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
 					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
@@ -134,6 +149,72 @@ func GetAuthorize(env *app.Environment) gin.HandlerFunc {
 					return
 				}
 
+				// Trusted subscribers never prompt the subject. Consent on behalf of the subject and mark the consents automatic.
+				var events []aap.Event
+				for i, cr := range consentRequests {
+					if cr.Consented || !cr.Trusted {
+						continue
+					}
+
+					consent, err := aap.CreateConsent(tx, iFilterOwner, iFilterSubscriber, aap.Identity{Id: cr.Audience}, aap.Scope{Name: cr.Scope}, aap.ConsentRule{Expire: app.ConsentExpire(cr.Audience, 0, 0), Automatic: true})
+					if err != nil {
+						e := tx.Rollback()
+						if e != nil {
+							log.Debug(e.Error())
+						}
+						bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+						request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+						log.Debug(err.Error())
+						return
+					}
+					consentRequests[i].Consented = true
+
+					if !utils.StringInSlice(consent.Scope.Name, consentedScopes) {
+						consentedScopes = append(consentedScopes, consent.Scope.Name)
+					}
+
+					if !utils.StringInSlice(consent.Publisher.Id, consentedAudiences) {
+						consentedAudiences = append(consentedAudiences, consent.Publisher.Id)
+					}
+
+					if consent.ConsentRule.Expire > 0 && (consentsExpire == 0 || consent.ConsentRule.Expire < consentsExpire) {
+						consentsExpire = consent.ConsentRule.Expire
+					}
+
+					events = append(events, app.NewEvent(env, c, aap.EVENT_CONSENT_CREATED, aap.EventConsent{
+						Identity:   consent.Identity.Id,
+						Subscriber: consent.Subscriber.Id,
+						Publisher:  consent.Publisher.Id,
+						Scope:      consent.Scope.Name,
+						NotBefore:  consent.ConsentRule.NotBefore,
+						Expire:     consent.ConsentRule.Expire,
+						Automatic:  consent.ConsentRule.Automatic,
+					}))
+				}
+
+				if len(events) > 0 {
+					err = app.StageEvents(tx, events)
+					if err != nil {
+						e := tx.Rollback()
+						if e != nil {
+							log.Debug(e.Error())
+						}
+						bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+						log.Debug(err.Error())
+						return
+					}
+
+					// Consents must be stored before hydra is told to accept them
+					err = tx.Commit()
+					if err != nil {
+						bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+						log.Debug(err.Error())
+						return
+					}
+					app.InvalidateVerdicts(env)
+					env.Outbox.Notify()
+				}
+
 				consentAuthorization := client.ReadConsentsAuthorizeResponse{
 					Challenge:  r.Challenge,
 					Authorized: false,
@@ -161,7 +242,8 @@ func GetAuthorize(env *app.Environment) gin.HandlerFunc {
 				}
 
 				// If not skip in hydra but all consented in db model, then accept consent.
-				if len(consentedAudiences) == len(consentChallenge.RequestedAudiences) && len(consentedScopes) == len(consentChallenge.RequestedScopes) {
+				// Without requested audiences consents to any publisher count.
+				if (len(consentChallenge.RequestedAudiences) == 0 || len(consentedAudiences) == len(consentChallenge.RequestedAudiences)) && len(consentedScopes) == len(consentChallenge.RequestedScopes) {
 					hydraGrantScopes = consentChallenge.RequestedScopes
 					hydraGrantAudience = consentChallenge.RequestedAudiences
 					hydraAcceptConsent = true
@@ -169,12 +251,22 @@ func GetAuthorize(env *app.Environment) gin.HandlerFunc {
 
 				if hydraAcceptConsent == true {
 
-					hydraConsentAcceptResponse, err := hydra.AcceptConsent(config.GetString("hydra.private.url")+config.GetString("hydra.private.endpoints.consentAccept"), hydraClient, r.Challenge, hydra.ConsentAcceptRequest{
-						GrantScope:               hydraGrantScopes,
-						Session:                  hydra.ConsentAcceptSession{},
-						GrantAccessTokenAudience: hydraGrantAudience,
-						Remember:                 true,
-						RememberFor:              0, // Never expire consent in hydra. Control this from aap system
+					hydraSession, err := tokenSession(env, consentChallenge, hydraGrantScopes, hydraGrantAudience)
+					if err != nil {
+						bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+						request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+						log.Debug(err.Error())
+						return
+					}
+
+					hydraConsentAcceptResponse, err := aap.AcceptHydraConsent(hydraClient, r.Challenge, aap.HydraConsentAcceptRequest{
+						ConsentAcceptRequest: hydra.ConsentAcceptRequest{
+							GrantScope:               hydraGrantScopes,
+							GrantAccessTokenAudience: hydraGrantAudience,
+							Remember:                 true,
+							RememberFor:              rememberFor(consentsExpire), // Hydra must prompt again when the first of the consents expires
+						},
+						Session: hydraSession,
 					})
 					if err != nil {
 						bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
@@ -195,6 +287,7 @@ func GetAuthorize(env *app.Environment) gin.HandlerFunc {
 			bulky.OutputValidateRequests(iRequests)
 		}
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		app.LocalizeResponses(responses, locales)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -218,9 +311,12 @@ func PostAuthorize(env *app.Environment) gin.HandlerFunc {
 		// Create a new HTTP client to perform the request, to prevent serialization
 		hydraClient := hydra.NewHydraClient(env.OAuth2Delegator.Config)
 
+		// Preferred locales of the subject, used for consent texts and error messages
+		var locales = app.PreferredLocales("", nil)
+
 		var handleRequests = func(iRequests []*bulky.Request) {
 
-			session, tx, err := aap.BeginReadTx(env.Driver)
+			session, tx, err := aap.BeginWriteTx(env.Driver)
 			if err != nil {
 				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
 				log.Debug(err.Error())
@@ -233,8 +329,9 @@ func PostAuthorize(env *app.Environment) gin.HandlerFunc {
 				r := request.Input.(client.CreateConsentsAuthorizeRequest)
 
 				log = log.WithFields(logrus.Fields{"challenge": r.Challenge})
+				locales = app.PreferredLocales(r.Locale, nil)
 
-				hydraConsentResponse, err := hydra.GetConsent(config.GetString("hydra.private.url")+config.GetString("hydra.private.endpoints.consent"), hydraClient, r.Challenge)
+				hydraConsentResponse, err := aap.GetHydraConsent(hydraClient, r.Challenge)
 				if err != nil {
 					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
 					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
@@ -244,6 +341,7 @@ func PostAuthorize(env *app.Environment) gin.HandlerFunc {
 				log.Debug(hydraConsentResponse)
 
 				consentChallenge := parseConsentChallenge(hydraConsentResponse)
+				locales = app.PreferredLocales(r.Locale, consentChallenge.UiLocales)
 
 				// Prepare db lookup filters based on consent challenge.
 				iFilterOwner := aap.Identity{Id: consentChallenge.Subject}
@@ -260,7 +358,7 @@ func PostAuthorize(env *app.Environment) gin.HandlerFunc {
 					}
 				}
 
-				consentRequests, subscribedScopes, consentedScopes, consentedAudiences, err := fetchConsentRequests(tx, iFilterOwner, iFilterSubscriber, iFilterPublishers, iFilterScopes)
+				consentRequests, subscribedScopes, consentedScopes, consentedAudiences, consentsExpire, err := fetchConsentRequests(tx, iFilterOwner, iFilterSubscriber, iFilterPublishers, iFilterScopes, locales)
 				if err != nil {
 					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
 					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
@@ -304,12 +402,222 @@ func PostAuthorize(env *app.Environment) gin.HandlerFunc {
 				var hydraGrantScopes []string = consentedScopes      // Accept all consented scopes from DB model
 				var hydraGrantAudience []string = consentedAudiences // Accept all consented audience from DB model
 
-				hydraConsentAcceptResponse, err := hydra.AcceptConsent(config.GetString("hydra.private.url")+config.GetString("hydra.private.endpoints.consentAccept"), hydraClient, r.Challenge, hydra.ConsentAcceptRequest{
-					GrantScope:               hydraGrantScopes,
-					Session:                  hydra.ConsentAcceptSession{},
-					GrantAccessTokenAudience: hydraGrantAudience,
-					Remember:                 true,
-					RememberFor:              0, // Never expire consent in hydra. Control this from aap system
+				// Accepting none of the consent requests is a reject, which must go through reject to tell hydra.
+				if r.Consents != nil && len(r.Consents) <= 0 {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests)
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.NO_CONSENTS)
+					return
+				}
+
+				// Accepting the already consented scopes. Required consent requests must be among them.
+				if r.Consents == nil {
+					for _, cr := range consentRequests {
+						if !cr.Required || cr.Consented {
+							continue
+						}
+
+						e := tx.Rollback()
+						if e != nil {
+							log.Debug(e.Error())
+						}
+						log.WithFields(logrus.Fields{"scope": cr.Scope, "audience": cr.Audience}).Debug("Required consent not consented")
+						bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests)
+						request.Output = bulky.NewClientErrorResponse(request.Index, E.REQUIRED_SCOPES)
+						return
+					}
+
+					if len(hydraGrantScopes) <= 0 {
+						e := tx.Rollback()
+						if e != nil {
+							log.Debug(e.Error())
+						}
+						bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests)
+						request.Output = bulky.NewClientErrorResponse(request.Index, E.NO_CONSENTS)
+						return
+					}
+				}
+
+				// The subject accepted a subset of the consent requests. Consent to those and grant only those.
+				if r.Consents != nil {
+					var acceptedRequests []client.ConsentRequest
+					for _, accepted := range r.Consents {
+						var found bool
+						for _, cr := range consentRequests {
+							if cr.Scope == accepted.Scope && cr.Audience == accepted.Audience {
+								found = true
+								acceptedRequests = append(acceptedRequests, cr)
+								break
+							}
+						}
+
+						if !found {
+							e := tx.Rollback()
+							if e != nil {
+								log.Debug(e.Error())
+							}
+							log.WithFields(logrus.Fields{"scope": accepted.Scope, "audience": accepted.Audience}).Debug("Accepted consent not requested")
+							bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests)
+							request.Output = bulky.NewClientErrorResponse(request.Index, E.INVALID_SCOPES)
+							return
+						}
+					}
+
+					for _, cr := range consentRequests {
+						if !cr.Required {
+							continue
+						}
+
+						var accepted bool
+						for _, a := range acceptedRequests {
+							if a.Scope == cr.Scope && a.Audience == cr.Audience {
+								accepted = true
+								break
+							}
+						}
+
+						if !accepted {
+							e := tx.Rollback()
+							if e != nil {
+								log.Debug(e.Error())
+							}
+							log.WithFields(logrus.Fields{"scope": cr.Scope, "audience": cr.Audience}).Debug("Required consent not accepted")
+							bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests)
+							request.Output = bulky.NewClientErrorResponse(request.Index, E.REQUIRED_SCOPES)
+							return
+						}
+					}
+
+					var events []aap.Event
+					hydraGrantScopes = []string{}
+					hydraGrantAudience = []string{}
+					consentsExpire = 0
+
+					for _, a := range acceptedRequests {
+						consent, err := aap.CreateConsent(tx, iFilterOwner, iFilterSubscriber, aap.Identity{Id: a.Audience}, aap.Scope{Name: a.Scope}, aap.ConsentRule{Expire: app.ConsentExpire(a.Audience, 0, 0)})
+						if err != nil {
+							e := tx.Rollback()
+							if e != nil {
+								log.Debug(e.Error())
+							}
+							bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+							request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+							log.Debug(err.Error())
+							return
+						}
+
+						events = append(events, app.NewEvent(env, c, aap.EVENT_CONSENT_CREATED, aap.EventConsent{
+							Identity:   consent.Identity.Id,
+							Subscriber: consent.Subscriber.Id,
+							Publisher:  consent.Publisher.Id,
+							Scope:      consent.Scope.Name,
+							NotBefore:  consent.ConsentRule.NotBefore,
+							Expire:     consent.ConsentRule.Expire,
+						}))
+
+						if !utils.StringInSlice(consent.Scope.Name, hydraGrantScopes) {
+							hydraGrantScopes = append(hydraGrantScopes, consent.Scope.Name)
+						}
+
+						if !utils.StringInSlice(consent.Publisher.Id, hydraGrantAudience) {
+							hydraGrantAudience = append(hydraGrantAudience, consent.Publisher.Id)
+						}
+
+						if consent.ConsentRule.Expire > 0 && (consentsExpire == 0 || consent.ConsentRule.Expire < consentsExpire) {
+							consentsExpire = consent.ConsentRule.Expire
+						}
+					}
+
+					// Consents given earlier but deselected now are withdrawn, so the stored consents agree with what hydra grants
+					for _, cr := range consentRequests {
+						if !cr.Consented {
+							continue
+						}
+
+						var accepted bool
+						for _, a := range acceptedRequests {
+							if a.Scope == cr.Scope && a.Audience == cr.Audience {
+								accepted = true
+								break
+							}
+						}
+						if accepted {
+							continue
+						}
+
+						consent, err := aap.DeleteConsent(tx, iFilterOwner, iFilterSubscriber, aap.Identity{Id: cr.Audience}, aap.Scope{Name: cr.Scope})
+						if err != nil {
+							e := tx.Rollback()
+							if e != nil {
+								log.Debug(e.Error())
+							}
+							bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+							request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+							log.Debug(err.Error())
+							return
+						}
+
+						events = append(events, app.NewEvent(env, c, aap.EVENT_CONSENT_DELETED, aap.EventConsent{
+							Identity:   consent.Identity.Id,
+							Subscriber: consent.Subscriber.Id,
+							Publisher:  consent.Publisher.Id,
+							Scope:      consent.Scope.Name,
+							NotBefore:  consent.ConsentRule.NotBefore,
+							Expire:     consent.ConsentRule.Expire,
+							Automatic:  consent.ConsentRule.Automatic,
+						}))
+					}
+
+					for i, cr := range consentAuthorization.ConsentRequests {
+						consentAuthorization.ConsentRequests[i].Consented = false
+						for _, a := range acceptedRequests {
+							if a.Scope == cr.Scope && a.Audience == cr.Audience {
+								consentAuthorization.ConsentRequests[i].Consented = true
+							}
+						}
+					}
+
+					err = app.StageEvents(tx, events)
+					if err != nil {
+						e := tx.Rollback()
+						if e != nil {
+							log.Debug(e.Error())
+						}
+						bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+						log.Debug(err.Error())
+						return
+					}
+
+					// Consents must be stored before hydra is told to accept them
+					err = tx.Commit()
+					if err != nil {
+						bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+						log.Debug(err.Error())
+						return
+					}
+					app.InvalidateVerdicts(env)
+					env.Outbox.Notify()
+				}
+
+				hydraSession, err := tokenSession(env, consentChallenge, hydraGrantScopes, hydraGrantAudience)
+				if err != nil {
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+					log.Debug(err.Error())
+					return
+				}
+
+				hydraConsentAcceptResponse, err := aap.AcceptHydraConsent(hydraClient, r.Challenge, aap.HydraConsentAcceptRequest{
+					ConsentAcceptRequest: hydra.ConsentAcceptRequest{
+						GrantScope:               hydraGrantScopes,
+						GrantAccessTokenAudience: hydraGrantAudience,
+						Remember:                 true,
+						RememberFor:              rememberFor(consentsExpire), // Hydra must prompt again when the first of the consents expires
+					},
+					Session: hydraSession,
 				})
 				if err != nil {
 					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
@@ -329,6 +637,7 @@ func PostAuthorize(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		app.LocalizeResponses(responses, locales)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
@@ -352,14 +661,18 @@ func PostReject(env *app.Environment) gin.HandlerFunc {
 		// Create a new HTTP client to perform the request, to prevent serialization
 		hydraClient := hydra.NewHydraClient(env.OAuth2Delegator.Config)
 
+		// Preferred locales of the subject, used for consent texts and error messages
+		var locales = app.PreferredLocales("", nil)
+
 		var handleRequests = func(iRequests []*bulky.Request) {
 
 			for _, request := range iRequests {
 				r := request.Input.(client.CreateConsentsRejectRequest)
 
 				log = log.WithFields(logrus.Fields{"challenge": r.Challenge})
+				locales = app.PreferredLocales(r.Locale, nil)
 
-				hydraConsentResponse, err := hydra.GetConsent(config.GetString("hydra.private.url")+config.GetString("hydra.private.endpoints.consent"), hydraClient, r.Challenge)
+				hydraConsentResponse, err := aap.GetHydraConsent(hydraClient, r.Challenge)
 				if err != nil {
 					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
 					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
@@ -368,6 +681,7 @@ func PostReject(env *app.Environment) gin.HandlerFunc {
 				}
 
 				consentChallenge := parseConsentChallenge(hydraConsentResponse)
+				locales = app.PreferredLocales(r.Locale, consentChallenge.UiLocales)
 
 				hydraConsentRejectResponse, err := hydra.RejectConsent(config.GetString("hydra.private.url")+config.GetString("hydra.private.endpoints.consentReject"), hydraClient, r.Challenge, hydra.ConsentRejectRequest{
 					Error:            "Access Denied",
@@ -391,6 +705,16 @@ func PostReject(env *app.Environment) gin.HandlerFunc {
 					ClientId:   consentChallenge.ClientId,
 					Subject:    consentChallenge.Subject,
 				})
+
+				err = recordRejectedConsent(env, consentChallenge, r.Challenge, app.NewEvent(env, c, aap.EVENT_CONSENT_REJECTED, aap.EventConsent{
+					Identity:   consentChallenge.Subject,
+					Subscriber: consentChallenge.ClientId,
+					Scopes:     consentChallenge.RequestedScopes,
+					Challenge:  r.Challenge,
+				}))
+				if err != nil {
+					log.Debug(err.Error())
+				}
 				continue
 			}
 
@@ -398,18 +722,67 @@ func PostReject(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		app.LocalizeResponses(responses, locales)
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
 }
 
-func parseConsentChallenge(hydraConsentResponse hydra.ConsentResponse) (consentChallenge ConsentChallenge) {
+// The rejection is already sent to hydra, so it is recorded in a transaction of its own
+func recordRejectedConsent(env *app.Environment, consentChallenge ConsentChallenge, challenge string, event aap.Event) error {
+	session, tx, err := aap.BeginWriteTx(env.Driver)
+	if err != nil {
+		return err
+	}
+	defer tx.Close() // rolls back if not already committed/rolled back
+	defer session.Close()
+
+	var publishers = consentChallenge.RequestedAudiences
+	if len(publishers) <= 0 {
+		publishers = []string{""}
+	}
+
+	for _, publisher := range publishers {
+		for _, scope := range consentChallenge.RequestedScopes {
+			err = aap.CreateConsentHistory(tx, aap.ConsentHistory{
+				Action:         aap.CONSENT_HISTORY_REJECTED,
+				Identity:       aap.Identity{Id: consentChallenge.Subject},
+				Subscriber:     aap.Identity{Id: consentChallenge.ClientId},
+				SubscriberName: consentChallenge.ClientName,
+				Publisher:      aap.Identity{Id: publisher},
+				Scope:          aap.Scope{Name: scope},
+				Challenge:      challenge,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = app.StageEvents(tx, []aap.Event{event})
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	app.InvalidateVerdicts(env)
+	env.Outbox.Notify()
+	return nil
+}
+
+func parseConsentChallenge(hydraConsentResponse aap.HydraConsentResponse) (consentChallenge ConsentChallenge) {
 	consentChallenge = ConsentChallenge{
 		Skip:               hydraConsentResponse.Skip,
 		Subject:            hydraConsentResponse.Subject,
 		ClientId:           hydraConsentResponse.Client.ClientId,
 		RequestedScopes:    hydraConsentResponse.RequestedScopes,
 		RequestedAudiences: hydraConsentResponse.RequestedAccessTokenAudience,
+		UiLocales:          hydraConsentResponse.OidcContext.UiLocales,
+		Acr:                hydraConsentResponse.Acr,
 	}
 
 	loginContext := hydraConsentResponse.Context
@@ -417,16 +790,81 @@ func parseConsentChallenge(hydraConsentResponse hydra.ConsentResponse) (consentC
 		consentChallenge.ClientName = loginContext["client_name"]
 		consentChallenge.SubjectName = loginContext["subject_name"]
 		consentChallenge.SubjectEmail = loginContext["subject_email"]
+
+		// The login provider passes the authentication methods and time in the login context
+		consentChallenge.Amr = strings.Fields(loginContext["amr"])
+		if authTime, err := strconv.ParseInt(loginContext["auth_time"], 10, 64); err == nil {
+			consentChallenge.AuthTime = authTime
+		}
+		if consentChallenge.Acr == "" {
+			consentChallenge.Acr = loginContext["acr"]
+		}
 	}
 
 	return consentChallenge
 }
 
+// tokenSession is the claims of the tokens issued with the consent. Access token claims are returned in ext by introspection and used by the judge for step-up.
+// The grants of the subject are projected into the claims when enabled.
+func tokenSession(env *app.Environment, consentChallenge ConsentChallenge, grantScopes []string, grantAudiences []string) (session aap.HydraConsentAcceptSession, err error) {
+	session.AccessToken = authenticationClaims(consentChallenge)
+
+	if !app.IsGrantsClaimEnabled() {
+		return session, nil
+	}
+
+	grantsClaims, err := app.GrantsClaims(env, aap.Identity{Id: consentChallenge.Subject}, grantScopes, grantAudiences)
+	if err != nil {
+		return aap.HydraConsentAcceptSession{}, err
+	}
+
+	for claim, value := range grantsClaims {
+		session.AccessToken[claim] = value
+	}
+
+	if config.GetInt("tokens.claims.grants.id_token") == 1 {
+		session.IdToken = grantsClaims
+	}
+
+	return session, nil
+}
+
+func authenticationClaims(consentChallenge ConsentChallenge) (session map[string]interface{}) {
+	session = make(map[string]interface{})
+
+	if consentChallenge.Acr != "" {
+		session["acr"] = consentChallenge.Acr
+	}
+
+	if len(consentChallenge.Amr) > 0 {
+		session["amr"] = consentChallenge.Amr
+	}
+
+	if consentChallenge.AuthTime > 0 {
+		session["auth_time"] = consentChallenge.AuthTime
+	}
+
+	return session
+}
+
 type PublisherScope struct {
 	Publisher, Scope string
 }
 
-func fetchConsentRequests(tx neo4j.Transaction, iFilterOwner aap.Identity, iFilterSubscriber aap.Identity, iFilterPublishers []aap.Identity, iFilterScopes []aap.Scope) (consentRequests []client.ConsentRequest, subscribedScopes []string, consentedScopes []string, consentedAudiences []string, err error) {
+// Seconds hydra may remember the consent. 0 = never expire consent in hydra, aap controls it.
+func rememberFor(consentsExpire int64) int {
+	if consentsExpire <= 0 {
+		return 0
+	}
+
+	remaining := consentsExpire - time.Now().Unix()
+	if remaining <= 0 {
+		return 1 // Hydra treats 0 as forever
+	}
+	return int(remaining)
+}
+
+func fetchConsentRequests(tx neo4j.Transaction, iFilterOwner aap.Identity, iFilterSubscriber aap.Identity, iFilterPublishers []aap.Identity, iFilterScopes []aap.Scope, locales []string) (consentRequests []client.ConsentRequest, subscribedScopes []string, consentedScopes []string, consentedAudiences []string, consentsExpire int64, err error) {
 
 	// No publisher given, so use the all publisher the one with Id = ""
 	if len(iFilterPublishers) <= 0 {
@@ -442,7 +880,7 @@ func fetchConsentRequests(tx neo4j.Transaction, iFilterOwner aap.Identity, iFilt
 		// Lookup definitions of scopes for each given publisher
 		dbPublishes, err := aap.FetchPublishes(tx, publisher, iFilterScopes)
 		if err != nil {
-			return nil, nil, nil, nil, err
+			return nil, nil, nil, nil, 0, err
 		}
 		for _, pub := range dbPublishes {
 			publishings[PublisherScope{pub.Publisher.Id, pub.Scope.Name}] = pub
@@ -451,23 +889,36 @@ func fetchConsentRequests(tx neo4j.Transaction, iFilterOwner aap.Identity, iFilt
 		// Lookup subscriptions for client to each publisher
 		dbSubscriptions, err := aap.FetchSubscriptions(tx, iFilterSubscriber, publisher, iFilterScopes)
 		if err != nil {
-			return nil, nil, nil, nil, err
+			return nil, nil, nil, nil, 0, err
+		}
+		for _, sub := range dbSubscriptions {
+			// Pending and rejected subscriptions cannot be consented to
+			if sub.SubscribeRule.State == aap.SUBSCRIPTION_APPROVED {
+				subscriptions[publisher.Id] = append(subscriptions[publisher.Id], sub)
+			}
 		}
-		subscriptions[publisher.Id] = append(subscriptions[publisher.Id], dbSubscriptions...)
 
 		// Initialize consent map for all subscriptions.
-		for _, sub := range dbSubscriptions {
+		for _, sub := range subscriptions[publisher.Id] {
 			consents[PublisherScope{sub.Publisher.Id, sub.Scope.Name}] = false
 		}
 
 		// Lookup consents already given to the client to publisher scope by subject
 		dbConsents, err := aap.FetchConsents(tx, iFilterOwner, iFilterSubscriber, publisher, iFilterScopes)
 		if err != nil {
-			return nil, nil, nil, nil, err
+			return nil, nil, nil, nil, 0, err
 		}
 		for _, consent := range dbConsents {
 			consentedScopes = append(consentedScopes, consent.Scope.Name)
+			if !utils.StringInSlice(consent.Publisher.Id, consentedAudiences) {
+				consentedAudiences = append(consentedAudiences, consent.Publisher.Id)
+			}
 			consents[PublisherScope{consent.Publisher.Id, consent.Scope.Name}] = true
+
+			// The first consent to expire decides when the subject must be prompted again
+			if consent.ConsentRule.Expire > 0 && (consentsExpire == 0 || consent.ConsentRule.Expire < consentsExpire) {
+				consentsExpire = consent.ConsentRule.Expire
+			}
 		}
 	}
 
@@ -492,17 +943,22 @@ func fetchConsentRequests(tx neo4j.Transaction, iFilterOwner aap.Identity, iFilt
 			pub := publishings[PublisherScope{sub.Publisher.Id, sub.Scope.Name}]
 			isConsented := consents[PublisherScope{sub.Publisher.Id, sub.Scope.Name}]
 
+			title, description, locale := app.LocalizePublishRule(pub.Rule, locales)
+
 			consentRequest := client.ConsentRequest{
 				Scope:       sub.Scope.Name,
 				Audience:    sub.Publisher.Id,
-				Title:       pub.Rule.Title,
-				Description: pub.Rule.Description,
+				Title:       title,
+				Description: description,
+				Locale:      locale,
 				Consented:   isConsented,
+				Required:    sub.SubscribeRule.Required,
+				Trusted:     sub.SubscribeRule.Trusted,
 			}
 			consentRequests = append(consentRequests, consentRequest)
 		}
 
 	}
 
-	return consentRequests, subscribedScopes, consentedScopes, consentedAudiences, nil
+	return consentRequests, subscribedScopes, consentedScopes, consentedAudiences, consentsExpire, nil
 }
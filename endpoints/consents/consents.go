@@ -1,15 +1,18 @@
 package consents
 
 import (
+	hydra "github.com/charmixer/hydra/client"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"time"
 
 	"github.com/opensentry/aap/app"
 	"github.com/opensentry/aap/client"
 	E "github.com/opensentry/aap/client/errors"
-	_ "github.com/opensentry/aap/config"
+	"github.com/opensentry/aap/config"
 	"github.com/opensentry/aap/gateway/aap"
+	"github.com/opensentry/aap/utils"
 
 	bulky "github.com/charmixer/bulky/server"
 )
@@ -86,6 +89,9 @@ func GetConsents(env *app.Environment) gin.HandlerFunc {
 							Subscriber: d.Subscriber.Id,
 							Publisher:  d.Publisher.Id,
 							Scope:      d.Scope.Name,
+							NotBefore:  d.ConsentRule.NotBefore,
+							Expire:     d.ConsentRule.Expire,
+							Automatic:  d.ConsentRule.Automatic,
 						})
 					}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
@@ -139,6 +145,8 @@ func PostConsents(env *app.Environment) gin.HandlerFunc {
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
 
+			var events []aap.Event
+
 			var newConsents []aap.Consent
 
 			for _, request := range iRequests {
@@ -151,7 +159,9 @@ func PostConsents(env *app.Environment) gin.HandlerFunc {
 					Scope:      aap.Scope{Name: r.Scope},
 				}
 
-				consent, err := aap.CreateConsent(tx, newConsent.Identity, newConsent.Subscriber, newConsent.Publisher, newConsent.Scope)
+				expire := app.ConsentExpire(r.Publisher, r.NotBefore, r.Expire)
+
+				consent, err := aap.CreateConsent(tx, newConsent.Identity, newConsent.Subscriber, newConsent.Publisher, newConsent.Scope, aap.ConsentRule{NotBefore: r.NotBefore, Expire: expire})
 				if err != nil {
 					e := tx.Rollback()
 					if e != nil {
@@ -171,9 +181,18 @@ func PostConsents(env *app.Environment) gin.HandlerFunc {
 						Subscriber: consent.Subscriber.Id,
 						Publisher:  consent.Publisher.Id,
 						Scope:      consent.Scope.Name,
+						NotBefore:  consent.ConsentRule.NotBefore,
+						Expire:     consent.ConsentRule.Expire,
 					}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
-					aap.EmitEventConsentCreated(env.Nats, consent)
+					events = append(events, app.NewEvent(env, c, aap.EVENT_CONSENT_CREATED, aap.EventConsent{
+						Identity:   consent.Identity.Id,
+						Subscriber: consent.Subscriber.Id,
+						Publisher:  consent.Publisher.Id,
+						Scope:      consent.Scope.Name,
+						NotBefore:  consent.ConsentRule.NotBefore,
+						Expire:     consent.ConsentRule.Expire,
+					}))
 					continue
 				}
 
@@ -190,7 +209,20 @@ func PostConsents(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = app.StageEvents(tx, events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+
 				tx.Commit()
+				app.InvalidateVerdicts(env)
+				env.Outbox.Notify()
 				// proxy to hydra. Not needed.
 				return
 			}
@@ -220,6 +252,11 @@ func DeleteConsents(env *app.Environment) gin.HandlerFunc {
 			return
 		}
 
+		// Create a new HTTP client to perform the request, to prevent serialization
+		hydraClient := hydra.NewHydraClient(env.OAuth2Delegator.Config)
+
+		var createdRevocations []aap.Revocation
+
 		var handleRequests = func(iRequests []*bulky.Request) {
 
 			session, tx, err := aap.BeginWriteTx(env.Driver)
@@ -231,6 +268,18 @@ func DeleteConsents(env *app.Environment) gin.HandlerFunc {
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
 
+			var events []aap.Event
+
+			// Access already given by a consent lives on in hydra and in issued tokens. Revoke the tokens carrying the withdrawn scopes once per subject and client.
+			type revokedPair struct {
+				consent    aap.Consent
+				scopes     []string
+				revocation aap.Revocation
+				requests   []*bulky.Request
+			}
+			var revokedPairs []*revokedPair
+			oks := make(map[*bulky.Request]client.DeleteConsentsResponse)
+
 			for _, request := range iRequests {
 				r := request.Input.(client.DeleteConsentsRequest)
 
@@ -288,13 +337,46 @@ func DeleteConsents(env *app.Environment) gin.HandlerFunc {
 						return
 					}
 
+					var pair *revokedPair
+					for _, p := range revokedPairs {
+						if p.consent.Identity.Id == consentToDelete.Identity.Id && p.consent.Subscriber.Id == consentToDelete.Subscriber.Id {
+							pair = p
+						}
+					}
+
+					if pair == nil {
+						pair = &revokedPair{consent: consentToDelete}
+						revokedPairs = append(revokedPairs, pair)
+					}
+
+					if !utils.StringInSlice(consentToDelete.Scope.Name, pair.scopes) {
+						pair.scopes = append(pair.scopes, consentToDelete.Scope.Name)
+					}
+					pair.requests = append(pair.requests, request)
+
 					ok := client.DeleteConsentsResponse{
-						Reference:  consentToDelete.Identity.Id,
+						Consent: client.Consent{
+							Reference:  consentToDelete.Identity.Id,
+							Subscriber: consentToDelete.Subscriber.Id,
+							Publisher:  consentToDelete.Publisher.Id,
+							Scope:      consentToDelete.Scope.Name,
+							NotBefore:  consentToDelete.ConsentRule.NotBefore,
+							Expire:     consentToDelete.ConsentRule.Expire,
+							Automatic:  consentToDelete.ConsentRule.Automatic,
+						},
+					}
+					oks[request] = ok
+					request.Output = bulky.NewOkResponse(request.Index, ok)
+
+					events = append(events, app.NewEvent(env, c, aap.EVENT_CONSENT_DELETED, aap.EventConsent{
+						Identity:   consentToDelete.Identity.Id,
 						Subscriber: consentToDelete.Subscriber.Id,
 						Publisher:  consentToDelete.Publisher.Id,
 						Scope:      consentToDelete.Scope.Name,
-					}
-					request.Output = bulky.NewOkResponse(request.Index, ok)
+						NotBefore:  consentToDelete.ConsentRule.NotBefore,
+						Expire:     consentToDelete.ConsentRule.Expire,
+						Automatic:  consentToDelete.ConsentRule.Automatic,
+					}))
 					continue
 				}
 
@@ -311,8 +393,79 @@ func DeleteConsents(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
-				tx.Commit()
-				// proxy to hydra. Not needed
+				for _, pair := range revokedPairs {
+					revocation, err := aap.CreateRevocation(tx, aap.Revocation{
+						Subject:  pair.consent.Identity.Id,
+						ClientId: pair.consent.Subscriber.Id,
+						Scopes:   pair.scopes,
+						Expire:   time.Now().Unix() + int64(config.GetInt("revocations.ttl")),
+					})
+					if err != nil {
+						e := tx.Rollback()
+						if e != nil {
+							log.Debug(e.Error())
+						}
+						bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+						log.Debug(err.Error())
+						return
+					}
+					pair.revocation = revocation
+
+					events = append(events, app.NewEvent(env, c, aap.EVENT_REVOCATION_CREATED, aap.EventRevocation{
+						Id:        revocation.Id,
+						Jti:       revocation.Jti,
+						Subject:   revocation.Subject,
+						ClientId:  revocation.ClientId,
+						Scopes:    revocation.Scopes,
+						RevokedAt: revocation.RevokedAt,
+						Expire:    revocation.Expire,
+					}))
+
+					for _, request := range pair.requests {
+						ok := oks[request]
+						ok.Revocation = revocation.Id
+						ok.RevokedScopes = revocation.Scopes
+						oks[request] = ok
+						request.Output = bulky.NewOkResponse(request.Index, ok)
+					}
+				}
+
+				err = app.StageEvents(tx, events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+
+				err = tx.Commit()
+				if err != nil {
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+				app.InvalidateVerdicts(env)
+
+				for _, pair := range revokedPairs {
+					createdRevocations = append(createdRevocations, pair.revocation)
+
+					var sessionsError string
+					err := aap.RevokeHydraConsentSessions(hydraClient, pair.consent.Identity, pair.consent.Subscriber)
+					if err != nil {
+						log.WithFields(logrus.Fields{"subject": pair.consent.Identity.Id, "client_id": pair.consent.Subscriber.Id}).Debug(err.Error())
+						sessionsError = err.Error()
+					}
+
+					for _, request := range pair.requests {
+						ok := oks[request]
+						ok.SessionsRevoked = sessionsError == ""
+						ok.SessionsError = sessionsError
+						request.Output = bulky.NewOkResponse(request.Index, ok)
+					}
+				}
 				return
 			}
 
@@ -321,6 +474,13 @@ func DeleteConsents(env *app.Environment) gin.HandlerFunc {
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{})
+
+		// Revoke in this instance right away, the other instances are told by the outbox relay
+		for _, revocation := range createdRevocations {
+			env.Revocations.Add(revocation)
+		}
+		env.Outbox.Notify()
+
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
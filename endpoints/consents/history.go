@@ -0,0 +1,241 @@
+package consents
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+
+	"github.com/opensentry/aap/app"
+	"github.com/opensentry/aap/client"
+	"github.com/opensentry/aap/config"
+	"github.com/opensentry/aap/gateway/aap"
+
+	bulky "github.com/charmixer/bulky/server"
+)
+
+const CONSENT_RECEIPT_VERSION = "KI-CR-v1.1.0"
+
+func GetConsentsHistory(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "GetConsentsHistory",
+		})
+
+		var requests []client.ReadConsentsHistoryRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := aap.BeginReadTx(env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			for _, request := range iRequests {
+				r := request.Input.(client.ReadConsentsHistoryRequest)
+
+				dbHistory, err := aap.FetchConsentHistory(tx, aap.Identity{Id: r.Reference}, aap.Identity{Id: r.Subscriber}, aap.Identity{Id: r.Publisher})
+				if err != nil {
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+					log.Debug(err.Error())
+					return
+				}
+
+				var ok = client.ReadConsentsHistoryResponse{}
+				for _, h := range dbHistory {
+					ok = append(ok, client.ConsentHistory{
+						Id:             h.Id,
+						Action:         h.Action,
+						At:             h.At,
+						Reference:      h.Identity.Id,
+						Subscriber:     h.Subscriber.Id,
+						SubscriberName: h.SubscriberName,
+						Publisher:      h.Publisher.Id,
+						PublisherName:  h.PublisherName,
+						Scope:          h.Scope.Name,
+						Title:          h.Title,
+						Description:    h.Description,
+						NotBefore:      h.ConsentRule.NotBefore,
+						Expire:         h.ConsentRule.Expire,
+						Automatic:      h.ConsentRule.Automatic,
+						Challenge:      h.Challenge,
+					})
+				}
+
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+			}
+
+			bulky.OutputValidateRequests(iRequests)
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{})
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// GetConsentsReceipts exports the consents currently given by the subject as a Kantara consent receipt.
+func GetConsentsReceipts(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "GetConsentsReceipts",
+		})
+
+		var requests []client.ReadConsentsReceiptsRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := aap.BeginReadTx(env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			for _, request := range iRequests {
+				r := request.Input.(client.ReadConsentsReceiptsRequest)
+
+				iOwner := aap.Identity{Id: r.Reference}
+				iSubscriber := aap.Identity{Id: r.Subscriber}
+
+				dbConsents, err := aap.FetchConsents(tx, iOwner, iSubscriber, aap.Identity{}, nil)
+				if err != nil {
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+					log.Debug(err.Error())
+					return
+				}
+
+				dbHistory, err := aap.FetchConsentHistory(tx, iOwner, iSubscriber, aap.Identity{})
+				if err != nil {
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)     // Specify error on failed one
+					log.Debug(err.Error())
+					return
+				}
+
+				request.Output = bulky.NewOkResponse(request.Index, newConsentReceipt(iOwner, dbConsents, dbHistory))
+			}
+
+			bulky.OutputValidateRequests(iRequests)
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{})
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func newConsentReceipt(iOwner aap.Identity, consents []aap.Consent, history []aap.ConsentHistory) client.ReadConsentsReceiptsResponse {
+	receiptId, _ := uuid.NewV4()
+
+	receipt := client.ReadConsentsReceiptsResponse{
+		Version:          CONSENT_RECEIPT_VERSION,
+		Jurisdiction:     config.GetString("consents.receipts.jurisdiction"),
+		CollectionMethod: config.GetString("consents.receipts.collection"),
+		ConsentReceiptId: receiptId.String(),
+		Language:         config.GetString("consents.receipts.language"),
+		PiiPrincipalId:   iOwner.Id,
+		PiiControllers: []client.ConsentReceiptPiiController{
+			{
+				PiiController:    config.GetString("consents.receipts.controller.name"),
+				Contact:          config.GetString("consents.receipts.controller.contact"),
+				Address:          config.GetStringMapString("consents.receipts.controller.address"),
+				Email:            config.GetString("consents.receipts.controller.email"),
+				Phone:            config.GetString("consents.receipts.controller.phone"),
+				PiiControllerUrl: config.GetString("consents.receipts.controller.url"),
+			},
+		},
+		PolicyUrl: config.GetString("consents.receipts.policy"),
+		Services:  []client.ConsentReceiptService{},
+		SpiCat:    []string{},
+	}
+
+	// The latest grant of each consent holds the texts shown to the subject when consenting
+	granted := make(map[PublisherScope]map[string]aap.ConsentHistory)
+	for _, h := range history {
+		if h.Action != aap.CONSENT_HISTORY_GRANTED {
+			continue
+		}
+
+		key := PublisherScope{h.Publisher.Id, h.Scope.Name}
+		if granted[key] == nil {
+			granted[key] = make(map[string]aap.ConsentHistory)
+		}
+		granted[key][h.Subscriber.Id] = h
+	}
+
+	services := make(map[string]int)
+	for _, consent := range consents {
+		h := granted[PublisherScope{consent.Publisher.Id, consent.Scope.Name}][consent.Subscriber.Id]
+
+		if h.At > receipt.ConsentTimestamp {
+			receipt.ConsentTimestamp = h.At
+		}
+
+		index, exists := services[consent.Subscriber.Id]
+		if !exists {
+			service := h.SubscriberName
+			if service == "" {
+				service = consent.Subscriber.Id
+			}
+
+			receipt.Services = append(receipt.Services, client.ConsentReceiptService{Service: service, Purposes: []client.ConsentReceiptPurpose{}})
+			index = len(receipt.Services) - 1
+			services[consent.Subscriber.Id] = index
+		}
+
+		purpose := h.Title
+		if purpose == "" {
+			purpose = consent.Scope.Name
+		}
+		if h.Description != "" {
+			purpose = purpose + ": " + h.Description
+		}
+
+		consentType := "EXPLICIT"
+		if consent.ConsentRule.Automatic {
+			consentType = "IMPLICIT"
+		}
+
+		termination := "Until withdrawn"
+		if consent.ConsentRule.Expire > 0 {
+			termination = "Expires " + time.Unix(consent.ConsentRule.Expire, 0).UTC().Format(time.RFC3339) + " or when withdrawn"
+		}
+
+		receipt.Services[index].Purposes = append(receipt.Services[index].Purposes, client.ConsentReceiptPurpose{
+			Purpose:         purpose,
+			PurposeCategory: []string{},
+			ConsentType:     consentType,
+			PiiCategory:     []string{consent.Scope.Name},
+			PrimaryPurpose:  true,
+			Termination:     termination,
+		})
+	}
+
+	if receipt.ConsentTimestamp == 0 {
+		receipt.ConsentTimestamp = time.Now().Unix()
+	}
+
+	return receipt
+}
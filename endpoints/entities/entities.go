@@ -38,6 +38,8 @@ func PostEntities(env *app.Environment) gin.HandlerFunc {
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
 
+			var events []aap.Event
+
 			validScopes := []string{
 				"aap:read:grants",
 				"aap:create:grants",
@@ -48,12 +50,23 @@ func PostEntities(env *app.Environment) gin.HandlerFunc {
 				"aap:read:subscriptions",
 				"aap:create:subscriptions",
 				"aap:delete:subscriptions",
+				"aap:update:subscriptions:approvals",
+				"aap:update:subscriptions:trust",
+				"aap:read:entities:judge:cache",
 				"aap:read:consents",
 				"aap:create:consents",
 				"aap:delete:consents",
 				"aap:read:shadows",
 				"aap:create:shadows",
 				"aap:delete:shadows",
+				"aap:read:revocations",
+				"aap:create:revocations",
+				"aap:read:webhooks",
+				"aap:create:webhooks",
+				"aap:update:webhooks",
+				"aap:delete:webhooks",
+				"aap:read:apps",
+				"aap:delete:apps",
 
 				"mg:aap:read:grants",
 				"mg:aap:create:grants",
@@ -64,12 +77,23 @@ func PostEntities(env *app.Environment) gin.HandlerFunc {
 				"mg:aap:read:subscriptions",
 				"mg:aap:create:subscriptions",
 				"mg:aap:delete:subscriptions",
+				"mg:aap:update:subscriptions:approvals",
+				"mg:aap:update:subscriptions:trust",
+				"mg:aap:read:entities:judge:cache",
 				"mg:aap:read:consents",
 				"mg:aap:create:consents",
 				"mg:aap:delete:consents",
 				"mg:aap:read:shadows",
 				"mg:aap:create:shadows",
 				"mg:aap:delete:shadows",
+				"mg:aap:read:revocations",
+				"mg:aap:create:revocations",
+				"mg:aap:read:webhooks",
+				"mg:aap:create:webhooks",
+				"mg:aap:update:webhooks",
+				"mg:aap:delete:webhooks",
+				"mg:aap:read:apps",
+				"mg:aap:delete:apps",
 
 				"0:mg:aap:read:grants",
 				"0:mg:aap:create:grants",
@@ -80,12 +104,23 @@ func PostEntities(env *app.Environment) gin.HandlerFunc {
 				"0:mg:aap:read:subscriptions",
 				"0:mg:aap:create:subscriptions",
 				"0:mg:aap:delete:subscriptions",
+				"0:mg:aap:update:subscriptions:approvals",
+				"0:mg:aap:update:subscriptions:trust",
+				"0:mg:aap:read:entities:judge:cache",
 				"0:mg:aap:read:consents",
 				"0:mg:aap:create:consents",
 				"0:mg:aap:delete:consents",
 				"0:mg:aap:read:shadows",
 				"0:mg:aap:create:shadows",
 				"0:mg:aap:delete:shadows",
+				"0:mg:aap:read:revocations",
+				"0:mg:aap:create:revocations",
+				"0:mg:aap:read:webhooks",
+				"0:mg:aap:create:webhooks",
+				"0:mg:aap:update:webhooks",
+				"0:mg:aap:delete:webhooks",
+				"0:mg:aap:read:apps",
+				"0:mg:aap:delete:apps",
 			}
 
 			for _, request := range iRequests {
@@ -126,6 +161,12 @@ func PostEntities(env *app.Environment) gin.HandlerFunc {
 						Scopes:    r.Scopes,
 					}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
+
+					events = append(events, app.NewEvent(env, c, aap.EVENT_ENTITY_CREATED, aap.EventEntity{
+						Reference: entity.Id,
+						Creator:   r.Creator,
+						Scopes:    r.Scopes,
+					}))
 					continue
 				}
 
@@ -141,7 +182,20 @@ func PostEntities(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = app.StageEvents(tx, events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+
 				tx.Commit()
+				app.InvalidateVerdicts(env)
+				env.Outbox.Notify()
 				return
 			}
 
@@ -9,6 +9,7 @@ import (
 
 	"github.com/opensentry/aap/app"
 	"github.com/opensentry/aap/client"
+	"github.com/opensentry/aap/config"
 	"github.com/opensentry/aap/gateway/aap"
 
 	hydra "github.com/charmixer/hydra/client"
@@ -45,6 +46,8 @@ func GetEntitiesJudge(env *app.Environment) gin.HandlerFunc {
 
 			hydraClient := hydra.NewHydraClient(env.OAuth2Delegator.Config)
 
+			// Judge all requests at once, so each distinct access token is introspected once
+			var judgeRequests []app.JudgeRequest
 			for _, request := range iRequests {
 				r := request.Input.(client.ReadEntitiesJudgeRequest)
 
@@ -67,17 +70,32 @@ func GetEntitiesJudge(env *app.Environment) gin.HandlerFunc {
 					iOwners = append(iOwners, aap.Identity{Id: id})
 				}
 
-				judgeVerdict, err := app.Judge(tx, tokenFromRequest, iPublisher, iScopes, iOwners, iCaller, hydraClient, env.OAuth2Delegator.IntrospectTokenUrl)
-				if err != nil {
-					e := tx.Rollback()
-					if e != nil {
-						log.Debug(e.Error())
-					}
-					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
-					request.Output = bulky.NewInternalErrorResponse(request.Index)
-					log.Debug(err.Error())
-					return
+				judgeRequests = append(judgeRequests, app.JudgeRequest{
+					Token:     tokenFromRequest,
+					Publisher: iPublisher,
+					Scopes:    iScopes,
+					Owners:    iOwners,
+					Caller:    iCaller,
+				})
+			}
+
+			judgeVerdicts, err := app.JudgeMany(env, tx, judgeRequests, hydraClient)
+			if err != nil {
+				e := tx.Rollback()
+				if e != nil {
+					log.Debug(e.Error())
 				}
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+
+			for i, request := range iRequests {
+				r := request.Input.(client.ReadEntitiesJudgeRequest)
+				judgeVerdict := judgeVerdicts[i]
+				iPublisher := judgeRequests[i].Publisher
+				iScopes := judgeRequests[i].Scopes
+				iOwners := judgeRequests[i].Owners
 
 				var grantedScopes []string
 				for _, s := range judgeVerdict.Verdict.GrantedScopes {
@@ -89,12 +107,64 @@ func GetEntitiesJudge(env *app.Environment) gin.HandlerFunc {
 					owners = append(owners, o.Id)
 				}
 
+				var shadows []client.VerdictShadow
+				for _, s := range judgeVerdict.Verdict.Shadows {
+					shadows = append(shadows, client.VerdictShadow{
+						Scope:  s.Scope.Name,
+						Shadow: s.Shadow.Id,
+					})
+				}
+
+				var missingSubscriptions []string
+				for _, s := range judgeVerdict.Verdict.MissingSubscriptions {
+					missingSubscriptions = append(missingSubscriptions, s.Name)
+				}
+
+				var missingConsents []string
+				for _, s := range judgeVerdict.Verdict.MissingConsents {
+					missingConsents = append(missingConsents, s.Name)
+				}
+
+				var stepUps []client.VerdictStepUp
+				for _, s := range judgeVerdict.StepUps {
+					stepUps = append(stepUps, client.VerdictStepUp{
+						Scope:  s.Scope.Name,
+						Acr:    s.Acr,
+						Amr:    s.Amr,
+						MaxAge: s.MaxAge,
+					})
+				}
+
+				var explanation *client.VerdictExplanation
+				if r.Explain {
+					ex, err := app.Explain(tx, judgeVerdict, iPublisher, iScopes, iOwners)
+					if err != nil {
+						e := tx.Rollback()
+						if e != nil {
+							log.Debug(e.Error())
+						}
+						bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+						request.Output = bulky.NewInternalErrorResponse(request.Index)
+						log.Debug(err.Error())
+						return
+					}
+					explanation = marshalExplanation(ex)
+				}
+
 				request.Output = bulky.NewOkResponse(request.Index, client.ReadEntitiesJudgeResponse{
-					Granted:   judgeVerdict.Verdict.Granted,
-					Identity:  judgeVerdict.Verdict.Requestor.Id,
-					Publisher: judgeVerdict.Verdict.Publisher.Id,
-					Scope:     strings.Join(grantedScopes, " "),
-					Owners:    owners,
+					Granted:     judgeVerdict.Verdict.Granted,
+					Identity:    judgeVerdict.Verdict.Requestor.Id,
+					Publisher:   judgeVerdict.Verdict.Publisher.Id,
+					Scope:       strings.Join(grantedScopes, " "),
+					Owners:      owners,
+					Shadows:     shadows,
+					Explanation: explanation,
+
+					MissingSubscriptions: missingSubscriptions,
+					MissingConsents:      missingConsents,
+
+					Error:   judgeVerdict.Error,
+					StepUps: stepUps,
 				})
 			}
 
@@ -108,8 +178,74 @@ func GetEntitiesJudge(env *app.Environment) gin.HandlerFunc {
 			tx.Rollback()
 		}
 
-		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: 1})
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{MaxRequests: int64(config.GetInt("judge.requests.max"))})
 		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
 }
+
+func marshalExplanation(e app.Explanation) *client.VerdictExplanation {
+	explanation := client.VerdictExplanation{
+		Reason: e.Reason,
+		Introspection: client.VerdictExplanationIntrospection{
+			Active:   e.Introspection.Active,
+			Subject:  e.Introspection.Subject.Id,
+			ClientId: e.Introspection.Client.Id,
+			Reason:   e.Introspection.Reason,
+		},
+		Scopes:        []client.VerdictExplanationScope{},
+		Owners:        []string{},
+		MissingScopes: []string{},
+	}
+
+	for _, s := range e.Scopes {
+		scope := client.VerdictExplanationScope{
+			Scope:     s.Scope.Name,
+			Published: s.Published,
+			Granted:   s.Granted,
+			Grants:    []client.VerdictExplanationGrant{},
+		}
+
+		for _, g := range s.Grants {
+			var path []string
+			for _, i := range g.Path {
+				path = append(path, i.Id)
+			}
+
+			scope.Grants = append(scope.Grants, client.VerdictExplanationGrant{
+				Path:      path,
+				Owner:     g.Owner.Id,
+				NotBefore: g.GrantRule.NotBefore,
+				Expire:    g.GrantRule.Expire,
+				Accepted:  g.Accepted,
+				Rejected:  g.Rejected,
+			})
+		}
+
+		explanation.Scopes = append(explanation.Scopes, scope)
+	}
+
+	for _, o := range e.Owners {
+		explanation.Owners = append(explanation.Owners, o.Id)
+	}
+
+	for _, s := range e.MissingScopes {
+		explanation.MissingScopes = append(explanation.MissingScopes, s.Name)
+	}
+
+	return &explanation
+}
+
+func GetEntitiesJudgeCache(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		stats := env.VerdictCache.Stats()
+
+		c.JSON(http.StatusOK, client.ReadEntitiesJudgeCacheResponse{
+			Enabled: app.IsVerdictCacheEnabled(),
+			Hits:    stats.Hits,
+			Misses:  stats.Misses,
+			Entries: stats.Entries,
+		})
+	}
+	return gin.HandlerFunc(fn)
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/opensentry/aap/app"
 	"github.com/opensentry/aap/client"
+	E "github.com/opensentry/aap/client/errors"
 	"github.com/opensentry/aap/gateway/aap"
 
 	bulky "github.com/charmixer/bulky/server"
@@ -147,6 +148,10 @@ func PostGrants(env *app.Environment) gin.HandlerFunc {
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
 
+			var events []aap.Event
+
+			requestor := c.MustGet("sub").(string)
+
 			for _, request := range iRequests {
 				r := request.Input.(client.CreateGrantsRequest)
 
@@ -166,6 +171,30 @@ func PostGrants(env *app.Environment) gin.HandlerFunc {
 					Id: r.OnBehalfOf,
 				}
 
+				// Only identities holding a may grant of the scope on behalf of the owner can grant it
+				mayGrant, err := aap.IsMayGranted(tx, aap.Identity{Id: requestor}, iScope, iPublishedBy, iOnBehalfOf)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if mayGrant == false {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					log.WithFields(logrus.Fields{"id": requestor, "scope": r.Scope, "publisher_id": r.Publisher, "on_behalf_of_id": r.OnBehalfOf}).Debug("Missing may grant")
+					bulky.FailAllRequestsWithClientOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.MAY_GRANT_REQUIRED)
+					return
+				}
+
 				grant, err := aap.CreateGrant(tx, iReceive, iScope, iPublishedBy, iOnBehalfOf, r.NotBefore, r.Expire)
 				if err != nil {
 					e := tx.Rollback()
@@ -182,6 +211,18 @@ func PostGrants(env *app.Environment) gin.HandlerFunc {
 					return
 				}
 
+				rev, err := aap.FetchGrantsRevision(tx, grant.Identity)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
 				ok := client.Grant{
 					Identity:   grant.Identity.Id,
 					Scope:      grant.Scope.Name,
@@ -192,12 +233,35 @@ func PostGrants(env *app.Environment) gin.HandlerFunc {
 				}
 
 				request.Output = bulky.NewOkResponse(request.Index, ok)
+
+				events = append(events, app.NewEvent(env, c, aap.EVENT_GRANT_CREATED, aap.EventGrant{
+					Identity:   grant.Identity.Id,
+					Scope:      grant.Scope.Name,
+					Publisher:  grant.Publisher.Id,
+					OnBehalfOf: grant.OnBehalfOf.Id,
+					NotBefore:  grant.GrantRule.NotBefore,
+					Expire:     grant.GrantRule.Expire,
+					Revision:   rev,
+				}))
 			}
 
 			err = bulky.OutputValidateRequests(iRequests)
 
 			if err == nil {
+				err = app.StageEvents(tx, events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+
 				tx.Commit()
+				app.InvalidateVerdicts(env)
+				env.Outbox.Notify()
 				return
 			}
 
@@ -238,6 +302,8 @@ func DeleteGrants(env *app.Environment) gin.HandlerFunc {
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
 
+			var events []aap.Event
+
 			requestor := c.MustGet("sub").(string)
 
 			for _, request := range iRequests {
@@ -245,6 +311,30 @@ func DeleteGrants(env *app.Environment) gin.HandlerFunc {
 
 				log = log.WithFields(logrus.Fields{"id": requestor})
 
+				// Only identities holding a may grant of the scope on behalf of the owner can revoke it
+				mayGrant, err := aap.IsMayGranted(tx, aap.Identity{Id: requestor}, aap.Scope{Name: r.Scope}, aap.Identity{Id: r.Publisher}, aap.Identity{Id: r.OnBehalfOf})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if mayGrant == false {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					log.WithFields(logrus.Fields{"scope": r.Scope, "publisher_id": r.Publisher, "on_behalf_of_id": r.OnBehalfOf}).Debug("Missing may grant")
+					bulky.FailAllRequestsWithClientOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.MAY_GRANT_REQUIRED)
+					return
+				}
+
 				dbGrants, err := aap.FetchGrants(tx, aap.Identity{Id: r.Identity}, []aap.Scope{{Name: r.Scope}}, []aap.Identity{{Id: r.Publisher}}, []aap.Identity{{Id: r.OnBehalfOf}})
 				if err != nil {
 					request.Output = bulky.NewInternalErrorResponse(request.Index)
@@ -274,8 +364,30 @@ func DeleteGrants(env *app.Environment) gin.HandlerFunc {
 						return
 					}
 
+					rev, err := aap.FetchGrantsRevision(tx, grantToDelete.Identity)
+					if err != nil {
+						e := tx.Rollback()
+						if e != nil {
+							log.Debug(e.Error())
+						}
+						bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+						request.Output = bulky.NewInternalErrorResponse(request.Index)
+						log.Debug(err.Error())
+						return
+					}
+
 					ok := client.DeleteGrantsResponse{}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
+
+					events = append(events, app.NewEvent(env, c, aap.EVENT_GRANT_DELETED, aap.EventGrant{
+						Identity:   grantToDelete.Identity.Id,
+						Scope:      grantToDelete.Scope.Name,
+						Publisher:  grantToDelete.Publisher.Id,
+						OnBehalfOf: grantToDelete.OnBehalfOf.Id,
+						NotBefore:  grantToDelete.GrantRule.NotBefore,
+						Expire:     grantToDelete.GrantRule.Expire,
+						Revision:   rev,
+					}))
 					continue
 				}
 
@@ -292,7 +404,20 @@ func DeleteGrants(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = app.StageEvents(tx, events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+
 				tx.Commit()
+				app.InvalidateVerdicts(env)
+				env.Outbox.Notify()
 				return
 			}
 
@@ -4,10 +4,13 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"strings"
 
 	"github.com/opensentry/aap/app"
 	"github.com/opensentry/aap/client"
+	E "github.com/opensentry/aap/client/errors"
 	"github.com/opensentry/aap/gateway/aap"
+	"github.com/opensentry/aap/utils"
 
 	bulky "github.com/charmixer/bulky/server"
 )
@@ -37,6 +40,8 @@ func PostPublishes(env *app.Environment) gin.HandlerFunc {
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
 
+			var events []aap.Event
+
 			requestor := c.MustGet("sub").(string)
 
 			for _, request := range iRequests {
@@ -46,8 +51,13 @@ func PostPublishes(env *app.Environment) gin.HandlerFunc {
 					Publisher: aap.Identity{Id: r.Publisher},
 					Scope:     aap.Scope{Name: r.Scope},
 					Rule: aap.PublishRule{
-						Title:       r.Title,
-						Description: r.Description,
+						Title:            r.Title,
+						Description:      r.Description,
+						Translations:     mapTranslationsToPublishRule(r.Translations),
+						ApprovalRequired: r.ApprovalRequired,
+						Acr:              r.Acr,
+						Amr:              r.Amr,
+						MaxAge:           r.MaxAge,
 					},
 				}
 				db, err := aap.CreatePublishes(tx, aap.Identity{Id: requestor}, newPublish)
@@ -69,13 +79,29 @@ func PostPublishes(env *app.Environment) gin.HandlerFunc {
 					}
 
 					ok := client.CreatePublishesResponse{
-						Publisher:      db.Publisher.Id,
-						Scope:          db.Scope.Name,
-						Title:          db.Rule.Title,
-						Description:    db.Rule.Description,
-						MayGrantScopes: mgs,
+						Publisher:        db.Publisher.Id,
+						Scope:            db.Scope.Name,
+						Title:            db.Rule.Title,
+						Description:      db.Rule.Description,
+						Translations:     mapPublishRuleToTranslations(db.Rule.Translations),
+						ApprovalRequired: db.Rule.ApprovalRequired,
+						Acr:              db.Rule.Acr,
+						Amr:              db.Rule.Amr,
+						MaxAge:           db.Rule.MaxAge,
+						MayGrantScopes:   mgs,
 					}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
+
+					events = append(events, app.NewEvent(env, c, aap.EVENT_PUBLISH_CREATED, aap.EventPublish{
+						Publisher:        db.Publisher.Id,
+						Scope:            db.Scope.Name,
+						Title:            db.Rule.Title,
+						Description:      db.Rule.Description,
+						ApprovalRequired: db.Rule.ApprovalRequired,
+						Acr:              db.Rule.Acr,
+						Amr:              db.Rule.Amr,
+						MaxAge:           db.Rule.MaxAge,
+					}))
 					continue
 				}
 
@@ -91,7 +117,20 @@ func PostPublishes(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = app.StageEvents(tx, events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+
 				tx.Commit()
+				app.InvalidateVerdicts(env)
+				env.Outbox.Notify()
 				return
 			}
 
@@ -112,7 +151,185 @@ func DeletePublishes(env *app.Environment) gin.HandlerFunc {
 			"func": "DeletePublishes",
 		})
 
-		c.AbortWithStatusJSON(http.StatusOK, gin.H{})
+		var requests []client.DeletePublishesRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := aap.BeginWriteTx(env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			var events []aap.Event
+
+			var clients []string
+			requestClients := make(map[*bulky.Request][]string)
+			oks := make(map[*bulky.Request]client.DeletePublishesResponse)
+
+			for _, request := range iRequests {
+				r := request.Input.(client.DeletePublishesRequest)
+
+				iPublish := aap.Publish{
+					Publisher: aap.Identity{Id: r.Publisher},
+					Scope:     aap.Scope{Name: r.Scope},
+				}
+
+				dbPublishes, err := aap.FetchPublishes(tx, iPublish.Publisher, []aap.Scope{iPublish.Scope})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(dbPublishes) <= 0 {
+					// not found translate into already deleted
+					ok := client.DeletePublishesResponse{
+						Publisher: r.Publisher,
+						Scope:     r.Scope,
+					}
+					request.Output = bulky.NewOkResponse(request.Index, ok)
+					continue
+				}
+
+				dependents, err := aap.FetchPublishDependents(tx, iPublish)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				hasDependents := dependents.Grants > 0 || dependents.Subscriptions > 0 || dependents.Consents > 0
+				if hasDependents && r.Cascade == false {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					log.WithFields(logrus.Fields{"publisher_id": r.Publisher, "scope": r.Scope}).Debug("Publishing has dependents")
+					bulky.FailAllRequestsWithClientOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.PUBLISH_HAS_DEPENDENTS)
+					return
+				}
+
+				err = aap.DeletePublish(tx, iPublish, r.Cascade)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				for _, subscriber := range dependents.Subscribers {
+					clients = append(clients, subscriber.Id)
+					requestClients[request] = append(requestClients[request], subscriber.Id)
+				}
+
+				ok := client.DeletePublishesResponse{
+					Publisher:     r.Publisher,
+					Scope:         r.Scope,
+					Grants:        dependents.Grants,
+					Subscriptions: dependents.Subscriptions,
+					Consents:      dependents.Consents,
+				}
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+				oks[request] = ok
+
+				events = append(events, app.NewEvent(env, c, aap.EVENT_PUBLISH_DELETED, aap.EventPublish{
+					Publisher:     r.Publisher,
+					Scope:         r.Scope,
+					Grants:        dependents.Grants,
+					Subscriptions: dependents.Subscriptions,
+					Consents:      dependents.Consents,
+				}))
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				err = app.StageEvents(tx, events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+
+				tx.Commit()
+				app.InvalidateVerdicts(env)
+				env.Outbox.Notify()
+
+				// Subscribers lost the scope, so the hydra clients must lose it too
+				var failed []string
+
+				readSession, readTx, err := aap.BeginReadTx(env.Driver)
+				if err != nil {
+					log.Debug(err.Error())
+					failed = clients
+				} else {
+					defer readTx.Close() // rolls back if not already committed/rolled back
+					defer readSession.Close()
+
+					var synced []string
+					for _, id := range clients {
+						if utils.StringInSlice(id, synced) {
+							continue
+						}
+						synced = append(synced, id)
+
+						err := aap.SyncScopesToHydra(readTx, aap.Identity{Id: id})
+						if err != nil {
+							failed = append(failed, id)
+							log.WithFields(logrus.Fields{"client_id": id}).Debug(err.Error())
+						}
+					}
+				}
+
+				// The deletes are committed, so report the clients still holding the scope in hydra instead of failing
+				for request, ok := range oks {
+					for _, id := range requestClients[request] {
+						if utils.StringInSlice(id, failed) && !utils.StringInSlice(id, ok.HydraSyncFailed) {
+							ok.HydraSyncFailed = append(ok.HydraSyncFailed, id)
+						}
+					}
+
+					if len(ok.HydraSyncFailed) > 0 {
+						request.Output = bulky.NewOkResponse(request.Index, ok)
+					}
+				}
+
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{})
+		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
 }
@@ -176,11 +393,16 @@ func GetPublishes(env *app.Environment) gin.HandlerFunc {
 					}
 
 					ok = append(ok, client.Publish{
-						Publisher:      db.Publisher.Id,
-						Scope:          db.Scope.Name,
-						Title:          db.Rule.Title,
-						Description:    db.Rule.Description,
-						MayGrantScopes: mgs,
+						Publisher:        db.Publisher.Id,
+						Scope:            db.Scope.Name,
+						Title:            db.Rule.Title,
+						Description:      db.Rule.Description,
+						Translations:     mapPublishRuleToTranslations(db.Rule.Translations),
+						ApprovalRequired: db.Rule.ApprovalRequired,
+						Acr:              db.Rule.Acr,
+						Amr:              db.Rule.Amr,
+						MaxAge:           db.Rule.MaxAge,
+						MayGrantScopes:   mgs,
 					})
 				}
 
@@ -194,3 +416,27 @@ func GetPublishes(env *app.Environment) gin.HandlerFunc {
 	}
 	return gin.HandlerFunc(fn)
 }
+
+func mapTranslationsToPublishRule(translations map[string]client.PublishTranslation) (prTranslations map[string]aap.PublishRuleTranslation) {
+	if len(translations) == 0 {
+		return nil
+	}
+
+	prTranslations = make(map[string]aap.PublishRuleTranslation)
+	for tag, t := range translations {
+		prTranslations[strings.ToLower(tag)] = aap.PublishRuleTranslation{Title: t.Title, Description: t.Description}
+	}
+	return prTranslations
+}
+
+func mapPublishRuleToTranslations(prTranslations map[string]aap.PublishRuleTranslation) (translations map[string]client.PublishTranslation) {
+	if len(prTranslations) == 0 {
+		return nil
+	}
+
+	translations = make(map[string]client.PublishTranslation)
+	for tag, t := range prTranslations {
+		translations[tag] = client.PublishTranslation{Title: t.Title, Description: t.Description}
+	}
+	return translations
+}
@@ -0,0 +1,226 @@
+package revocations
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+
+	"github.com/opensentry/aap/app"
+	"github.com/opensentry/aap/client"
+	"github.com/opensentry/aap/config"
+	"github.com/opensentry/aap/gateway/aap"
+
+	bulky "github.com/charmixer/bulky/server"
+)
+
+func GetRevocations(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "GetRevocations",
+		})
+
+		var requests []client.ReadRevocationsRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequest = func(iRequests []*bulky.Request) {
+			session, tx, err := aap.BeginReadTx(env.Driver)
+
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			for _, request := range iRequests {
+				var r client.ReadRevocationsRequest
+				if request.Input != nil {
+					r = request.Input.(client.ReadRevocationsRequest)
+				}
+
+				iFilterRevocation := aap.Revocation{
+					Jti:      r.Jti,
+					Subject:  r.Subject,
+					ClientId: r.ClientId,
+				}
+
+				revocations, err := aap.FetchRevocations(tx, iFilterRevocation)
+				if err != nil {
+					// fail all requests
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests)
+
+					// specify error on this request
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				var ok = client.ReadRevocationsResponse{}
+				for _, revocation := range revocations {
+					ok = append(ok, client.Revocation{
+						Id:        revocation.Id,
+						Jti:       revocation.Jti,
+						Subject:   revocation.Subject,
+						ClientId:  revocation.ClientId,
+						RevokedAt: revocation.RevokedAt,
+						Expire:    revocation.Expire,
+					})
+				}
+
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+			}
+
+			tx.Commit()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequest, bulky.HandleRequestParams{EnableEmptyRequest: true})
+
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func PostRevocations(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "PostRevocations",
+		})
+
+		var requests []client.CreateRevocationsRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var createdRevocations []aap.Revocation
+
+		var handleRequest = func(iRequests []*bulky.Request) {
+			session, tx, err := aap.BeginWriteTx(env.Driver)
+
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			// Revocations only live as long as the tokens they revoke
+			err = aap.DeleteExpiredRevocations(tx)
+			if err != nil {
+				e := tx.Rollback()
+				if e != nil {
+					log.Debug(e.Error())
+				}
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+
+			var revocations []aap.Revocation
+			for _, request := range iRequests {
+				r := request.Input.(client.CreateRevocationsRequest)
+
+				expire := r.Expire
+				if expire <= 0 {
+					expire = time.Now().Unix() + int64(config.GetInt("revocations.ttl"))
+				}
+
+				iRevocation := aap.Revocation{
+					Jti:      r.Jti,
+					Subject:  r.Subject,
+					ClientId: r.ClientId,
+					Expire:   expire,
+				}
+
+				revocation, err := aap.CreateRevocation(tx, iRevocation)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+
+					// fail all requests
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests)
+
+					// specify error on this request
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+				revocations = append(revocations, revocation)
+
+				ok := client.CreateRevocationsResponse{
+					Id:        revocation.Id,
+					Jti:       revocation.Jti,
+					Subject:   revocation.Subject,
+					ClientId:  revocation.ClientId,
+					RevokedAt: revocation.RevokedAt,
+					Expire:    revocation.Expire,
+				}
+
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+
+			if err == nil {
+				var events []aap.Event
+				for _, revocation := range revocations {
+					events = append(events, app.NewEvent(env, c, aap.EVENT_REVOCATION_CREATED, aap.EventRevocation{
+						Id:        revocation.Id,
+						Jti:       revocation.Jti,
+						Subject:   revocation.Subject,
+						ClientId:  revocation.ClientId,
+						RevokedAt: revocation.RevokedAt,
+						Expire:    revocation.Expire,
+					}))
+				}
+
+				err = app.StageEvents(tx, events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+
+				err = tx.Commit()
+				if err != nil {
+					log.Debug(err.Error())
+					return
+				}
+				createdRevocations = revocations
+				return
+			}
+
+			// deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequest, bulky.HandleRequestParams{})
+
+		// Revoke in this instance right away, the other instances are told by the outbox relay
+		for _, revocation := range createdRevocations {
+			env.Revocations.Add(revocation)
+		}
+		env.Outbox.Notify()
+
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/opensentry/aap/app"
 	"github.com/opensentry/aap/client"
+	E "github.com/opensentry/aap/client/errors"
 	"github.com/opensentry/aap/gateway/aap"
 
 	bulky "github.com/charmixer/bulky/server"
@@ -40,6 +41,8 @@ func PostScopes(env *app.Environment) gin.HandlerFunc {
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
 
+			var events []aap.Event
+
 			for _, request := range iRequests {
 				r := request.Input.(client.CreateScopesRequest)
 
@@ -65,10 +68,30 @@ func PostScopes(env *app.Environment) gin.HandlerFunc {
 				}
 
 				request.Output = bulky.NewOkResponse(request.Index, ok)
+
+				events = append(events, app.NewEvent(env, c, aap.EVENT_SCOPE_CREATED, aap.EventScope{
+					Name:        rScope.Name,
+					Title:       rScope.Title,
+					Description: rScope.Description,
+					Sensitivity: rScope.Sensitivity,
+					Deprecated:  rScope.Deprecated,
+				}))
+			}
+
+			err = app.StageEvents(tx, events)
+			if err != nil {
+				e := tx.Rollback()
+				if e != nil {
+					log.Debug(e.Error())
+				}
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
 			}
 
 			// should be deny by default
 			tx.Commit()
+			env.Outbox.Notify()
 		}
 
 		responses := bulky.HandleRequest(requests, handleRequest, bulky.HandleRequestParams{})
@@ -123,7 +146,11 @@ func GetScopes(env *app.Environment) gin.HandlerFunc {
 					}
 
 					ok = append(ok, client.Scope{
-						Scope: d.Name,
+						Scope:       d.Name,
+						Title:       d.Title,
+						Description: d.Description,
+						Sensitivity: d.Sensitivity,
+						Deprecated:  d.Deprecated,
 					})
 				}
 
@@ -142,7 +169,7 @@ func PutScopes(env *app.Environment) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
 		log = log.WithFields(logrus.Fields{
-			"func": "PostScopes",
+			"func": "PutScopes",
 		})
 
 		var requests []client.UpdateScopesRequest
@@ -152,7 +179,93 @@ func PutScopes(env *app.Environment) gin.HandlerFunc {
 			return
 		}
 
-		c.AbortWithStatus(http.StatusNotFound)
+		var handleRequest = func(iRequests []*bulky.Request) {
+			session, tx, err := aap.BeginWriteTx(env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			var events []aap.Event
+
+			for _, request := range iRequests {
+				r := request.Input.(client.UpdateScopesRequest)
+
+				update := aap.ScopeUpdate{
+					Title:       r.Title,
+					Description: r.Description,
+					Sensitivity: r.Sensitivity,
+					Deprecated:  r.Deprecated,
+				}
+
+				rScope, err := aap.UpdateScope(tx, aap.Scope{Name: r.Scope}, update)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if rScope.Name == "" {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithClientOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.SCOPE_NOT_FOUND)
+					return
+				}
+
+				ok := client.UpdateScopesResponse{
+					Scope:       rScope.Name,
+					Title:       rScope.Title,
+					Description: rScope.Description,
+					Sensitivity: rScope.Sensitivity,
+					Deprecated:  rScope.Deprecated,
+				}
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+
+				events = append(events, app.NewEvent(env, c, aap.EVENT_SCOPE_UPDATED, aap.EventScope{
+					Name:        rScope.Name,
+					Title:       rScope.Title,
+					Description: rScope.Description,
+					Sensitivity: rScope.Sensitivity,
+					Deprecated:  rScope.Deprecated,
+				}))
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				err = app.StageEvents(tx, events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+
+				tx.Commit()
+				env.Outbox.Notify()
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequest, bulky.HandleRequestParams{})
+
+		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
 }
@@ -120,6 +120,8 @@ func PostShadows(env *app.Environment) gin.HandlerFunc {
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
 
+			var events []aap.Event
+
 			for _, request := range iRequests {
 				r := request.Input.(client.CreateShadowsRequest)
 
@@ -157,12 +159,32 @@ func PostShadows(env *app.Environment) gin.HandlerFunc {
 				}
 
 				request.Output = bulky.NewOkResponse(request.Index, ok)
+
+				events = append(events, app.NewEvent(env, c, aap.EVENT_SHADOW_CREATED, aap.EventShadow{
+					Identity:  shadow.Identity.Id,
+					Shadow:    shadow.Shadow.Id,
+					NotBefore: shadow.GrantRule.NotBefore,
+					Expire:    shadow.GrantRule.Expire,
+				}))
 			}
 
 			err = bulky.OutputValidateRequests(iRequests)
 
 			if err == nil {
+				err = app.StageEvents(tx, events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+
 				tx.Commit()
+				app.InvalidateVerdicts(env)
+				env.Outbox.Notify()
 				return
 			}
 
@@ -203,6 +225,8 @@ func DeleteShadows(env *app.Environment) gin.HandlerFunc {
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
 
+			var events []aap.Event
+
 			for _, request := range iRequests {
 				r := request.Input.(client.DeleteShadowsRequest)
 
@@ -245,6 +269,13 @@ func DeleteShadows(env *app.Environment) gin.HandlerFunc {
 
 					ok := client.DeleteShadowsResponse{}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
+
+					events = append(events, app.NewEvent(env, c, aap.EVENT_SHADOW_DELETED, aap.EventShadow{
+						Identity:  shadowToDelete.Identity.Id,
+						Shadow:    shadowToDelete.Shadow.Id,
+						NotBefore: shadowToDelete.GrantRule.NotBefore,
+						Expire:    shadowToDelete.GrantRule.Expire,
+					}))
 					continue
 				}
 
@@ -261,7 +292,20 @@ func DeleteShadows(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = app.StageEvents(tx, events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+
 				tx.Commit()
+				app.InvalidateVerdicts(env)
+				env.Outbox.Notify()
 				return
 			}
 
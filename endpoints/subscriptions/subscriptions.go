@@ -7,7 +7,10 @@ import (
 
 	"github.com/opensentry/aap/app"
 	"github.com/opensentry/aap/client"
+	E "github.com/opensentry/aap/client/errors"
+	"github.com/opensentry/aap/config"
 	"github.com/opensentry/aap/gateway/aap"
+	"github.com/opensentry/aap/utils"
 
 	bulky "github.com/charmixer/bulky/server"
 )
@@ -37,6 +40,8 @@ func PostSubscriptions(env *app.Environment) gin.HandlerFunc {
 			defer tx.Close() // rolls back if not already committed/rolled back
 			defer session.Close()
 
+			var events []aap.Event
+
 			requestor := c.MustGet("sub").(string)
 
 			var clients []string
@@ -44,10 +49,44 @@ func PostSubscriptions(env *app.Environment) gin.HandlerFunc {
 			for _, request := range iRequests {
 				r := request.Input.(client.CreateSubscriptionsRequest)
 
+				// Trusted clients skip the consent prompt, so trusting is done on behalf of the publisher
+				if r.Trusted {
+					verdicts, err := aap.JudgeMany(tx, []aap.JudgeQuery{{
+						Publisher: aap.Identity{Id: config.GetString("id")},
+						Requestor: aap.Identity{Id: requestor},
+						Scopes:    []aap.Scope{{Name: "aap:update:subscriptions:trust"}},
+						Owners:    []aap.Identity{{Id: r.Publisher}},
+					}})
+					if err != nil {
+						e := tx.Rollback()
+						if e != nil {
+							log.Debug(e.Error())
+						}
+						bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+						request.Output = bulky.NewInternalErrorResponse(request.Index)
+						log.Debug(err.Error())
+						return
+					}
+
+					if len(verdicts) <= 0 || !verdicts[0].Granted {
+						e := tx.Rollback()
+						if e != nil {
+							log.Debug(e.Error())
+						}
+						bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+						request.Output = bulky.NewClientErrorResponse(request.Index, E.TRUST_NOT_ALLOWED)
+						return
+					}
+				}
+
 				iSubscription := aap.Subscription{
 					Subscriber: aap.Identity{Id: r.Subscriber},
 					Publisher:  aap.Identity{Id: r.Publisher},
 					Scope:      aap.Scope{Name: r.Scope},
+					SubscribeRule: aap.SubscribeRule{
+						Required: r.Required,
+						Trusted:  r.Trusted,
+					},
 				}
 				rSubscription, err := aap.CreateSubscription(tx, iSubscription, aap.Identity{Id: requestor})
 				if err != nil {
@@ -66,10 +105,24 @@ func PostSubscriptions(env *app.Environment) gin.HandlerFunc {
 						Subscriber: rSubscription.Subscriber.Id,
 						Publisher:  rSubscription.Publisher.Id,
 						Scope:      rSubscription.Scope.Name,
+						Required:   rSubscription.SubscribeRule.Required,
+						Trusted:    rSubscription.SubscribeRule.Trusted,
+						State:      rSubscription.SubscribeRule.State,
+						DecidedBy:  rSubscription.SubscribeRule.DecidedBy.Id,
+						DecidedAt:  rSubscription.SubscribeRule.DecidedAt,
 					}
 					request.Output = bulky.NewOkResponse(request.Index, ok)
 
 					clients = append(clients, rSubscription.Subscriber.Id)
+
+					events = append(events, app.NewEvent(env, c, aap.EVENT_SUBSCRIPTION_CREATED, aap.EventSubscription{
+						Subscriber: rSubscription.Subscriber.Id,
+						Publisher:  rSubscription.Publisher.Id,
+						Scope:      rSubscription.Scope.Name,
+						Required:   rSubscription.SubscribeRule.Required,
+						Trusted:    rSubscription.SubscribeRule.Trusted,
+						State:      rSubscription.SubscribeRule.State,
+					}))
 					continue
 				}
 
@@ -85,7 +138,20 @@ func PostSubscriptions(env *app.Environment) gin.HandlerFunc {
 
 			err = bulky.OutputValidateRequests(iRequests)
 			if err == nil {
+				err = app.StageEvents(tx, events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+
 				tx.Commit()
+				app.InvalidateVerdicts(env)
+				env.Outbox.Notify()
 
 				readSession, readTx, err := aap.BeginReadTx(env.Driver)
 				if err != nil {
@@ -119,7 +185,112 @@ func DeleteSubscriptions(env *app.Environment) gin.HandlerFunc {
 			"func": "DeleteSubscriptions",
 		})
 
-		c.AbortWithStatusJSON(http.StatusOK, gin.H{})
+		var requests []client.DeleteSubscriptionsRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := aap.BeginWriteTx(env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			var events []aap.Event
+
+			var clients []string
+
+			for _, request := range iRequests {
+				r := request.Input.(client.DeleteSubscriptionsRequest)
+
+				iSubscription := aap.Subscription{
+					Subscriber: aap.Identity{Id: r.Subscriber},
+					Publisher:  aap.Identity{Id: r.Publisher},
+					Scope:      aap.Scope{Name: r.Scope},
+				}
+				rSubscription, deletedConsents, err := aap.DeleteSubscription(tx, iSubscription, r.DeleteConsents)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				ok := client.DeleteSubscriptionsResponse{
+					Subscriber: r.Subscriber,
+					Publisher:  r.Publisher,
+					Scope:      r.Scope,
+					Deleted:    rSubscription.Subscriber.Id != "",
+					Consents:   deletedConsents,
+				}
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+
+				if ok.Deleted && !utils.StringInSlice(r.Subscriber, clients) {
+					clients = append(clients, r.Subscriber)
+				}
+
+				if ok.Deleted {
+					events = append(events, app.NewEvent(env, c, aap.EVENT_SUBSCRIPTION_DELETED, aap.EventSubscription{
+						Subscriber: r.Subscriber,
+						Publisher:  r.Publisher,
+						Scope:      r.Scope,
+						Consents:   deletedConsents,
+					}))
+				}
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				err = app.StageEvents(tx, events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+
+				tx.Commit()
+				app.InvalidateVerdicts(env)
+				env.Outbox.Notify()
+
+				readSession, readTx, err := aap.BeginReadTx(env.Driver)
+				if err != nil {
+					log.Debug(err.Error())
+					return
+				}
+				defer readTx.Close() // rolls back if not already committed/rolled back
+				defer readSession.Close()
+
+				for _, id := range clients {
+					err := aap.SyncScopesToHydra(readTx, aap.Identity{Id: id})
+					if err != nil {
+						log.WithFields(logrus.Fields{"client_id": id}).Debug(err.Error())
+					}
+				}
+
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{})
+		c.JSON(http.StatusOK, responses)
 	}
 	return gin.HandlerFunc(fn)
 }
@@ -192,10 +363,19 @@ func GetSubscriptions(env *app.Environment) gin.HandlerFunc {
 
 				var ok = client.ReadSubscriptionsResponse{}
 				for _, subscription := range subscriptions {
+					if r.State != "" && subscription.SubscribeRule.State != r.State {
+						continue
+					}
+
 					ok = append(ok, client.Subscription{
 						Subscriber: subscription.Subscriber.Id,
 						Scope:      subscription.Scope.Name,
 						Publisher:  subscription.Publisher.Id,
+						Required:   subscription.SubscribeRule.Required,
+						Trusted:    subscription.SubscribeRule.Trusted,
+						State:      subscription.SubscribeRule.State,
+						DecidedBy:  subscription.SubscribeRule.DecidedBy.Id,
+						DecidedAt:  subscription.SubscribeRule.DecidedAt,
 					})
 				}
 
@@ -209,3 +389,169 @@ func GetSubscriptions(env *app.Environment) gin.HandlerFunc {
 	}
 	return gin.HandlerFunc(fn)
 }
+
+// PutSubscriptionsApprovals approves or rejects subscriptions. The requestor must be granted aap:update:subscriptions:approvals on behalf of the publisher.
+func PutSubscriptionsApprovals(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "PutSubscriptionsApprovals",
+		})
+
+		var requests []client.UpdateSubscriptionsApprovalsRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+
+			session, tx, err := aap.BeginWriteTx(env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			var events []aap.Event
+
+			requestor := aap.Identity{Id: c.MustGet("sub").(string)}
+
+			var clients []string
+
+			for _, request := range iRequests {
+				r := request.Input.(client.UpdateSubscriptionsApprovalsRequest)
+
+				// Approving is done on behalf of the publisher
+				verdicts, err := aap.JudgeMany(tx, []aap.JudgeQuery{{
+					Publisher: aap.Identity{Id: config.GetString("id")},
+					Requestor: requestor,
+					Scopes:    []aap.Scope{{Name: "aap:update:subscriptions:approvals"}},
+					Owners:    []aap.Identity{{Id: r.Publisher}},
+				}})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(verdicts) <= 0 || !verdicts[0].Granted {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.APPROVAL_NOT_ALLOWED)
+					return
+				}
+
+				iSubscription := aap.Subscription{
+					Subscriber: aap.Identity{Id: r.Subscriber},
+					Publisher:  aap.Identity{Id: r.Publisher},
+					Scope:      aap.Scope{Name: r.Scope},
+				}
+				rSubscription, err := aap.UpdateSubscriptionState(tx, iSubscription, r.State, requestor)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if rSubscription.Subscriber.Id == "" {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.SUBSCRIPTION_NOT_FOUND)
+					return
+				}
+
+				ok := client.UpdateSubscriptionsApprovalsResponse{
+					Subscriber: rSubscription.Subscriber.Id,
+					Publisher:  rSubscription.Publisher.Id,
+					Scope:      rSubscription.Scope.Name,
+					Required:   rSubscription.SubscribeRule.Required,
+					Trusted:    rSubscription.SubscribeRule.Trusted,
+					State:      rSubscription.SubscribeRule.State,
+					DecidedBy:  rSubscription.SubscribeRule.DecidedBy.Id,
+					DecidedAt:  rSubscription.SubscribeRule.DecidedAt,
+				}
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+
+				if !utils.StringInSlice(rSubscription.Subscriber.Id, clients) {
+					clients = append(clients, rSubscription.Subscriber.Id)
+				}
+
+				eventType := aap.EVENT_SUBSCRIPTION_APPROVED
+				if rSubscription.SubscribeRule.State == aap.SUBSCRIPTION_REJECTED {
+					eventType = aap.EVENT_SUBSCRIPTION_REJECTED
+				}
+
+				events = append(events, app.NewEvent(env, c, eventType, aap.EventSubscription{
+					Subscriber: rSubscription.Subscriber.Id,
+					Publisher:  rSubscription.Publisher.Id,
+					Scope:      rSubscription.Scope.Name,
+					Required:   rSubscription.SubscribeRule.Required,
+					Trusted:    rSubscription.SubscribeRule.Trusted,
+					State:      rSubscription.SubscribeRule.State,
+				}))
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				err = app.StageEvents(tx, events)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+					log.Debug(err.Error())
+					return
+				}
+
+				tx.Commit()
+				app.InvalidateVerdicts(env)
+				env.Outbox.Notify()
+
+				readSession, readTx, err := aap.BeginReadTx(env.Driver)
+				if err != nil {
+					log.Debug(err.Error())
+					return
+				}
+				defer readTx.Close() // rolls back if not already committed/rolled back
+				defer readSession.Close()
+
+				for _, id := range clients {
+					err := aap.SyncScopesToHydra(readTx, aap.Identity{Id: id})
+					if err != nil {
+						log.WithFields(logrus.Fields{"client_id": id}).Debug(err.Error())
+					}
+				}
+
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{})
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
@@ -0,0 +1,548 @@
+package webhooks
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+	"github.com/sirupsen/logrus"
+	"net/http"
+
+	"github.com/opensentry/aap/app"
+	"github.com/opensentry/aap/client"
+	E "github.com/opensentry/aap/client/errors"
+	"github.com/opensentry/aap/config"
+	"github.com/opensentry/aap/gateway/aap"
+
+	bulky "github.com/charmixer/bulky/server"
+)
+
+// allowedWebhooks returns the ids of the webhooks the requestor holds iScope on behalf of the publisher of. Webhooks are managed on behalf of their publisher, the way approvals are.
+func allowedWebhooks(tx neo4j.Transaction, iRequestor aap.Identity, iScope string, iWebhooks []aap.Webhook) (allowed map[string]bool, err error) {
+	allowed = make(map[string]bool)
+
+	var judged []aap.Webhook
+	var queries []aap.JudgeQuery
+	for _, webhook := range iWebhooks {
+		// Registered before publishers were required, nobody manages them
+		if webhook.Publisher.Id == "" {
+			continue
+		}
+
+		judged = append(judged, webhook)
+		queries = append(queries, aap.JudgeQuery{
+			Publisher: aap.Identity{Id: config.GetString("id")},
+			Requestor: iRequestor,
+			Scopes:    []aap.Scope{{Name: iScope}},
+			Owners:    []aap.Identity{{Id: webhook.Publisher.Id}},
+		})
+	}
+
+	if len(queries) <= 0 {
+		return allowed, nil
+	}
+
+	verdicts, err := aap.JudgeMany(tx, queries)
+	if err != nil {
+		return nil, err
+	}
+
+	for index, verdict := range verdicts {
+		if verdict.Granted {
+			allowed[judged[index].Id] = true
+		}
+	}
+
+	return allowed, nil
+}
+
+func GetWebhooks(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "GetWebhooks",
+		})
+
+		var requests []client.ReadWebhooksRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequest = func(iRequests []*bulky.Request) {
+			session, tx, err := aap.BeginReadTx(env.Driver)
+
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			requestor := aap.Identity{Id: c.MustGet("sub").(string)}
+
+			for _, request := range iRequests {
+				var r client.ReadWebhooksRequest
+				if request.Input != nil {
+					r = request.Input.(client.ReadWebhooksRequest)
+				}
+
+				var iFilterWebhooks []aap.Webhook
+				if r.Id != "" {
+					iFilterWebhooks = []aap.Webhook{
+						{Id: r.Id},
+					}
+				}
+
+				var allowed map[string]bool
+				webhooks, err := aap.FetchWebhooks(tx, iFilterWebhooks)
+				if err == nil {
+					allowed, err = allowedWebhooks(tx, requestor, "aap:read:webhooks", webhooks)
+				}
+				if err != nil {
+					// fail all requests
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests)
+
+					// specify error on this request
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				// Never expose the secrets
+				var ok = client.ReadWebhooksResponse{}
+				for _, webhook := range webhooks {
+					if !allowed[webhook.Id] {
+						continue
+					}
+
+					ok = append(ok, client.Webhook{
+						Id:        webhook.Id,
+						Url:       webhook.Url,
+						Events:    webhook.Events,
+						Publisher: webhook.Publisher.Id,
+						CreatedAt: webhook.CreatedAt,
+					})
+				}
+
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+			}
+
+			tx.Commit()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequest, bulky.HandleRequestParams{EnableEmptyRequest: true})
+
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func PostWebhooks(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "PostWebhooks",
+		})
+
+		var requests []client.CreateWebhooksRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequest = func(iRequests []*bulky.Request) {
+			session, tx, err := aap.BeginWriteTx(env.Driver)
+
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			requestor := aap.Identity{Id: c.MustGet("sub").(string)}
+
+			for _, request := range iRequests {
+				r := request.Input.(client.CreateWebhooksRequest)
+
+				// Registering is done on behalf of the publisher
+				verdicts, err := aap.JudgeMany(tx, []aap.JudgeQuery{{
+					Publisher: aap.Identity{Id: config.GetString("id")},
+					Requestor: requestor,
+					Scopes:    []aap.Scope{{Name: "aap:create:webhooks"}},
+					Owners:    []aap.Identity{{Id: r.Publisher}},
+				}})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(verdicts) <= 0 || !verdicts[0].Granted {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.WEBHOOK_NOT_ALLOWED)
+					return
+				}
+
+				iWebhook := aap.Webhook{
+					Url:       r.Url,
+					Events:    r.Events,
+					Secret:    r.Secret,
+					Publisher: aap.Identity{Id: r.Publisher},
+				}
+
+				webhook, err := aap.CreateWebhook(tx, iWebhook)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+
+					// fail all requests
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests)
+
+					// specify error on this request
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				ok := client.CreateWebhooksResponse{
+					Id:        webhook.Id,
+					Url:       webhook.Url,
+					Events:    webhook.Events,
+					Publisher: webhook.Publisher.Id,
+					CreatedAt: webhook.CreatedAt,
+				}
+
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+
+			if err == nil {
+				tx.Commit()
+				return
+			}
+
+			// deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequest, bulky.HandleRequestParams{})
+
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func DeleteWebhooks(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "DeleteWebhooks",
+		})
+
+		var requests []client.DeleteWebhooksRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequests = func(iRequests []*bulky.Request) {
+			session, tx, err := aap.BeginWriteTx(env.Driver)
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			requestor := aap.Identity{Id: c.MustGet("sub").(string)}
+
+			for _, request := range iRequests {
+				r := request.Input.(client.DeleteWebhooksRequest)
+
+				var allowed map[string]bool
+				webhooks, err := aap.FetchWebhooks(tx, []aap.Webhook{{Id: r.Id}})
+				if err == nil {
+					allowed, err = allowedWebhooks(tx, requestor, "aap:delete:webhooks", webhooks)
+				}
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(webhooks) > 0 && !allowed[r.Id] {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.WEBHOOK_NOT_ALLOWED)
+					return
+				}
+
+				// Deleting an unknown webhook translates into already deleted
+				err = aap.DeleteWebhook(tx, aap.Webhook{Id: r.Id})
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				ok := client.DeleteWebhooksResponse{
+					Id: r.Id,
+				}
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+			if err == nil {
+				tx.Commit()
+				return
+			}
+
+			// Deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequests, bulky.HandleRequestParams{})
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func GetWebhookDeliveries(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "GetWebhookDeliveries",
+		})
+
+		var requests []client.ReadWebhookDeliveriesRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequest = func(iRequests []*bulky.Request) {
+			session, tx, err := aap.BeginReadTx(env.Driver)
+
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			requestor := aap.Identity{Id: c.MustGet("sub").(string)}
+
+			for _, request := range iRequests {
+				var r client.ReadWebhookDeliveriesRequest
+				if request.Input != nil {
+					r = request.Input.(client.ReadWebhookDeliveriesRequest)
+				}
+
+				var iFilterWebhooks []aap.Webhook
+				if r.Webhook != "" {
+					iFilterWebhooks = []aap.Webhook{
+						{Id: r.Webhook},
+					}
+				}
+
+				var allowed map[string]bool
+				var deliveries []aap.WebhookDelivery
+				webhooks, err := aap.FetchWebhooks(tx, iFilterWebhooks)
+				if err == nil {
+					allowed, err = allowedWebhooks(tx, requestor, "aap:read:webhooks", webhooks)
+				}
+				if err == nil {
+					deliveries, err = aap.FetchWebhookDeliveries(tx, aap.Webhook{Id: r.Webhook}, r.Status)
+				}
+				if err != nil {
+					// fail all requests
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests)
+
+					// specify error on this request
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				var ok = client.ReadWebhookDeliveriesResponse{}
+				for _, delivery := range deliveries {
+					if !allowed[delivery.Webhook.Id] {
+						continue
+					}
+
+					var attemptLog []client.WebhookDeliveryAttempt
+					for _, attempt := range delivery.AttemptLog {
+						attemptLog = append(attemptLog, client.WebhookDeliveryAttempt{
+							At:         attempt.At,
+							StatusCode: attempt.StatusCode,
+							Error:      attempt.Error,
+							Duration:   attempt.Duration,
+						})
+					}
+
+					ok = append(ok, client.WebhookDelivery{
+						Id:            delivery.Id,
+						Webhook:       delivery.Webhook.Id,
+						EventId:       delivery.EventId,
+						EventType:     delivery.EventType,
+						Status:        delivery.Status,
+						Attempts:      delivery.Attempts,
+						NextAttemptAt: delivery.NextAttemptAt,
+						CreatedAt:     delivery.CreatedAt,
+						AttemptLog:    attemptLog,
+					})
+				}
+
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+			}
+
+			tx.Commit()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequest, bulky.HandleRequestParams{EnableEmptyRequest: true})
+
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func PostWebhookDeliveriesReplay(env *app.Environment) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		log := c.MustGet(env.Constants.LogKey).(*logrus.Entry)
+		log = log.WithFields(logrus.Fields{
+			"func": "PostWebhookDeliveriesReplay",
+		})
+
+		var requests []client.CreateWebhookDeliveriesReplayRequest
+		err := c.BindJSON(&requests)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var handleRequest = func(iRequests []*bulky.Request) {
+			session, tx, err := aap.BeginWriteTx(env.Driver)
+
+			if err != nil {
+				bulky.FailAllRequestsWithInternalErrorResponse(iRequests)
+				log.Debug(err.Error())
+				return
+			}
+
+			defer tx.Close() // rolls back if not already committed/rolled back
+			defer session.Close()
+
+			requestor := aap.Identity{Id: c.MustGet("sub").(string)}
+
+			for _, request := range iRequests {
+				r := request.Input.(client.CreateWebhookDeliveriesReplayRequest)
+
+				var allowed map[string]bool
+				webhooks, err := aap.FetchWebhooks(tx, []aap.Webhook{{Id: r.Webhook}})
+				if err == nil {
+					allowed, err = allowedWebhooks(tx, requestor, "aap:update:webhooks", webhooks)
+				}
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				if len(webhooks) > 0 && !allowed[r.Webhook] {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests) // Fail all with abort
+					request.Output = bulky.NewClientErrorResponse(request.Index, E.WEBHOOK_NOT_ALLOWED)
+					return
+				}
+
+				var iDeliveries []aap.WebhookDelivery
+				for _, id := range r.Deliveries {
+					iDeliveries = append(iDeliveries, aap.WebhookDelivery{Id: id})
+				}
+
+				replayed, err := aap.ReplayWebhookDeliveries(tx, aap.Webhook{Id: r.Webhook}, iDeliveries)
+				if err != nil {
+					e := tx.Rollback()
+					if e != nil {
+						log.Debug(e.Error())
+					}
+
+					// fail all requests
+					bulky.FailAllRequestsWithServerOperationAbortedResponse(iRequests)
+
+					// specify error on this request
+					request.Output = bulky.NewInternalErrorResponse(request.Index)
+					log.Debug(err.Error())
+					return
+				}
+
+				ok := client.CreateWebhookDeliveriesReplayResponse{
+					Webhook:  r.Webhook,
+					Replayed: replayed,
+				}
+
+				request.Output = bulky.NewOkResponse(request.Index, ok)
+			}
+
+			err = bulky.OutputValidateRequests(iRequests)
+
+			if err == nil {
+				tx.Commit()
+				env.Webhooks.Notify()
+				return
+			}
+
+			// deny by default
+			tx.Rollback()
+		}
+
+		responses := bulky.HandleRequest(requests, handleRequest, bulky.HandleRequestParams{})
+
+		c.JSON(http.StatusOK, responses)
+	}
+	return gin.HandlerFunc(fn)
+}
@@ -1,6 +1,7 @@
 package aap
 
 import (
+	"fmt"
 	"github.com/neo4j/neo4j-go-driver/neo4j"
 )
 
@@ -22,7 +23,7 @@ func FetchResourceServerByAudience(driver neo4j.Driver, aud string) (*ResourceSe
 		var cypher string
 		var params map[string]interface{}
 		cypher = `
-    MATCH (rs:ResourceServer:Identity {aud:$aud}) return rs.name, rs.aud, rs.description
+    MATCH (rs:ResourceServer:Identity {aud:$aud}) return rs.name, rs.aud, rs.description, rs.id
     `
 		params = map[string]interface{}{"aud": aud}
 		if result, err = tx.Run(cypher, params); err != nil {
@@ -40,8 +41,10 @@ func FetchResourceServerByAudience(driver neo4j.Driver, aud string) (*ResourceSe
 			name := record.GetByIndex(0).(string)
 			aud := record.GetByIndex(1).(string)
 			description := record.GetByIndex(2).(string)
+			id := record.GetByIndex(3).(string)
 
 			rs = &ResourceServer{
+				Id:          id,
 				Name:        name,
 				Audience:    aud,
 				Description: description,
@@ -59,3 +62,46 @@ func FetchResourceServerByAudience(driver neo4j.Driver, aud string) (*ResourceSe
 	}
 	return ret.(*ResourceServer), nil
 }
+
+// FetchResourceServersByAudiences returns the resource servers by their aud, so all audiences of a judge are resolved in one round trip.
+func FetchResourceServersByAudiences(tx neo4j.Transaction, iAudiences []string) (rResourceServers map[string]ResourceServer, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	rResourceServers = make(map[string]ResourceServer)
+
+	if len(iAudiences) <= 0 {
+		return rResourceServers, nil
+	}
+	params["auds"] = iAudiences
+
+	cypher = fmt.Sprintf(`
+    // FetchResourceServersByAudiences
+
+    MATCH (rs:ResourceServer:Identity) WHERE rs.aud in $auds
+    RETURN rs
+  `)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		resourceServerNode := record.GetByIndex(0)
+
+		if resourceServerNode != nil {
+			rs := marshalNodeToResourceServer(resourceServerNode.(neo4j.Node))
+			rResourceServers[rs.Audience] = rs
+		}
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return rResourceServers, nil
+}
@@ -0,0 +1,124 @@
+package aap
+
+import (
+	"errors"
+	"fmt"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// UpdateClientUsages records when subjects last used subscribers (clients). Usages are only ever moved forward in time.
+func UpdateClientUsages(tx neo4j.Transaction, iUsages []ClientUsage) (err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if len(iUsages) <= 0 {
+		return nil
+	}
+
+	var usages []interface{}
+	for _, u := range iUsages {
+		if u.Identity.Id == "" {
+			return errors.New("Missing iUsage.Identity.Id")
+		}
+
+		if u.Subscriber.Id == "" {
+			return errors.New("Missing iUsage.Subscriber.Id")
+		}
+
+		usages = append(usages, map[string]interface{}{
+			"owner_id":      u.Identity.Id,
+			"subscriber_id": u.Subscriber.Id,
+			"at":            u.LastUsedAt,
+		})
+	}
+	params["usages"] = usages
+
+	cypher = fmt.Sprintf(`
+    // UpdateClientUsages
+
+    UNWIND $usages as usage
+
+    MATCH (owner:Identity {id:usage.owner_id})
+    MATCH (subscriber:Identity {id:usage.subscriber_id})
+
+    MERGE (owner)-[u:USES]->(subscriber)
+    SET u.last_used_at = CASE WHEN coalesce(u.last_used_at, 0) < usage.at THEN usage.at ELSE u.last_used_at END
+  `)
+
+	logCypher(cypher, params)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return err
+	}
+
+	result.Next()
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func FetchClientUsages(tx neo4j.Transaction, iOwner Identity, iFilterSubscriber Identity) (rUsages []ClientUsage, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iOwner.Id == "" {
+		return nil, errors.New("Missing iOwner.Id")
+	}
+	params["owner_id"] = iOwner.Id
+
+	cypSubscriber := ""
+	if iFilterSubscriber.Id != "" {
+		cypSubscriber = ` {id:$subscriber_id} `
+		params["subscriber_id"] = iFilterSubscriber.Id
+	}
+
+	cypher = fmt.Sprintf(`
+    // FetchClientUsages
+
+    MATCH (owner:Identity {id:$owner_id})-[u:USES]->(subscriber:Identity %s)
+
+    RETURN owner, subscriber, u.last_used_at
+  `, cypSubscriber)
+
+	logCypher(cypher, params)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		ownerNode := record.GetByIndex(0)
+		subscriberNode := record.GetByIndex(1)
+		lastUsedAt := record.GetByIndex(2)
+
+		usage := ClientUsage{}
+
+		if ownerNode != nil {
+			usage.Identity = marshalNodeToIdentity(ownerNode.(neo4j.Node))
+		}
+
+		if subscriberNode != nil {
+			usage.Subscriber = marshalNodeToIdentity(subscriberNode.(neo4j.Node))
+		}
+
+		if lastUsedAt != nil {
+			usage.LastUsedAt = lastUsedAt.(int64)
+		}
+
+		rUsages = append(rUsages, usage)
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return rUsages, nil
+}
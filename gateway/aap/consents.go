@@ -7,7 +7,7 @@ import (
 	"strings"
 )
 
-func CreateConsent(tx neo4j.Transaction, iOwner Identity, iSubscriber Identity, iPublisher Identity, iScopes Scope) (consent Consent, err error) {
+func CreateConsent(tx neo4j.Transaction, iOwner Identity, iSubscriber Identity, iPublisher Identity, iScopes Scope, iConsentRule ConsentRule) (consent Consent, err error) {
 	var result neo4j.Result
 	var cypher string
 	var params = make(map[string]interface{})
@@ -32,6 +32,10 @@ func CreateConsent(tx neo4j.Transaction, iOwner Identity, iSubscriber Identity,
 	}
 	params["scope"] = iScopes.Name
 
+	params["nbf"] = iConsentRule.NotBefore
+	params["exp"] = iConsentRule.Expire
+	params["automatic"] = iConsentRule.Automatic
+
 	cypher = fmt.Sprintf(`
     // CreateConsent
 
@@ -45,14 +49,16 @@ func CreateConsent(tx neo4j.Transaction, iOwner Identity, iSubscriber Identity,
     DETACH DELETE existingCr
 
     // ensure unique rules
-    CREATE (cr:Consent:Rule)
+    CREATE (cr:Consent:Rule {nbf:$nbf, exp:$exp, automatic:$automatic})
 
     MERGE (owner)-[:CONSENT]->(cr)-[:CONSENT]->(pr)
     MERGE (cr)-[:CONSENT]->(subscriber)
 
+    %s
+
     // Conclude
-    RETURN publisher, scope, owner, subscriber
-  `)
+    RETURN publisher, scope, owner, subscriber, cr
+  `, cypCreateConsentHistory(CONSENT_HISTORY_GRANTED))
 
 	logCypher(cypher, params)
 
@@ -66,6 +72,11 @@ func CreateConsent(tx neo4j.Transaction, iOwner Identity, iSubscriber Identity,
 		scopeNode := record.GetByIndex(1)
 		ownerNode := record.GetByIndex(2)
 		subscriberNode := record.GetByIndex(3)
+		consentRuleNode := record.GetByIndex(4)
+
+		if consentRuleNode != nil {
+			consent.ConsentRule = marshalNodeToConsentRule(consentRuleNode.(neo4j.Node))
+		}
 
 		if ownerNode != nil {
 			consent.Identity = marshalNodeToIdentity(ownerNode.(neo4j.Node))
@@ -133,8 +144,11 @@ func FetchConsents(tx neo4j.Transaction, iOwner Identity, iSubscriber Identity,
     MATCH (owner:Identity %s)-[:CONSENT]->(cr:Consent:Rule)-[:CONSENT]->(pr)
     MATCH (cr)-[:CONSENT]->(subscriber:Identity %s)
 
+    // Expired consents must be given again. Consents from before nbf/exp was introduced never expire.
+    WHERE coalesce(cr.nbf, 0) <= datetime().epochSeconds AND (coalesce(cr.exp, 0) = 0 OR cr.exp > datetime().epochSeconds)
+
     // conclude
-    RETURN publisher, scope, owner, subscriber
+    RETURN publisher, scope, owner, subscriber, cr
   `, cypPublisher, cypScopes, cypOwner, cypSubscriber)
 
 	if result, err = tx.Run(cypher, params); err != nil {
@@ -149,9 +163,14 @@ func FetchConsents(tx neo4j.Transaction, iOwner Identity, iSubscriber Identity,
 		scopeNode := record.GetByIndex(1)
 		ownerNode := record.GetByIndex(2)
 		subscriberNode := record.GetByIndex(3)
+		consentRuleNode := record.GetByIndex(4)
 
 		consent = Consent{}
 
+		if consentRuleNode != nil {
+			consent.ConsentRule = marshalNodeToConsentRule(consentRuleNode.(neo4j.Node))
+		}
+
 		if ownerNode != nil {
 			consent.Identity = marshalNodeToIdentity(ownerNode.(neo4j.Node))
 		}
@@ -213,11 +232,13 @@ func DeleteConsent(tx neo4j.Transaction, iOwner Identity, iSubscriber Identity,
     MATCH (owner)-[:CONSENT]->(cr:Consent:Rule)-[:CONSENT]->(pr)
     MATCH (cr)-[:CONSENT]->(subscriber)
 
+    %s
+
     DETACH DELETE (cr)
 
     // Conclude
     RETURN publisher, scope, owner, subscriber
-  `)
+  `, cypCreateConsentHistory(CONSENT_HISTORY_WITHDRAWN))
 
 	logCypher(cypher, params)
 
@@ -259,3 +280,80 @@ func DeleteConsent(tx neo4j.Transaction, iOwner Identity, iSubscriber Identity,
 
 	return consent, nil
 }
+
+// DeleteExpiredConsents removes at most iLimit expired consents and returns them.
+func DeleteExpiredConsents(tx neo4j.Transaction, iLimit int64) (rConsents []Consent, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	params["limit"] = iLimit
+
+	cypher = fmt.Sprintf(`
+    // DeleteExpiredConsents
+
+    MATCH (owner:Identity)-[:CONSENT]->(cr:Consent:Rule)-[:CONSENT]->(pr:Publish:Rule)-[:PUBLISH]->(scope:Scope)
+    MATCH (publisher:Identity)-[:PUBLISH]->(pr)
+    MATCH (cr)-[:CONSENT]->(subscriber:Identity)
+    WHERE coalesce(cr.exp, 0) > 0 AND cr.exp <= datetime().epochSeconds
+
+    WITH publisher, scope, pr, owner, subscriber, cr LIMIT $limit
+    WITH publisher, scope, pr, owner, subscriber, cr, cr {.nbf, .exp, .automatic} as rule
+
+    %s
+
+    DETACH DELETE cr
+
+    // Conclude
+    RETURN publisher, scope, owner, subscriber, rule
+  `, cypCreateConsentHistory(CONSENT_HISTORY_EXPIRED))
+
+	logCypher(cypher, params)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		publisherNode := record.GetByIndex(0)
+		scopeNode := record.GetByIndex(1)
+		ownerNode := record.GetByIndex(2)
+		subscriberNode := record.GetByIndex(3)
+		rule := record.GetByIndex(4)
+
+		if publisherNode == nil || scopeNode == nil || ownerNode == nil || subscriberNode == nil {
+			continue
+		}
+
+		consent := Consent{
+			Identity:   marshalNodeToIdentity(ownerNode.(neo4j.Node)),
+			Subscriber: marshalNodeToIdentity(subscriberNode.(neo4j.Node)),
+			Publisher:  marshalNodeToIdentity(publisherNode.(neo4j.Node)),
+			Scope:      marshalNodeToScope(scopeNode.(neo4j.Node)),
+		}
+
+		// The node is deleted, so the rule is returned as a map
+		if rule != nil {
+			r := rule.(map[string]interface{})
+			if r["nbf"] != nil {
+				consent.ConsentRule.NotBefore = r["nbf"].(int64)
+			}
+			if r["exp"] != nil {
+				consent.ConsentRule.Expire = r["exp"].(int64)
+			}
+			if r["automatic"] != nil {
+				consent.ConsentRule.Automatic = r["automatic"].(bool)
+			}
+		}
+
+		rConsents = append(rConsents, consent)
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return rConsents, nil
+}
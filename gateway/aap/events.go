@@ -1,11 +1,113 @@
 package aap
 
-import (
-	"fmt"
-	nats "github.com/nats-io/nats.go"
+// Bump when a payload changes in a non backwards compatible way. See model/events.schema.json
+const EVENT_SCHEMA_VERSION = 1
+
+// Events are stored in the outbox and published on the subject equal to their type, aap.<resource>.<action>
+const (
+	EVENT_ENTITY_CREATED        = "aap.entity.created"
+	EVENT_SCOPE_CREATED         = "aap.scope.created"
+	EVENT_SCOPE_UPDATED         = "aap.scope.updated"
+	EVENT_PUBLISH_CREATED       = "aap.publish.created"
+	EVENT_PUBLISH_DELETED       = "aap.publish.deleted"
+	EVENT_GRANT_CREATED         = "aap.grant.created"
+	EVENT_GRANT_DELETED         = "aap.grant.deleted"
+	EVENT_SHADOW_CREATED        = "aap.shadow.created"
+	EVENT_SHADOW_DELETED        = "aap.shadow.deleted"
+	EVENT_SUBSCRIPTION_CREATED  = "aap.subscription.created"
+	EVENT_SUBSCRIPTION_DELETED  = "aap.subscription.deleted"
+	EVENT_SUBSCRIPTION_APPROVED = "aap.subscription.approved"
+	EVENT_SUBSCRIPTION_REJECTED = "aap.subscription.rejected"
+	EVENT_CONSENT_CREATED       = "aap.consent.created"
+	EVENT_CONSENT_DELETED       = "aap.consent.deleted"
+	EVENT_CONSENT_REJECTED      = "aap.consent.rejected"
+	EVENT_CONSENT_EXPIRED       = "aap.consent.expired"
+	EVENT_REVOCATION_CREATED    = "aap.revocation.created"
 )
 
-func EmitEventConsentCreated(natsConnection *nats.Conn, consent Consent) {
-	e := fmt.Sprintf("{sub:%s, client_id:%s, aud:%s, scope:%s}", consent.Identity.Id, consent.Subscriber.Id, consent.Publisher.Id, consent.Scope.Name)
-	natsConnection.Publish("aap.consent.created", []byte(e))
+type Event struct {
+	Id        string      `json:"id"`
+	Type      string      `json:"type"`
+	Version   int         `json:"version"`
+	Actor     string      `json:"sub,omitempty"` // The identity performing the request causing the event
+	RequestId string      `json:"request_id,omitempty"`
+	Timestamp int64       `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+type EventEntity struct {
+	Reference string   `json:"reference_id"`
+	Creator   string   `json:"creator_id"`
+	Scopes    []string `json:"scopes"`
+}
+
+type EventScope struct {
+	Name        string `json:"name"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Sensitivity string `json:"sensitivity,omitempty"`
+	Deprecated  bool   `json:"deprecated"`
+}
+
+type EventPublish struct {
+	Publisher        string   `json:"publisher_id"`
+	Scope            string   `json:"scope"`
+	Title            string   `json:"title,omitempty"`
+	Description      string   `json:"description,omitempty"`
+	Grants           int64    `json:"grants_deleted,omitempty"`
+	Subscriptions    int64    `json:"subscriptions_deleted,omitempty"`
+	Consents         int64    `json:"consents_deleted,omitempty"`
+	ApprovalRequired bool     `json:"is_approval_required,omitempty"`
+	Acr              []string `json:"acr_values,omitempty"`
+	Amr              []string `json:"amr_values,omitempty"`
+	MaxAge           int64    `json:"max_age,omitempty"`
+}
+
+type EventGrant struct {
+	Identity   string `json:"identity_id"`
+	Scope      string `json:"scope"`
+	Publisher  string `json:"publisher_id"`
+	OnBehalfOf string `json:"on_behalf_of_id"`
+	NotBefore  int64  `json:"nbf"`
+	Expire     int64  `json:"exp"`
+	Revision   int64  `json:"grants_rev,omitempty"` // Revision of the grants of the identity after the change. Tokens claiming an older grants_rev are stale
+}
+
+type EventShadow struct {
+	Identity  string `json:"identity_id"`
+	Shadow    string `json:"shadow_id"`
+	NotBefore int64  `json:"nbf"`
+	Expire    int64  `json:"exp"`
+}
+
+type EventSubscription struct {
+	Subscriber string `json:"subscriber_id"`
+	Publisher  string `json:"publisher_id"`
+	Scope      string `json:"scope"`
+	Consents   int64  `json:"consents_deleted,omitempty"`
+	Required   bool   `json:"is_required,omitempty"`
+	Trusted    bool   `json:"is_trusted,omitempty"`
+	State      string `json:"state,omitempty"`
+}
+
+type EventConsent struct {
+	Identity   string   `json:"identity_id"`
+	Subscriber string   `json:"subscriber_id"`
+	Publisher  string   `json:"publisher_id,omitempty"`
+	Scope      string   `json:"scope,omitempty"`
+	Scopes     []string `json:"scopes,omitempty"`
+	Challenge  string   `json:"challenge,omitempty"`
+	NotBefore  int64    `json:"nbf,omitempty"`
+	Expire     int64    `json:"exp,omitempty"`
+	Automatic  bool     `json:"is_automatic,omitempty"` // Given by aap, because the subscriber is trusted
+}
+
+type EventRevocation struct {
+	Id        string   `json:"id"`
+	Jti       string   `json:"jti,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	ClientId  string   `json:"client_id,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	RevokedAt int64    `json:"revoked_at"`
+	Expire    int64    `json:"exp"`
 }
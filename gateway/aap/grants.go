@@ -48,6 +48,9 @@ func CreateGrant(tx neo4j.Transaction, iReceive Identity, iScope Scope, iPublish
     MERGE (receiver)-[:IS_GRANTED]->(grantRule)-[:GRANTS]->(publishRule)
     MERGE (grantRule)-[:ON_BEHALF_OF]->(obo)
 
+    // Tokens projecting the grants of the receiver are stale
+    SET receiver.grants_rev = coalesce(receiver.grants_rev, 0) + 1
+
     // Conclude
     return scope, publisher, receiver, obo, grantRule
   `
@@ -173,6 +176,9 @@ func DeleteGrant(tx neo4j.Transaction, iGrant Grant) (err error) {
     MATCH (receiver)-[:IS_GRANTED]->(grantRule:Grant:Rule)-[:GRANTS]->(publishRule)
     WHERE (grantRule)-[:ON_BEHALF_OF]->(obo)
 
+    // Tokens projecting the grants of the receiver are stale
+    SET receiver.grants_rev = coalesce(receiver.grants_rev, 0) + 1
+
     DETACH DELETE grantRule
   `
 
@@ -194,6 +200,69 @@ func DeleteGrant(tx neo4j.Transaction, iGrant Grant) (err error) {
 	return nil
 }
 
+// IsMayGranted checks that iGranter holds a valid grant on a publish rule that MAY_GRANT the publish rule of iScope by iPublisher on behalf of iOnBehalfOf.
+// For a normal scope this is the mg:<scope> grant, for mg:<scope> it is the 0:mg:<scope> grant.
+func IsMayGranted(tx neo4j.Transaction, iGranter Identity, iScope Scope, iPublisher Identity, iOnBehalfOf Identity) (mayGrant bool, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iGranter.Id == "" {
+		return false, errors.New("Missing iGranter.Id")
+	}
+	params["granterId"] = iGranter.Id
+
+	if iScope.Name == "" {
+		return false, errors.New("Missing iScope.Name")
+	}
+	params["scopeName"] = iScope.Name
+
+	if iPublisher.Id == "" {
+		return false, errors.New("Missing iPublisher.Id")
+	}
+	params["publisherId"] = iPublisher.Id
+
+	if iOnBehalfOf.Id == "" {
+		return false, errors.New("Missing iOnBehalfOf.Id")
+	}
+	params["onBehalfOfId"] = iOnBehalfOf.Id
+
+	cypher = `
+    // IsMayGranted
+
+    MATCH (publisher:Identity {id: $publisherId})-[:PUBLISH]->(publishRule:Publish:Rule)-[:PUBLISH]->(:Scope {name: $scopeName})
+    MATCH (publisher)-[:PUBLISH]->(mgPublishRule:Publish:Rule)-[:MAY_GRANT]->(publishRule)
+
+    MATCH (granter:Identity {id: $granterId})-[:IS_GRANTED]->(grantRule:Grant:Rule)-[:GRANTS]->(mgPublishRule)
+    MATCH (grantRule)-[:ON_BEHALF_OF]->(:Identity {id: $onBehalfOfId})
+    WHERE grantRule.nbf <= datetime().epochSeconds AND (grantRule.exp > datetime().epochSeconds OR grantRule.exp = 0)
+
+    // Conclude
+    return count(grantRule) > 0
+  `
+
+	logCypher(cypher, params)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return false, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+
+		if v := record.GetByIndex(0); v != nil {
+			mayGrant = v.(bool)
+		}
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return false, err
+	}
+
+	return mayGrant, nil
+}
+
 func FetchGrants(tx neo4j.Transaction, iGranted Identity, iFilterScopes []Scope, iFilterPublishers []Identity, iFilterOnBehalfOf []Identity) (grants []Grant, err error) {
 	var result neo4j.Result
 	var cypher string
@@ -297,3 +366,43 @@ func FetchGrants(tx neo4j.Transaction, iGranted Identity, iFilterScopes []Scope,
 
 	return grants, nil
 }
+
+// FetchGrantsRevision returns the revision of the grants given to the identity. It is increased every time a grant is created or deleted. 0 = never granted.
+func FetchGrantsRevision(tx neo4j.Transaction, iGranted Identity) (rev int64, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iGranted.Id == "" {
+		return 0, errors.New("Missing iGranted.Id")
+	}
+	params["id"] = iGranted.Id
+
+	cypher = fmt.Sprintf(`
+    // FetchGrantsRevision
+
+    MATCH (identity:Identity {id:$id})
+    RETURN coalesce(identity.grants_rev, 0)
+  `)
+
+	logCypher(cypher, params)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return 0, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		revValue := record.GetByIndex(0)
+		if revValue != nil {
+			rev = revValue.(int64)
+		}
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return 0, err
+	}
+
+	return rev, nil
+}
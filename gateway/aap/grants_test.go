@@ -0,0 +1,57 @@
+package aap
+
+import (
+	"testing"
+)
+
+func TestIsMayGranted(t *testing.T) {
+	granter := Identity{Id: "granter-id"}
+	publisher := Identity{Id: "publisher-id"}
+	owner := Identity{Id: "owner-id"}
+
+	tests := []struct {
+		name         string
+		granter      Identity
+		scope        Scope
+		publisher    Identity
+		onBehalfOf   Identity
+		rows         [][]interface{}
+		wantMayGrant bool
+		wantErr      bool
+	}{
+		{name: "holds may grant", granter: granter, scope: Scope{Name: "read"}, publisher: publisher, onBehalfOf: owner, rows: [][]interface{}{{true}}, wantMayGrant: true},
+		{name: "holds no may grant", granter: granter, scope: Scope{Name: "read"}, publisher: publisher, onBehalfOf: owner, rows: [][]interface{}{{false}}},
+		{name: "scope not published", granter: granter, scope: Scope{Name: "read"}, publisher: publisher, onBehalfOf: owner},
+		{name: "delegating may grant", granter: granter, scope: Scope{Name: "mg:read"}, publisher: publisher, onBehalfOf: owner, rows: [][]interface{}{{true}}, wantMayGrant: true},
+		{name: "missing granter", scope: Scope{Name: "read"}, publisher: publisher, onBehalfOf: owner, wantErr: true},
+		{name: "missing scope", granter: granter, publisher: publisher, onBehalfOf: owner, wantErr: true},
+		{name: "missing publisher", granter: granter, scope: Scope{Name: "read"}, onBehalfOf: owner, wantErr: true},
+		{name: "missing on behalf of", granter: granter, scope: Scope{Name: "read"}, publisher: publisher, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := newFakeTx(map[string][][]interface{}{"IsMayGranted": tt.rows})
+
+			mayGrant, err := IsMayGranted(tx, tt.granter, tt.scope, tt.publisher, tt.onBehalfOf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if len(tx.runs) > 0 {
+					t.Error("statement run on invalid input")
+				}
+				return
+			}
+
+			if mayGrant != tt.wantMayGrant {
+				t.Errorf("mayGrant = %v, want %v", mayGrant, tt.wantMayGrant)
+			}
+
+			params := tx.run(t, "IsMayGranted").params
+			if params["scopeName"] != tt.scope.Name || params["onBehalfOfId"] != tt.onBehalfOf.Id {
+				t.Errorf("params = %v", params)
+			}
+		})
+	}
+}
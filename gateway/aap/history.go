@@ -0,0 +1,131 @@
+package aap
+
+import (
+	"errors"
+	"fmt"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// cypCreateConsentHistory records the consent rule cr as it is right now.
+// Requires owner, subscriber, publisher, scope, pr and cr to be bound and must run before cr is deleted.
+// History is never updated or deleted and does not relate to other nodes, so it outlives the consent, the client and the publishing.
+func cypCreateConsentHistory(action string) string {
+	return fmt.Sprintf(`CREATE (:ConsentHistory {
+      id:randomUUID(), action:"%s", at:datetime().epochSeconds,
+      identity_id:owner.id,
+      subscriber_id:subscriber.id, subscriber_name:coalesce(subscriber.name, ""),
+      publisher_id:publisher.id, publisher_name:coalesce(publisher.name, ""),
+      scope:scope.name, title:coalesce(pr.title, ""), description:coalesce(pr.description, ""),
+      nbf:coalesce(cr.nbf, 0), exp:coalesce(cr.exp, 0), automatic:coalesce(cr.automatic, false),
+      challenge:""
+    })`, action)
+}
+
+// CreateConsentHistory records changes not stored as consent rules, like a rejected consent challenge.
+func CreateConsentHistory(tx neo4j.Transaction, iHistory ConsentHistory) (err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iHistory.Action == "" {
+		return errors.New("Missing iHistory.Action")
+	}
+	params["action"] = iHistory.Action
+
+	if iHistory.Identity.Id == "" {
+		return errors.New("Missing iHistory.Identity.Id")
+	}
+	params["identity_id"] = iHistory.Identity.Id
+
+	params["subscriber_id"] = iHistory.Subscriber.Id
+	params["subscriber_name"] = iHistory.SubscriberName
+	params["publisher_id"] = iHistory.Publisher.Id
+	params["publisher_name"] = iHistory.PublisherName
+	params["scope"] = iHistory.Scope.Name
+	params["title"] = iHistory.Title
+	params["description"] = iHistory.Description
+	params["nbf"] = iHistory.ConsentRule.NotBefore
+	params["exp"] = iHistory.ConsentRule.Expire
+	params["automatic"] = iHistory.ConsentRule.Automatic
+	params["challenge"] = iHistory.Challenge
+
+	cypher = fmt.Sprintf(`
+    // CreateConsentHistory
+
+    CREATE (:ConsentHistory {
+      id:randomUUID(), action:$action, at:datetime().epochSeconds,
+      identity_id:$identity_id,
+      subscriber_id:$subscriber_id, subscriber_name:$subscriber_name,
+      publisher_id:$publisher_id, publisher_name:$publisher_name,
+      scope:$scope, title:$title, description:$description,
+      nbf:$nbf, exp:$exp, automatic:$automatic,
+      challenge:$challenge
+    })
+  `)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return err
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// FetchConsentHistory returns the consent history of the owner, oldest first.
+func FetchConsentHistory(tx neo4j.Transaction, iOwner Identity, iFilterSubscriber Identity, iFilterPublisher Identity) (rHistory []ConsentHistory, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iOwner.Id == "" {
+		return nil, errors.New("Missing iOwner.Id")
+	}
+	params["identity_id"] = iOwner.Id
+
+	var cypFilterSubscriber string
+	if iFilterSubscriber.Id != "" {
+		cypFilterSubscriber = `and h.subscriber_id = $subscriber_id`
+		params["subscriber_id"] = iFilterSubscriber.Id
+	}
+
+	var cypFilterPublisher string
+	if iFilterPublisher.Id != "" {
+		cypFilterPublisher = `and h.publisher_id = $publisher_id`
+		params["publisher_id"] = iFilterPublisher.Id
+	}
+
+	cypher = fmt.Sprintf(`
+    // FetchConsentHistory
+
+    MATCH (h:ConsentHistory {identity_id:$identity_id})
+    WHERE 1=1 %s %s
+    RETURN h
+    ORDER BY h.at
+  `, cypFilterSubscriber, cypFilterPublisher)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		historyNode := record.GetByIndex(0)
+
+		if historyNode != nil {
+			rHistory = append(rHistory, marshalNodeToConsentHistory(historyNode.(neo4j.Node)))
+		}
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return rHistory, nil
+}
@@ -1,10 +1,17 @@
 package aap
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	hydra "github.com/charmixer/hydra/client"
 	"github.com/neo4j/neo4j-go-driver/neo4j"
 	"github.com/opensentry/aap/config"
 	"github.com/opensentry/aap/utils"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
 	"strings"
 )
 
@@ -24,6 +31,11 @@ func SyncScopesToHydra(tx neo4j.Transaction, iClient Identity) (err error) {
 			panic("Expected subscriptions for '" + iClient.Id + "' only, but found subscription for '" + s.Subscriber.Id + "' in response")
 		}
 
+		// Pending and rejected subscriptions must not be requestable by the client
+		if s.SubscribeRule.State != SUBSCRIPTION_APPROVED {
+			continue
+		}
+
 		scopes = append(scopes, s.Scope.Name)
 
 		if !utils.StringInSlice(s.Publisher.Id, audiences) {
@@ -47,3 +59,190 @@ func SyncScopesToHydra(tx neo4j.Transaction, iClient Identity) (err error) {
 
 	return nil
 }
+
+// RevokeHydraConsentSessions forgets the consent sessions remembered by hydra for the owner and subscriber. Hydra also invalidates the access and refresh tokens issued with them.
+func RevokeHydraConsentSessions(hydraClient *hydra.HydraClient, iOwner Identity, iSubscriber Identity) (err error) {
+	if iOwner.Id == "" {
+		return errors.New("Missing iOwner.Id")
+	}
+
+	if iSubscriber.Id == "" {
+		return errors.New("Missing iSubscriber.Id")
+	}
+
+	url := config.GetString("hydra.private.url") + config.GetString("hydra.private.endpoints.consentSessions")
+
+	request, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+
+	query := request.URL.Query()
+	query.Add("subject", iOwner.Id)
+	query.Add("client", iSubscriber.Id)
+	request.URL.RawQuery = query.Encode()
+
+	response, err := hydraClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	// No sessions remembered is not an error
+	if response.StatusCode == http.StatusNoContent || response.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	body, _ := ioutil.ReadAll(response.Body)
+	return fmt.Errorf("Revoking hydra consent sessions failed with status %d: %s", response.StatusCode, string(body))
+}
+
+// HydraConsentResponse is the hydra consent request including the OpenID Connect context, which the hydra client does not expose.
+type HydraConsentResponse struct {
+	hydra.ConsentResponse
+	OidcContext HydraOidcContext `json:"oidc_context"`
+	Acr         string           `json:"acr"` // Authentication context class the subject authenticated with, as accepted by the login provider
+}
+
+type HydraOidcContext struct {
+	UiLocales []string `json:"ui_locales"`
+	AcrValues []string `json:"acr_values"`
+	LoginHint string   `json:"login_hint"`
+}
+
+func GetHydraConsent(hydraClient *hydra.HydraClient, challenge string) (consent HydraConsentResponse, err error) {
+	if challenge == "" {
+		return HydraConsentResponse{}, errors.New("Missing challenge")
+	}
+
+	url := config.GetString("hydra.private.url") + config.GetString("hydra.private.endpoints.consent")
+
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return HydraConsentResponse{}, err
+	}
+
+	query := request.URL.Query()
+	query.Add("consent_challenge", challenge)
+	request.URL.RawQuery = query.Encode()
+
+	response, err := hydraClient.Do(request)
+	if err != nil {
+		return HydraConsentResponse{}, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return HydraConsentResponse{}, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return HydraConsentResponse{}, fmt.Errorf("Fetching hydra consent request failed with status %d: %s", response.StatusCode, string(body))
+	}
+
+	err = json.Unmarshal(body, &consent)
+	if err != nil {
+		return HydraConsentResponse{}, err
+	}
+
+	return consent, nil
+}
+
+// HydraConsentAcceptRequest accepts a consent request with claims in the session. The hydra client only allows the session claims to be a string.
+type HydraConsentAcceptRequest struct {
+	hydra.ConsentAcceptRequest
+	Session HydraConsentAcceptSession `json:"session"`
+}
+
+// Claims added to the tokens issued. Access token claims are returned by hydra in ext of the token introspection.
+type HydraConsentAcceptSession struct {
+	AccessToken map[string]interface{} `json:"access_token,omitempty"`
+	IdToken     map[string]interface{} `json:"id_token,omitempty"`
+}
+
+func AcceptHydraConsent(hydraClient *hydra.HydraClient, challenge string, acceptRequest HydraConsentAcceptRequest) (acceptResponse hydra.ConsentAcceptResponse, err error) {
+	if challenge == "" {
+		return hydra.ConsentAcceptResponse{}, errors.New("Missing challenge")
+	}
+
+	url := config.GetString("hydra.private.url") + config.GetString("hydra.private.endpoints.consentAccept")
+
+	body, err := json.Marshal(acceptRequest)
+	if err != nil {
+		return hydra.ConsentAcceptResponse{}, err
+	}
+
+	request, err := http.NewRequest("PUT", url, bytes.NewBuffer(body))
+	if err != nil {
+		return hydra.ConsentAcceptResponse{}, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	query := request.URL.Query()
+	query.Add("consent_challenge", challenge)
+	request.URL.RawQuery = query.Encode()
+
+	response, err := hydraClient.Do(request)
+	if err != nil {
+		return hydra.ConsentAcceptResponse{}, err
+	}
+	defer response.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return hydra.ConsentAcceptResponse{}, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return hydra.ConsentAcceptResponse{}, fmt.Errorf("Accepting hydra consent request failed with status %d: %s", response.StatusCode, string(responseBody))
+	}
+
+	err = json.Unmarshal(responseBody, &acceptResponse)
+	if err != nil {
+		return hydra.ConsentAcceptResponse{}, err
+	}
+
+	return acceptResponse, nil
+}
+
+// HydraIntrospectResponse is the hydra token introspection including the session claims of the access token, which the hydra client does not expose.
+type HydraIntrospectResponse struct {
+	hydra.IntrospectResponse
+	Ext HydraIntrospectExt `json:"ext"`
+}
+
+// Access token session claims set by aap when accepting consent
+type HydraIntrospectExt struct {
+	Acr      string   `json:"acr,omitempty"`
+	Amr      []string `json:"amr,omitempty"`
+	AuthTime int64    `json:"auth_time,omitempty"`
+}
+
+func IntrospectHydraToken(introspectUrl string, introspectRequest hydra.IntrospectRequest) (introspectResponse HydraIntrospectResponse, err error) {
+	values := neturl.Values{}
+	values.Add("token", introspectRequest.Token)
+	values.Add("scope", introspectRequest.Scope)
+
+	response, err := http.Post(introspectUrl, "application/x-www-form-urlencoded", strings.NewReader(values.Encode()))
+	if err != nil {
+		return HydraIntrospectResponse{}, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return HydraIntrospectResponse{}, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return HydraIntrospectResponse{}, fmt.Errorf("Introspecting token failed with status %d: %s", response.StatusCode, string(body))
+	}
+
+	err = json.Unmarshal(body, &introspectResponse)
+	if err != nil {
+		return HydraIntrospectResponse{}, err
+	}
+
+	return introspectResponse, nil
+}
@@ -0,0 +1,85 @@
+package aap
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	hydra "github.com/charmixer/hydra/client"
+	"github.com/spf13/viper"
+)
+
+func TestRevokeHydraConsentSessions(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		wantErr    bool
+	}{
+		{name: "revoked", statusCode: http.StatusNoContent},
+		{name: "no sessions remembered", statusCode: http.StatusNotFound},
+		{name: "hydra failed", statusCode: http.StatusInternalServerError, wantErr: true},
+		{name: "unexpected success", statusCode: http.StatusOK, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var called bool
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+
+				if r.Method != "DELETE" {
+					t.Errorf("method = %s, want DELETE", r.Method)
+				}
+
+				if r.URL.Path != "/oauth2/auth/sessions/consent" {
+					t.Errorf("path = %s, want /oauth2/auth/sessions/consent", r.URL.Path)
+				}
+
+				query := r.URL.Query()
+				if subject := query.Get("subject"); subject != "owner-id" {
+					t.Errorf("subject = %s, want owner-id", subject)
+				}
+				if client := query.Get("client"); client != "subscriber-id" {
+					t.Errorf("client = %s, want subscriber-id", client)
+				}
+
+				w.WriteHeader(tt.statusCode)
+			}))
+			defer server.Close()
+
+			viper.Set("hydra.private.url", server.URL)
+			viper.Set("hydra.private.endpoints.consentSessions", "/oauth2/auth/sessions/consent")
+
+			err := RevokeHydraConsentSessions(&hydra.HydraClient{Client: server.Client()}, Identity{Id: "owner-id"}, Identity{Id: "subscriber-id"})
+
+			if !called {
+				t.Fatal("hydra was not called")
+			}
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRevokeHydraConsentSessionsMissingIdentities(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("hydra must not be called without owner and subscriber")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	viper.Set("hydra.private.url", server.URL)
+
+	hydraClient := &hydra.HydraClient{Client: server.Client()}
+
+	if err := RevokeHydraConsentSessions(hydraClient, Identity{}, Identity{Id: "subscriber-id"}); err == nil {
+		t.Error("expected error on missing owner")
+	}
+
+	if err := RevokeHydraConsentSessions(hydraClient, Identity{Id: "owner-id"}, Identity{}); err == nil {
+		t.Error("expected error on missing subscriber")
+	}
+}
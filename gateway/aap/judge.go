@@ -5,25 +5,344 @@ import (
 	"fmt"
 	"github.com/neo4j/neo4j-go-driver/neo4j"
 	"strings"
+
+	"github.com/opensentry/aap/config"
 )
 
 func Judge(tx neo4j.Transaction, iPublisher Identity, iRequestor Identity, iScopes []Scope, iFilterOwners []Identity) (verdict Verdict, err error) {
+	verdicts, err := JudgeMany(tx, []JudgeQuery{
+		{Publisher: iPublisher, Requestor: iRequestor, Scopes: iScopes, Owners: iFilterOwners},
+	})
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	return verdicts[0], nil
+}
+
+// JudgeMany judges all queries in one round trip. Verdicts are returned in the order of the queries.
+func JudgeMany(tx neo4j.Transaction, iQueries []JudgeQuery) (rVerdicts []Verdict, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if len(iQueries) <= 0 {
+		return nil, errors.New("Missing iQueries")
+	}
+
+	var queries []interface{}
+	for index, q := range iQueries {
+		if q.Publisher.Id == "" {
+			return nil, errors.New("Missing iPublisher.Id")
+		}
+
+		if q.Requestor.Id == "" {
+			return nil, errors.New("Missing iRequestor.Id")
+		}
+
+		if len(q.Scopes) <= 0 {
+			return nil, errors.New("Missing iScopes")
+		}
+
+		_s := []string{}
+		for _, iScope := range q.Scopes {
+			_s = append(_s, iScope.Name)
+		}
+
+		// NOTE: Let cypher do the distinction of the owners instead of go.
+
+		// Always look for publisher owner grant
+		owners := append([]Identity{}, q.Owners...)
+		owners = append(owners, q.Publisher)
+
+		var filterOwners []string
+		for _, o := range owners {
+			filterOwners = append(filterOwners, o.Id)
+		}
+
+		queries = append(queries, map[string]interface{}{
+			"index":     int64(index),
+			"publisher": q.Publisher.Id,
+			"requestor": q.Requestor.Id,
+			"scopes":    _s,
+			"owners":    filterOwners,
+		})
+
+		// Deny by default
+		rVerdicts = append(rVerdicts, Verdict{
+			Publisher:       q.Publisher,
+			Requestor:       q.Requestor,
+			RequestedScopes: q.Scopes,
+			GrantedScopes:   []Scope{},
+			MissingScopes:   q.Scopes,
+			Owners:          owners,
+			Granted:         false,
+		})
+	}
+	params["queries"] = queries
+
+	// A requestor inherits the grants of every identity it shadows. Follow the shadow grant rules up to the configured depth.
+	maxShadowDepth := config.GetInt("judge.shadows.depth")
+	if maxShadowDepth < 0 {
+		maxShadowDepth = 0
+	}
+
+	cypher = fmt.Sprintf(`
+    // JudgeMany
+
+    UNWIND $queries as q
+
+    MATCH (publisher:Identity {id:q.publisher})
+    MATCH (requestor:Identity {id:q.requestor})
+
+    // Collect all publishings for scopes by publisher
+    MATCH (scope:Scope) WHERE scope.name in q.scopes
+    MATCH (publisher)-[:PUBLISH]->(publishing:Publish:Rule)-[:PUBLISH]->(scope)
+
+    // Collect the requestor and all identities shadowed by the requestor. Every shadow grant rule on the path must be valid and no identity may be visited twice.
+    MATCH path = (requestor)-[:IS_GRANTED|GRANTS*0..%d]->(shadow:Identity)
+    WHERE ALL(n in nodes(path) WHERE NOT n:Grant:Rule OR (n.nbf <= datetime().epochSeconds AND (n.exp > datetime().epochSeconds OR n.exp = 0)))
+    AND ALL(n in nodes(path) WHERE single(m in nodes(path) WHERE m = n))
+
+    // Collet all granted owners for requested publishings
+    MATCH (shadow)-[:IS_GRANTED]->(grant:Grant:Rule)-[:GRANTS]->(publishing), (grant)-[:ON_BEHALF_OF]->(owner:Identity)
+    WHERE grant.nbf <= datetime().epochSeconds AND (grant.exp > datetime().epochSeconds OR grant.exp = 0) and owner.id in q.owners
+
+    // Conclude. Direct grants first, then grants inherited from the nearest shadow. The publishing carries the step-up authentication required for the scope.
+    RETURN q.index as index, publisher, requestor, scope, owner, shadow, length(path)/2 as depth, publishing
+    ORDER BY index, depth
+  `, maxShadowDepth*2)
+
+	logCypher(cypher, params)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	grantedScopes := make([][]Scope, len(iQueries))
+	owners := make([][]Identity, len(iQueries))
+	shadows := make([][]VerdictShadow, len(iQueries))
+	stepUps := make([][]VerdictStepUp, len(iQueries))
+	seenScopes := make([]map[string]bool, len(iQueries))
+	seenOwners := make([]map[string]bool, len(iQueries))
+	for index := range iQueries {
+		seenScopes[index] = make(map[string]bool)
+		seenOwners[index] = make(map[string]bool)
+	}
+
+	for result.Next() {
+		record := result.Record()
+		indexValue := record.GetByIndex(0)
+		publisherNode := record.GetByIndex(1)
+		requestorNode := record.GetByIndex(2)
+		scopeNode := record.GetByIndex(3)
+		ownerNode := record.GetByIndex(4)
+		shadowNode := record.GetByIndex(5)
+		depth := record.GetByIndex(6)
+		publishingNode := record.GetByIndex(7)
+
+		if indexValue == nil || publisherNode == nil || requestorNode == nil || scopeNode == nil || ownerNode == nil || shadowNode == nil || publishingNode == nil {
+			continue
+		}
+
+		index := int(indexValue.(int64))
+		if index < 0 || index >= len(iQueries) {
+			continue
+		}
+
+		rVerdicts[index].Publisher = marshalNodeToIdentity(publisherNode.(neo4j.Node))
+		rVerdicts[index].Requestor = marshalNodeToIdentity(requestorNode.(neo4j.Node))
+
+		scope := marshalNodeToScope(scopeNode.(neo4j.Node))
+		owner := marshalNodeToIdentity(ownerNode.(neo4j.Node))
+		shadow := marshalNodeToIdentity(shadowNode.(neo4j.Node))
+
+		if !seenOwners[index][owner.Id] {
+			seenOwners[index][owner.Id] = true
+			owners[index] = append(owners[index], owner)
+		}
+
+		// Rows are ordered by depth, so the first row for a scope is the nearest grant
+		if seenScopes[index][scope.Name] {
+			continue
+		}
+		seenScopes[index][scope.Name] = true
+		grantedScopes[index] = append(grantedScopes[index], scope)
+
+		// Whether the token satisfies the step-up is judged by the caller, which knows the token.
+		pr := marshalNodeToPublishRule(publishingNode.(neo4j.Node))
+		if pr.IsStepUpRequired() {
+			stepUps[index] = append(stepUps[index], VerdictStepUp{Scope: scope, Acr: pr.Acr, Amr: pr.Amr, MaxAge: pr.MaxAge})
+		}
+
+		if shadow.Id != rVerdicts[index].Requestor.Id {
+			var d int64
+			if depth != nil {
+				d = depth.(int64)
+			}
+			shadows[index] = append(shadows[index], VerdictShadow{Scope: scope, Shadow: shadow, Depth: d})
+		}
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	for index, q := range iQueries {
+		if len(grantedScopes[index]) > 0 {
+			missingScopes := difference(q.Scopes, grantedScopes[index])
+
+			rVerdicts[index].GrantedScopes = grantedScopes[index]
+			rVerdicts[index].MissingScopes = missingScopes
+			rVerdicts[index].Owners = owners[index]
+			rVerdicts[index].Shadows = shadows[index]
+			rVerdicts[index].Granted = len(missingScopes) == 0
+
+			if rVerdicts[index].Granted {
+				rVerdicts[index].StepUps = stepUps[index]
+			}
+		}
+	}
+
+	if config.GetInt("judge.subscriptions.enforce") == 1 {
+		err = judgeSubscriptions(tx, iQueries, rVerdicts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rVerdicts, nil
+}
+
+// judgeSubscriptions denies verdicts where the client is not subscribed to a requested scope at the publisher, or the requestor has not consented to it.
+// Queries without a client are not judged. Tokens where the requestor is the client itself (client credentials) need no consent.
+func judgeSubscriptions(tx neo4j.Transaction, iQueries []JudgeQuery, rVerdicts []Verdict) (err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	var queries []interface{}
+	for index, q := range iQueries {
+		if q.Client.Id == "" {
+			continue
+		}
+
+		_s := []string{}
+		for _, iScope := range q.Scopes {
+			_s = append(_s, iScope.Name)
+		}
+
+		queries = append(queries, map[string]interface{}{
+			"index":     int64(index),
+			"publisher": q.Publisher.Id,
+			"requestor": q.Requestor.Id,
+			"client":    q.Client.Id,
+			"scopes":    _s,
+		})
+
+		rVerdicts[index].MissingSubscriptions = []Scope{}
+		rVerdicts[index].MissingConsents = []Scope{}
+	}
+
+	if len(queries) <= 0 {
+		return nil
+	}
+	params["queries"] = queries
+
+	cypher = fmt.Sprintf(`
+    // judgeSubscriptions
+
+    UNWIND $queries as q
+    UNWIND q.scopes as scopeName
+
+    OPTIONAL MATCH (publisher:Identity {id:q.publisher})-[:PUBLISH]->(pr:Publish:Rule)-[:PUBLISH]->(:Scope {name:scopeName})
+
+    // Subscriptions from before approval was introduced are approved
+    OPTIONAL MATCH (client:Identity {id:q.client})-[:SUBSCRIBES]->(sr:Subscribe:Rule)-[:SUBSCRIBES]->(pr)
+    WHERE coalesce(sr.state, "approved") = "approved"
+
+    OPTIONAL MATCH (requestor:Identity {id:q.requestor})-[:CONSENT]->(cr:Consent:Rule)-[:CONSENT]->(pr)
+    WHERE (cr)-[:CONSENT]->(:Identity {id:q.client})
+    AND coalesce(cr.nbf, 0) <= datetime().epochSeconds AND (coalesce(cr.exp, 0) = 0 OR cr.exp > datetime().epochSeconds)
+
+    WITH q.index as index, scopeName, q.requestor = q.client as isSelf, count(DISTINCT sr) as subscriptions, count(DISTINCT cr) as consents, min(CASE WHEN coalesce(cr.exp, 0) > 0 THEN cr.exp END) as consentsExpire
+
+    RETURN index, scopeName, subscriptions > 0 as subscribed, (isSelf OR consents > 0) as consented, consentsExpire
+  `)
+
+	logCypher(cypher, params)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		indexValue := record.GetByIndex(0)
+		scopeName := record.GetByIndex(1)
+		subscribed := record.GetByIndex(2)
+		consented := record.GetByIndex(3)
+		consentsExpire := record.GetByIndex(4)
+
+		if indexValue == nil || scopeName == nil {
+			continue
+		}
+
+		index := int(indexValue.(int64))
+		if index < 0 || index >= len(rVerdicts) {
+			continue
+		}
+
+		scope := Scope{Name: scopeName.(string)}
+
+		if subscribed == nil || !subscribed.(bool) {
+			rVerdicts[index].MissingSubscriptions = append(rVerdicts[index].MissingSubscriptions, scope)
+			rVerdicts[index].Granted = false
+			continue
+		}
+
+		if consented == nil || !consented.(bool) {
+			rVerdicts[index].MissingConsents = append(rVerdicts[index].MissingConsents, scope)
+			rVerdicts[index].Granted = false
+			continue
+		}
+
+		if consentsExpire != nil {
+			exp := consentsExpire.(int64)
+			if rVerdicts[index].ConsentsExpire == 0 || exp < rVerdicts[index].ConsentsExpire {
+				rVerdicts[index].ConsentsExpire = exp
+			}
+		}
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// TraceJudge collects every grant that could satisfy the requested scopes, including rules that are not valid right now.
+// Judge must stay the source of truth, this is only used to explain its verdicts.
+func TraceJudge(tx neo4j.Transaction, iPublisher Identity, iRequestor Identity, iScopes []Scope) (rTraces []VerdictTrace, err error) {
 	var result neo4j.Result
 	var cypher string
 	var params = make(map[string]interface{})
 
 	if iPublisher.Id == "" {
-		return Verdict{}, errors.New("Missing iPublisher.Id")
+		return nil, errors.New("Missing iPublisher.Id")
 	}
 	params["publisher"] = iPublisher.Id
 
 	if iRequestor.Id == "" {
-		return Verdict{}, errors.New("Missing iRequestor.Id")
+		return nil, errors.New("Missing iRequestor.Id")
 	}
 	params["requestor"] = iRequestor.Id
 
 	if len(iScopes) <= 0 {
-		return Verdict{}, errors.New("Missing iScopes")
+		return nil, errors.New("Missing iScopes")
 	}
 
 	_s := []string{}
@@ -32,103 +351,99 @@ func Judge(tx neo4j.Transaction, iPublisher Identity, iRequestor Identity, iScop
 	}
 	params["scope"] = strings.Join(_s, " ")
 
-	// NOTE: Let cypher do the distinction of the owners instead of go.
-
-	// Always look for publisher owner grant
-	iFilterOwners = append(iFilterOwners, iPublisher)
-
-	cypFilterOwners := ""
-	if len(iFilterOwners) > 0 {
-		var filterOwners []string
-		for _, o := range iFilterOwners {
-			filterOwners = append(filterOwners, o.Id)
-		}
-		cypFilterOwners = ` and owner.id in split($filterOwners, " ")`
-		params["filterOwners"] = strings.Join(filterOwners, " ")
+	maxShadowDepth := config.GetInt("judge.shadows.depth")
+	if maxShadowDepth < 0 {
+		maxShadowDepth = 0
 	}
 
 	cypher = fmt.Sprintf(`
-    // Judge
+    // TraceJudge
 
     MATCH (publisher:Identity {id:$publisher})
     MATCH (requestor:Identity {id:$requestor})
 
-    // Collect all publishings for scopes by publisher
-    MATCH (scope:Scope) WHERE scope.name in split($scope, " ")
-    MATCH (publisher)-[:PUBLISH]->(publishing:Publish:Rule)-[:PUBLISH]->(scope)
+    UNWIND split($scope, " ") as scopeName
 
-    // Collet all granted owners for requested publishings
-    MATCH (requestor)-[:IS_GRANTED]->(grant:Grant:Rule)-[:GRANTS]->(publishing), (grant)-[:ON_BEHALF_OF]->(owner:Identity)
-    WHERE grant.nbf <= datetime().epochSeconds AND (grant.exp > datetime().epochSeconds OR grant.exp = 0) %s
+    OPTIONAL MATCH (publisher)-[:PUBLISH]->(publishing:Publish:Rule)-[:PUBLISH]->(scope:Scope {name:scopeName})
+
+    // Same paths as Judge, but without filtering on nbf and exp
+    OPTIONAL MATCH path = (requestor)-[:IS_GRANTED|GRANTS*0..%d]->(shadow:Identity)-[:IS_GRANTED]->(grant:Grant:Rule)-[:GRANTS]->(publishing)
+    WHERE ALL(n in nodes(path) WHERE single(m in nodes(path) WHERE m = n))
 
-    // Conclude. This only returns anything if everything match!
-    RETURN publisher, requestor, collect(scope) as scope, collect(owner) as owner
-  `, cypFilterOwners)
+    OPTIONAL MATCH (grant)-[:ON_BEHALF_OF]->(owner:Identity)
+
+    WITH scopeName, scope, publishing, grant, owner, path, datetime().epochSeconds as now,
+         [n in nodes(path) WHERE n:Grant:Rule AND n <> grant] as shadowRules
+
+    RETURN scopeName, scope, publishing IS NOT NULL as published, grant, owner,
+           [n in nodes(path) WHERE n:Identity] as identities,
+           ALL(n in shadowRules WHERE n.nbf <= now AND (n.exp > now OR n.exp = 0)) as shadowsValid,
+           now
+    ORDER BY scopeName, length(path)
+  `, maxShadowDepth*2)
 
 	logCypher(cypher, params)
 
 	if result, err = tx.Run(cypher, params); err != nil {
-		return verdict, err
+		return nil, err
 	}
 
-	// Deny by default
-	verdict = Verdict{
-		Publisher:       iPublisher,
-		Requestor:       iRequestor,
-		RequestedScopes: iScopes,
-		GrantedScopes:   []Scope{},
-		MissingScopes:   iScopes,
-		Owners:          iFilterOwners,
-		Granted:         false,
-	}
-
-	if result.Next() {
+	for result.Next() {
 		record := result.Record()
-		publisherNode := record.GetByIndex(0)
-		requestorNode := record.GetByIndex(1)
-		scopeNodes := record.GetByIndex(2)
-		ownerNodes := record.GetByIndex(3)
-
-		if publisherNode != nil && requestorNode != nil && scopeNodes != nil && ownerNodes != nil {
-			p := marshalNodeToIdentity(publisherNode.(neo4j.Node))
-			r := marshalNodeToIdentity(requestorNode.(neo4j.Node))
-
-			var grantedScopes []Scope
-			if scopeNodes != nil {
-				for _, n := range scopeNodes.([]interface{}) {
-					grantedScopes = append(grantedScopes, marshalNodeToScope(n.(neo4j.Node)))
-				}
-			}
+		scopeName := record.GetByIndex(0)
+		scopeNode := record.GetByIndex(1)
+		published := record.GetByIndex(2)
+		grantNode := record.GetByIndex(3)
+		ownerNode := record.GetByIndex(4)
+		identityNodes := record.GetByIndex(5)
+		shadowsValid := record.GetByIndex(6)
+		now := record.GetByIndex(7)
 
-			var owners []Identity
-			if ownerNodes != nil {
-				for _, n := range ownerNodes.([]interface{}) {
-					owners = append(owners, marshalNodeToIdentity(n.(neo4j.Node)))
-				}
-			}
+		var trace VerdictTrace
 
-			missingScopes := difference(grantedScopes, iScopes)
+		if scopeName != nil {
+			trace.Scope = Scope{Name: scopeName.(string)}
+		}
 
-			granted := len(missingScopes) == 0
+		if scopeNode != nil {
+			trace.Scope = marshalNodeToScope(scopeNode.(neo4j.Node))
+		}
+
+		if published != nil {
+			trace.Published = published.(bool)
+		}
 
-			verdict = Verdict{
-				Publisher:       p,
-				Requestor:       r,
-				RequestedScopes: iScopes,
-				GrantedScopes:   grantedScopes,
-				MissingScopes:   missingScopes,
-				Owners:          owners,
-				Granted:         granted,
+		if grantNode != nil {
+			trace.GrantRule = marshalNodeToGrantRule(grantNode.(neo4j.Node))
+		}
+
+		if ownerNode != nil {
+			trace.Owner = marshalNodeToIdentity(ownerNode.(neo4j.Node))
+		}
+
+		if identityNodes != nil {
+			for _, n := range identityNodes.([]interface{}) {
+				trace.Path = append(trace.Path, marshalNodeToIdentity(n.(neo4j.Node)))
 			}
 		}
+
+		if shadowsValid != nil {
+			trace.ShadowsValid = shadowsValid.(bool)
+		}
+
+		if now != nil {
+			trace.Now = now.(int64)
+		}
+
+		rTraces = append(rTraces, trace)
 	}
 
 	// Check if we encountered any error during record streaming
 	if err = result.Err(); err != nil {
-		return verdict, err
+		return nil, err
 	}
 
-	return verdict, nil
+	return rTraces, nil
 }
 
 // Set Difference: A - B
@@ -0,0 +1,414 @@
+package aap
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// judgeRow is a record of the JudgeMany statement
+func judgeRow(index int64, scope string, owner string, shadow string, depth int64) []interface{} {
+	return []interface{}{index, fakeNode{"id": "publisher-id"}, fakeNode{"id": "requestor-id"}, fakeNode{"name": scope}, fakeNode{"id": owner}, fakeNode{"id": shadow}, depth, fakeNode{}}
+}
+
+func scopeNames(scopes []Scope) (names []string) {
+	for _, s := range scopes {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func TestDifference(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []Scope
+		b    []Scope
+		want []string
+	}{
+		{name: "nothing granted", a: []Scope{{Name: "read"}, {Name: "write"}}, want: []string{"read", "write"}},
+		{name: "partly granted", a: []Scope{{Name: "read"}, {Name: "write"}}, b: []Scope{{Name: "read"}}, want: []string{"write"}},
+		{name: "all granted", a: []Scope{{Name: "read"}}, b: []Scope{{Name: "read"}}},
+		{name: "granted more than requested", a: []Scope{{Name: "read"}}, b: []Scope{{Name: "read"}, {Name: "write"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scopeNames(difference(tt.a, tt.b))
+			if !equalParam(got, tt.want) {
+				t.Errorf("difference = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJudgeManyShadows(t *testing.T) {
+	query := JudgeQuery{
+		Publisher: Identity{Id: "publisher-id"},
+		Requestor: Identity{Id: "requestor-id"},
+		Scopes:    []Scope{{Name: "read"}, {Name: "write"}},
+	}
+
+	tests := []struct {
+		name        string
+		rows        [][]interface{}
+		wantGranted bool
+		wantMissing []string
+		wantShadows []VerdictShadow
+	}{
+		{
+			name:        "nothing granted",
+			wantMissing: []string{"read", "write"},
+		},
+		{
+			name:        "direct grants",
+			rows:        [][]interface{}{judgeRow(0, "read", "publisher-id", "requestor-id", 0), judgeRow(0, "write", "publisher-id", "requestor-id", 0)},
+			wantGranted: true,
+		},
+		{
+			name:        "missing scope is the requested scope not granted",
+			rows:        [][]interface{}{judgeRow(0, "read", "publisher-id", "requestor-id", 0)},
+			wantMissing: []string{"write"},
+		},
+		{
+			name:        "inherited from shadow",
+			rows:        [][]interface{}{judgeRow(0, "read", "publisher-id", "requestor-id", 0), judgeRow(0, "write", "publisher-id", "shadow-id", 2)},
+			wantGranted: true,
+			wantShadows: []VerdictShadow{{Scope: Scope{Name: "write"}, Shadow: Identity{Id: "shadow-id"}, Depth: 2}},
+		},
+		{
+			name:        "direct grant wins over nearest shadow",
+			rows:        [][]interface{}{judgeRow(0, "read", "publisher-id", "requestor-id", 0), judgeRow(0, "write", "publisher-id", "requestor-id", 0), judgeRow(0, "read", "publisher-id", "shadow-id", 1)},
+			wantGranted: true,
+		},
+		{
+			name:        "nearest shadow wins",
+			rows:        [][]interface{}{judgeRow(0, "read", "publisher-id", "requestor-id", 0), judgeRow(0, "write", "publisher-id", "near-id", 1), judgeRow(0, "write", "publisher-id", "far-id", 3)},
+			wantGranted: true,
+			wantShadows: []VerdictShadow{{Scope: Scope{Name: "write"}, Shadow: Identity{Id: "near-id"}, Depth: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := newFakeTx(map[string][][]interface{}{"JudgeMany": tt.rows})
+
+			verdicts, err := JudgeMany(tx, []JudgeQuery{query})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			verdict := verdicts[0]
+			if verdict.Granted != tt.wantGranted {
+				t.Errorf("granted = %v, want %v", verdict.Granted, tt.wantGranted)
+			}
+
+			if missing := scopeNames(verdict.MissingScopes); !equalParam(missing, tt.wantMissing) {
+				t.Errorf("missing = %v, want %v", missing, tt.wantMissing)
+			}
+
+			if len(verdict.Shadows) != len(tt.wantShadows) {
+				t.Fatalf("shadows = %v, want %v", verdict.Shadows, tt.wantShadows)
+			}
+			for i, s := range verdict.Shadows {
+				want := tt.wantShadows[i]
+				if s.Scope.Name != want.Scope.Name || s.Shadow.Id != want.Shadow.Id || s.Depth != want.Depth {
+					t.Errorf("shadow = %v, want %v", s, want)
+				}
+			}
+		})
+	}
+}
+
+func TestJudgeManyShadowPath(t *testing.T) {
+	defer viper.Reset()
+
+	tests := []struct {
+		name     string
+		depth    int
+		wantPath string
+	}{
+		{name: "shadows disabled", depth: 0, wantPath: "[:IS_GRANTED|GRANTS*0..0]"},
+		{name: "negative depth disables shadows", depth: -1, wantPath: "[:IS_GRANTED|GRANTS*0..0]"},
+		{name: "one shadow grant rule per level", depth: 2, wantPath: "[:IS_GRANTED|GRANTS*0..4]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("judge.shadows.depth", tt.depth)
+
+			tx := newFakeTx(nil)
+			_, err := JudgeMany(tx, []JudgeQuery{{Publisher: Identity{Id: "publisher-id"}, Requestor: Identity{Id: "requestor-id"}, Scopes: []Scope{{Name: "read"}}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cypher := tx.run(t, "JudgeMany").cypher
+			if !strings.Contains(cypher, tt.wantPath) {
+				t.Errorf("shadow path %s not in cypher", tt.wantPath)
+			}
+
+			// Shadow cycles must not be followed
+			if !strings.Contains(cypher, "single(m in nodes(path) WHERE m = n)") {
+				t.Error("shadow path is missing the cycle guard")
+			}
+		})
+	}
+}
+
+func TestTraceJudge(t *testing.T) {
+	tx := newFakeTx(map[string][][]interface{}{
+		"TraceJudge": {
+			// Granted through a shadow
+			{"read", fakeNode{"name": "read"}, true, fakeNode{"nbf": int64(1), "exp": int64(0)}, fakeNode{"id": "owner-id"}, []interface{}{fakeNode{"id": "requestor-id"}, fakeNode{"id": "shadow-id"}}, true, int64(100)},
+			// Not published, so never granted
+			{"write", nil, false, nil, nil, nil, true, int64(100)},
+		},
+	})
+
+	traces, err := TraceJudge(tx, Identity{Id: "publisher-id"}, Identity{Id: "requestor-id"}, []Scope{{Name: "read"}, {Name: "write"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(traces) != 2 {
+		t.Fatalf("traces = %d, want 2", len(traces))
+	}
+
+	granted := traces[0]
+	if !granted.Published || granted.Owner.Id != "owner-id" || granted.GrantRule.NotBefore != 1 || !granted.ShadowsValid || granted.Now != 100 {
+		t.Errorf("trace = %+v", granted)
+	}
+	if len(granted.Path) != 2 || granted.Path[1].Id != "shadow-id" {
+		t.Errorf("path = %v, want [requestor-id shadow-id]", granted.Path)
+	}
+
+	unpublished := traces[1]
+	if unpublished.Scope.Name != "write" || unpublished.Published || unpublished.Owner.Id != "" {
+		t.Errorf("trace = %+v", unpublished)
+	}
+
+	if scope := tx.run(t, "TraceJudge").params["scope"]; scope != "read write" {
+		t.Errorf("scope param = %v, want read write", scope)
+	}
+}
+
+func TestJudgeManyBatched(t *testing.T) {
+	queries := []JudgeQuery{
+		{Publisher: Identity{Id: "publisher-id"}, Requestor: Identity{Id: "requestor-id"}, Scopes: []Scope{{Name: "read"}}},
+		{Publisher: Identity{Id: "publisher-id"}, Requestor: Identity{Id: "requestor-id"}, Scopes: []Scope{{Name: "write"}}, Owners: []Identity{{Id: "owner-id"}}},
+		{Publisher: Identity{Id: "publisher-id"}, Requestor: Identity{Id: "requestor-id"}, Scopes: []Scope{{Name: "delete"}}},
+	}
+
+	tx := newFakeTx(map[string][][]interface{}{
+		"JudgeMany": {
+			judgeRow(0, "read", "publisher-id", "requestor-id", 0),
+			judgeRow(1, "write", "owner-id", "requestor-id", 0),
+			judgeRow(7, "delete", "publisher-id", "requestor-id", 0), // Unknown index
+		},
+	})
+
+	verdicts, err := JudgeMany(tx, queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One round trip for all queries
+	if len(tx.runs) != 1 {
+		t.Errorf("statements run = %d, want 1", len(tx.runs))
+	}
+
+	params := tx.run(t, "JudgeMany").params["queries"].([]interface{})
+	if len(params) != len(queries) {
+		t.Fatalf("queries param = %d, want %d", len(params), len(queries))
+	}
+	for index, p := range params {
+		if p.(map[string]interface{})["index"] != int64(index) {
+			t.Errorf("query %d has index %v", index, p.(map[string]interface{})["index"])
+		}
+	}
+
+	// The publisher is always an accepted owner
+	if owners := params[1].(map[string]interface{})["owners"]; !equalParam(owners, []string{"owner-id", "publisher-id"}) {
+		t.Errorf("owners = %v, want [owner-id publisher-id]", owners)
+	}
+
+	tests := []struct {
+		index       int
+		wantGranted bool
+		wantScope   string
+		wantOwner   string
+	}{
+		{index: 0, wantGranted: true, wantScope: "read", wantOwner: "publisher-id"},
+		{index: 1, wantGranted: true, wantScope: "write", wantOwner: "owner-id"},
+		{index: 2, wantGranted: false},
+	}
+
+	if len(verdicts) != len(tests) {
+		t.Fatalf("verdicts = %d, want %d", len(verdicts), len(tests))
+	}
+
+	for _, tt := range tests {
+		verdict := verdicts[tt.index]
+
+		if verdict.Granted != tt.wantGranted {
+			t.Errorf("verdict %d granted = %v, want %v", tt.index, verdict.Granted, tt.wantGranted)
+		}
+
+		if verdict.RequestedScopes[0].Name != queries[tt.index].Scopes[0].Name {
+			t.Errorf("verdict %d judged %v, want %v", tt.index, verdict.RequestedScopes, queries[tt.index].Scopes)
+		}
+
+		if !tt.wantGranted {
+			if len(verdict.MissingScopes) != 1 {
+				t.Errorf("verdict %d missing = %v, want the requested scopes", tt.index, verdict.MissingScopes)
+			}
+			continue
+		}
+
+		if len(verdict.GrantedScopes) != 1 || verdict.GrantedScopes[0].Name != tt.wantScope {
+			t.Errorf("verdict %d granted scopes = %v, want [%s]", tt.index, verdict.GrantedScopes, tt.wantScope)
+		}
+
+		if len(verdict.Owners) != 1 || verdict.Owners[0].Id != tt.wantOwner {
+			t.Errorf("verdict %d owners = %v, want [%s]", tt.index, verdict.Owners, tt.wantOwner)
+		}
+	}
+}
+
+func TestJudgeManyInvalidQueries(t *testing.T) {
+	valid := JudgeQuery{Publisher: Identity{Id: "publisher-id"}, Requestor: Identity{Id: "requestor-id"}, Scopes: []Scope{{Name: "read"}}}
+
+	tests := []struct {
+		name    string
+		queries []JudgeQuery
+	}{
+		{name: "no queries"},
+		{name: "missing publisher", queries: []JudgeQuery{valid, {Requestor: valid.Requestor, Scopes: valid.Scopes}}},
+		{name: "missing requestor", queries: []JudgeQuery{valid, {Publisher: valid.Publisher, Scopes: valid.Scopes}}},
+		{name: "missing scopes", queries: []JudgeQuery{valid, {Publisher: valid.Publisher, Requestor: valid.Requestor}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := newFakeTx(nil)
+
+			if _, err := JudgeMany(tx, tt.queries); err == nil {
+				t.Error("expected error")
+			}
+
+			if len(tx.runs) > 0 {
+				t.Error("statement run on invalid queries")
+			}
+		})
+	}
+}
+
+func TestJudgeManySubscriptions(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("judge.subscriptions.enforce", 1)
+
+	granted := [][]interface{}{judgeRow(0, "read", "publisher-id", "requestor-id", 0), judgeRow(0, "write", "publisher-id", "requestor-id", 0)}
+
+	tests := []struct {
+		name                 string
+		client               Identity
+		rows                 [][]interface{}
+		wantGranted          bool
+		wantSubscriptions    []string
+		wantConsents         []string
+		wantConsentsExpire   int64
+		wantSubscriptionsRun bool
+	}{
+		{
+			name:        "no client",
+			wantGranted: true,
+		},
+		{
+			name:                 "subscribed and consented",
+			client:               Identity{Id: "client-id"},
+			rows:                 [][]interface{}{{int64(0), "read", true, true, int64(200)}, {int64(0), "write", true, true, int64(150)}},
+			wantGranted:          true,
+			wantConsentsExpire:   150,
+			wantSubscriptionsRun: true,
+		},
+		{
+			name:                 "consents never expire",
+			client:               Identity{Id: "client-id"},
+			rows:                 [][]interface{}{{int64(0), "read", true, true, nil}, {int64(0), "write", true, true, nil}},
+			wantGranted:          true,
+			wantSubscriptionsRun: true,
+		},
+		{
+			name:                 "not subscribed",
+			client:               Identity{Id: "client-id"},
+			rows:                 [][]interface{}{{int64(0), "read", true, true, nil}, {int64(0), "write", false, false, nil}},
+			wantSubscriptions:    []string{"write"},
+			wantSubscriptionsRun: true,
+		},
+		{
+			name:                 "not consented",
+			client:               Identity{Id: "client-id"},
+			rows:                 [][]interface{}{{int64(0), "read", true, false, nil}, {int64(0), "write", true, true, nil}},
+			wantConsents:         []string{"read"},
+			wantSubscriptionsRun: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := newFakeTx(map[string][][]interface{}{"JudgeMany": granted, "judgeSubscriptions": tt.rows})
+
+			verdicts, err := JudgeMany(tx, []JudgeQuery{{
+				Publisher: Identity{Id: "publisher-id"},
+				Requestor: Identity{Id: "requestor-id"},
+				Client:    tt.client,
+				Scopes:    []Scope{{Name: "read"}, {Name: "write"}},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ran := len(tx.runs) == 2; ran != tt.wantSubscriptionsRun {
+				t.Errorf("subscriptions judged = %v, want %v", ran, tt.wantSubscriptionsRun)
+			}
+
+			verdict := verdicts[0]
+			if verdict.Granted != tt.wantGranted {
+				t.Errorf("granted = %v, want %v", verdict.Granted, tt.wantGranted)
+			}
+
+			if missing := scopeNames(verdict.MissingSubscriptions); !equalParam(missing, tt.wantSubscriptions) {
+				t.Errorf("missing subscriptions = %v, want %v", missing, tt.wantSubscriptions)
+			}
+
+			if missing := scopeNames(verdict.MissingConsents); !equalParam(missing, tt.wantConsents) {
+				t.Errorf("missing consents = %v, want %v", missing, tt.wantConsents)
+			}
+
+			if verdict.ConsentsExpire != tt.wantConsentsExpire {
+				t.Errorf("consents expire = %d, want %d", verdict.ConsentsExpire, tt.wantConsentsExpire)
+			}
+		})
+	}
+}
+
+func TestJudgeManySubscriptionsNotEnforced(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("judge.subscriptions.enforce", 0)
+
+	tx := newFakeTx(map[string][][]interface{}{"JudgeMany": {judgeRow(0, "read", "publisher-id", "requestor-id", 0)}})
+
+	verdicts, err := JudgeMany(tx, []JudgeQuery{{Publisher: Identity{Id: "publisher-id"}, Requestor: Identity{Id: "requestor-id"}, Client: Identity{Id: "client-id"}, Scopes: []Scope{{Name: "read"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tx.runs) != 1 {
+		t.Errorf("statements run = %d, want 1", len(tx.runs))
+	}
+
+	if !verdicts[0].Granted {
+		t.Error("granted = false, want true")
+	}
+}
@@ -2,6 +2,7 @@ package aap
 
 import (
 	"github.com/neo4j/neo4j-go-driver/neo4j"
+	"strings"
 )
 
 type Identity struct {
@@ -53,6 +54,7 @@ func marshalNodeToClient(node neo4j.Node) Client {
 }
 
 type ResourceServer struct {
+	Id          string
 	Name        string
 	Audience    string
 	Description string
@@ -62,6 +64,7 @@ func marshalNodeToResourceServer(node neo4j.Node) ResourceServer {
 	p := node.Props()
 
 	return ResourceServer{
+		Id:          p["id"].(string),
 		Name:        p["name"].(string),
 		Audience:    p["aud"].(string),
 		Description: p["description"].(string),
@@ -69,22 +72,71 @@ func marshalNodeToResourceServer(node neo4j.Node) ResourceServer {
 }
 
 type Scope struct {
-	Name string
+	Name        string
+	Title       string
+	Description string
+	Sensitivity string
+	Deprecated  bool
 }
 
-func marshalNodeToScope(node neo4j.Node) Scope {
+// The metadata of a scope to update. Nil fields are left unchanged.
+type ScopeUpdate struct {
+	Title       *string
+	Description *string
+	Sensitivity *string
+	Deprecated  *bool
+}
+
+func marshalNodeToScope(node neo4j.Node) (s Scope) {
 	p := node.Props()
 
-	return Scope{
-		Name: p["name"].(string),
+	s.Name = p["name"].(string)
+
+	if p["title"] != nil {
+		s.Title = p["title"].(string)
+	}
+
+	if p["description"] != nil {
+		s.Description = p["description"].(string)
+	}
+
+	if p["sensitivity"] != nil {
+		s.Sensitivity = p["sensitivity"].(string)
+	}
+
+	if p["deprecated"] != nil {
+		s.Deprecated = p["deprecated"].(bool)
 	}
+
+	return s
 }
 
 type PublishRule struct {
+	Title            string
+	Description      string
+	Translations     map[string]PublishRuleTranslation // Keyed by lower case language tag, eg. da or de-at
+	ApprovalRequired bool                              // New subscriptions are pending until approved on behalf of the publisher
+
+	// Step-up authentication required to use the scope
+	Acr    []string // Any of the authentication context classes
+	Amr    []string // All of the authentication methods
+	MaxAge int64    // Max seconds since the subject authenticated. 0 = no limit
+}
+
+// IsStepUpRequired is true if using the scope requires a certain authentication of the subject
+func (pr PublishRule) IsStepUpRequired() bool {
+	return len(pr.Acr) > 0 || len(pr.Amr) > 0 || pr.MaxAge > 0
+}
+
+type PublishRuleTranslation struct {
 	Title       string
 	Description string
 }
 
+// Translations are stored on the rule as title:<language tag> and description:<language tag> properties
+const PUBLISH_RULE_TITLE_PREFIX = "title:"
+const PUBLISH_RULE_DESCRIPTION_PREFIX = "description:"
+
 func marshalNodeToPublishRule(node neo4j.Node) (pr PublishRule) {
 	p := node.Props()
 
@@ -96,6 +148,49 @@ func marshalNodeToPublishRule(node neo4j.Node) (pr PublishRule) {
 		pr.Description = p["description"].(string)
 	}
 
+	if p["approval_required"] != nil {
+		pr.ApprovalRequired = p["approval_required"].(bool)
+	}
+
+	if p["acr"] != nil {
+		for _, acr := range p["acr"].([]interface{}) {
+			pr.Acr = append(pr.Acr, acr.(string))
+		}
+	}
+
+	if p["amr"] != nil {
+		for _, amr := range p["amr"].([]interface{}) {
+			pr.Amr = append(pr.Amr, amr.(string))
+		}
+	}
+
+	if p["max_age"] != nil {
+		pr.MaxAge = p["max_age"].(int64)
+	}
+
+	for key, value := range p {
+		var tag string
+		if strings.HasPrefix(key, PUBLISH_RULE_TITLE_PREFIX) {
+			tag = strings.TrimPrefix(key, PUBLISH_RULE_TITLE_PREFIX)
+		} else if strings.HasPrefix(key, PUBLISH_RULE_DESCRIPTION_PREFIX) {
+			tag = strings.TrimPrefix(key, PUBLISH_RULE_DESCRIPTION_PREFIX)
+		} else {
+			continue
+		}
+
+		if pr.Translations == nil {
+			pr.Translations = make(map[string]PublishRuleTranslation)
+		}
+
+		translation := pr.Translations[tag]
+		if strings.HasPrefix(key, PUBLISH_RULE_TITLE_PREFIX) {
+			translation.Title = value.(string)
+		} else {
+			translation.Description = value.(string)
+		}
+		pr.Translations[tag] = translation
+	}
+
 	return pr
 }
 
@@ -128,10 +223,35 @@ type Grant struct {
 }
 
 type Consent struct {
-	Identity   Identity
-	Subscriber Identity
-	Publisher  Identity
-	Scope      Scope
+	Identity    Identity
+	Subscriber  Identity
+	Publisher   Identity
+	Scope       Scope
+	ConsentRule ConsentRule
+}
+
+type ConsentRule struct {
+	NotBefore int64
+	Expire    int64 // 0 = never expires
+	Automatic bool  // Given by aap on behalf of the subject, because the subscription is trusted
+}
+
+func marshalNodeToConsentRule(node neo4j.Node) (cr ConsentRule) {
+	p := node.Props()
+
+	if p["nbf"] != nil {
+		cr.NotBefore = p["nbf"].(int64)
+	}
+
+	if p["exp"] != nil {
+		cr.Expire = p["exp"].(int64)
+	}
+
+	if p["automatic"] != nil {
+		cr.Automatic = p["automatic"].(bool)
+	}
+
+	return cr
 }
 
 type Publish struct {
@@ -142,10 +262,59 @@ type Publish struct {
 	MayGrantRules  []PublishRule
 }
 
+// Rules depending on the publish rule of a scope
+type PublishDependents struct {
+	Grants        int64
+	Subscriptions int64
+	Consents      int64
+	Subscribers   []Identity
+}
+
 type Subscription struct {
-	Subscriber Identity
-	Publisher  Identity
-	Scope      Scope
+	Subscriber    Identity
+	Publisher     Identity
+	Scope         Scope
+	SubscribeRule SubscribeRule
+}
+
+const SUBSCRIPTION_PENDING = "pending"
+const SUBSCRIPTION_APPROVED = "approved"
+const SUBSCRIPTION_REJECTED = "rejected"
+
+type SubscribeRule struct {
+	Required  bool   // Consent to the scope cannot be deselected by the subject
+	Trusted   bool   // First party subscriber. Consent is given automatically, the subject is never prompted
+	State     string // Only approved subscriptions are synced to hydra and can be consented to
+	DecidedBy Identity
+	DecidedAt int64
+}
+
+func marshalNodeToSubscribeRule(node neo4j.Node) (sr SubscribeRule) {
+	p := node.Props()
+
+	// Subscriptions from before approval was introduced are approved
+	sr.State = SUBSCRIPTION_APPROVED
+	if p["state"] != nil {
+		sr.State = p["state"].(string)
+	}
+
+	if p["decided_by"] != nil {
+		sr.DecidedBy = Identity{Id: p["decided_by"].(string)}
+	}
+
+	if p["decided_at"] != nil {
+		sr.DecidedAt = p["decided_at"].(int64)
+	}
+
+	if p["required"] != nil {
+		sr.Required = p["required"].(bool)
+	}
+
+	if p["trusted"] != nil {
+		sr.Trusted = p["trusted"].(bool)
+	}
+
+	return sr
 }
 
 type Shadow struct {
@@ -154,6 +323,17 @@ type Shadow struct {
 	GrantRule GrantRule
 }
 
+// What to judge. Owners defaults to the publisher only.
+// Client is the client the access token was issued to. When judge.subscriptions.enforce is enabled it must be subscribed to the scopes,
+// and consented to unless the requestor is the client itself.
+type JudgeQuery struct {
+	Publisher Identity
+	Requestor Identity
+	Scopes    []Scope
+	Owners    []Identity
+	Client    Identity
+}
+
 type Verdict struct {
 	Publisher       Identity
 	Requestor       Identity
@@ -161,5 +341,264 @@ type Verdict struct {
 	GrantedScopes   []Scope
 	MissingScopes   []Scope
 	Owners          []Identity
+	Shadows         []VerdictShadow
 	Granted         bool
+
+	// Only judged when subscriptions are enforced
+	MissingSubscriptions []Scope
+	MissingConsents      []Scope
+	ConsentsExpire       int64 // Earliest exp of the consents the verdict depends on. 0 = never expires
+
+	// Authentication of the subject required by the publisher to use the requested scopes. The token must satisfy all of them.
+	StepUps []VerdictStepUp
+}
+
+type VerdictStepUp struct {
+	Scope  Scope
+	Acr    []string
+	Amr    []string
+	MaxAge int64
+}
+
+// A granted scope that the requestor inherited from an identity it shadows
+type VerdictShadow struct {
+	Scope  Scope
+	Shadow Identity
+	Depth  int64
+}
+
+// Revokes access tokens. Every non empty of Jti, Subject and ClientId must match the token.
+type Revocation struct {
+	Id        string
+	Jti       string
+	Subject   string
+	ClientId  string
+	Scopes    []string // Only tokens carrying any of the scopes are revoked. Empty revokes regardless of scopes
+	RevokedAt int64
+	Expire    int64
+}
+
+func marshalNodeToRevocation(node neo4j.Node) (r Revocation) {
+	p := node.Props()
+
+	r.Id = p["id"].(string)
+
+	if p["jti"] != nil {
+		r.Jti = p["jti"].(string)
+	}
+
+	if p["sub"] != nil {
+		r.Subject = p["sub"].(string)
+	}
+
+	if p["client_id"] != nil {
+		r.ClientId = p["client_id"].(string)
+	}
+
+	if p["scopes"] != nil {
+		for _, scope := range p["scopes"].([]interface{}) {
+			r.Scopes = append(r.Scopes, scope.(string))
+		}
+	}
+
+	if p["revoked_at"] != nil {
+		r.RevokedAt = p["revoked_at"].(int64)
+	}
+
+	if p["exp"] != nil {
+		r.Expire = p["exp"].(int64)
+	}
+
+	return r
+}
+
+// A candidate grant considered when judging a scope. Used to explain verdicts, so rules are returned regardless of nbf and exp.
+type VerdictTrace struct {
+	Scope        Scope
+	Published    bool
+	GrantRule    GrantRule
+	Owner        Identity
+	Path         []Identity // From the requestor to the identity holding the grant
+	ShadowsValid bool       // All shadow grant rules on the path are valid
+	Now          int64
+}
+
+type Webhook struct {
+	Id        string
+	Url       string
+	Events    []string // Event types to deliver. Supports * and prefix.* patterns
+	Secret    string
+	Publisher Identity // Registered on behalf of, only events concerning the publisher are delivered
+	CreatedAt int64
+}
+
+func marshalNodeToWebhook(node neo4j.Node) (w Webhook) {
+	p := node.Props()
+
+	w.Id = p["id"].(string)
+	w.Url = p["url"].(string)
+
+	if p["events"] != nil {
+		for _, e := range p["events"].([]interface{}) {
+			w.Events = append(w.Events, e.(string))
+		}
+	}
+
+	if p["secret"] != nil {
+		w.Secret = p["secret"].(string)
+	}
+
+	if p["created_at"] != nil {
+		w.CreatedAt = p["created_at"].(int64)
+	}
+
+	return w
+}
+
+const (
+	WEBHOOK_DELIVERY_PENDING   = "pending"
+	WEBHOOK_DELIVERY_DELIVERED = "delivered"
+	WEBHOOK_DELIVERY_DEAD      = "dead" // Gave up, see the dead letter list
+)
+
+type WebhookDelivery struct {
+	Id            string
+	Webhook       Webhook
+	EventId       string
+	EventType     string
+	Payload       string
+	Status        string
+	Attempts      int64
+	NextAttemptAt int64
+	CreatedAt     int64
+	AttemptLog    []WebhookDeliveryAttempt
+}
+
+func marshalNodeToWebhookDelivery(node neo4j.Node) (d WebhookDelivery) {
+	p := node.Props()
+
+	d.Id = p["id"].(string)
+	d.EventId = p["event_id"].(string)
+	d.EventType = p["event_type"].(string)
+	d.Payload = p["payload"].(string)
+	d.Status = p["status"].(string)
+
+	if p["attempts"] != nil {
+		d.Attempts = p["attempts"].(int64)
+	}
+
+	if p["next_attempt_at"] != nil {
+		d.NextAttemptAt = p["next_attempt_at"].(int64)
+	}
+
+	if p["created_at"] != nil {
+		d.CreatedAt = p["created_at"].(int64)
+	}
+
+	return d
+}
+
+type WebhookDeliveryAttempt struct {
+	At         int64
+	StatusCode int64
+	Error      string
+	Duration   int64 // Milliseconds
+}
+
+func marshalNodeToWebhookDeliveryAttempt(node neo4j.Node) (a WebhookDeliveryAttempt) {
+	p := node.Props()
+
+	if p["at"] != nil {
+		a.At = p["at"].(int64)
+	}
+
+	if p["status_code"] != nil {
+		a.StatusCode = p["status_code"].(int64)
+	}
+
+	if p["error"] != nil {
+		a.Error = p["error"].(string)
+	}
+
+	if p["duration"] != nil {
+		a.Duration = p["duration"].(int64)
+	}
+
+	return a
+}
+
+const (
+	CONSENT_HISTORY_GRANTED   = "granted"
+	CONSENT_HISTORY_WITHDRAWN = "withdrawn"
+	CONSENT_HISTORY_EXPIRED   = "expired"
+	CONSENT_HISTORY_REJECTED  = "rejected"
+)
+
+// ConsentHistory is an immutable record of a change to a consent, with the names and texts shown at the time.
+type ConsentHistory struct {
+	Id             string
+	Action         string
+	At             int64
+	Identity       Identity
+	Subscriber     Identity
+	SubscriberName string
+	Publisher      Identity
+	PublisherName  string
+	Scope          Scope
+	Title          string
+	Description    string
+	ConsentRule    ConsentRule
+	Challenge      string
+}
+
+func marshalNodeToConsentHistory(node neo4j.Node) (h ConsentHistory) {
+	p := node.Props()
+
+	h.Id = p["id"].(string)
+	h.Action = p["action"].(string)
+	h.At = p["at"].(int64)
+	h.Identity = Identity{Id: p["identity_id"].(string)}
+
+	if p["subscriber_id"] != nil {
+		h.Subscriber = Identity{Id: p["subscriber_id"].(string)}
+	}
+
+	if p["subscriber_name"] != nil {
+		h.SubscriberName = p["subscriber_name"].(string)
+	}
+
+	if p["publisher_id"] != nil {
+		h.Publisher = Identity{Id: p["publisher_id"].(string)}
+	}
+
+	if p["publisher_name"] != nil {
+		h.PublisherName = p["publisher_name"].(string)
+	}
+
+	if p["scope"] != nil {
+		h.Scope = Scope{Name: p["scope"].(string)}
+	}
+
+	if p["title"] != nil {
+		h.Title = p["title"].(string)
+	}
+
+	if p["description"] != nil {
+		h.Description = p["description"].(string)
+	}
+
+	if p["challenge"] != nil {
+		h.Challenge = p["challenge"].(string)
+	}
+
+	h.ConsentRule = marshalNodeToConsentRule(node)
+
+	return h
+}
+
+// When the subject last was seen using the subscriber (client), ie. a token of the pair was judged and granted
+type ClientUsage struct {
+	Identity   Identity
+	Subscriber Identity
+	LastUsedAt int64
 }
@@ -43,6 +43,8 @@ func logCypher(query string, params map[string]interface{}) {
 	for i, e := range params {
 
 		switch t := e.(type) {
+		case nil:
+			query = strings.Replace(query, "$"+i, "null", -1)
 		case bool:
 			query = strings.Replace(query, "$"+i, "\""+strconv.FormatBool(e.(bool))+"\"", -1)
 		case int:
@@ -53,6 +55,8 @@ func logCypher(query string, params map[string]interface{}) {
 			query = strings.Replace(query, "$"+i, "\""+e.(string)+"\"", -1)
 		case []string:
 			query = strings.Replace(query, "$"+i, "["+strings.Join(e.([]string), ",")+"]", -1)
+		case []interface{}, map[string]interface{}:
+			query = strings.Replace(query, "$"+i, fmt.Sprintf("%v", e), -1)
 		default:
 			panic(fmt.Sprintf("Unsupported type %T", t))
 		}
@@ -0,0 +1,113 @@
+package aap
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// fakeTx answers each statement with records keyed by the first comment of the statement, which names it by convention.
+// Statements are recorded, so tests can assert on the parameters sent to neo4j.
+type fakeTx struct {
+	answers map[string][][]interface{}
+	runs    []fakeRun
+}
+
+type fakeRun struct {
+	cypher string
+	params map[string]interface{}
+}
+
+func newFakeTx(answers map[string][][]interface{}) *fakeTx {
+	return &fakeTx{answers: answers}
+}
+
+func (tx *fakeTx) Run(cypher string, params map[string]interface{}) (neo4j.Result, error) {
+	tx.runs = append(tx.runs, fakeRun{cypher: cypher, params: params})
+
+	return &fakeResult{records: tx.answers[statementName(cypher)], index: -1}, nil
+}
+
+func statementName(cypher string) string {
+	for _, line := range strings.Split(cypher, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "//") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "//"))
+		}
+	}
+	return ""
+}
+
+func (tx *fakeTx) Commit() error   { return nil }
+func (tx *fakeTx) Rollback() error { return nil }
+func (tx *fakeTx) Close() error    { return nil }
+
+// run returns the first recorded run of the statement
+func (tx *fakeTx) run(t *testing.T, statement string) fakeRun {
+	t.Helper()
+
+	for _, r := range tx.runs {
+		if statementName(r.cypher) == statement {
+			return r
+		}
+	}
+	t.Fatalf("statement %s was not run", statement)
+	return fakeRun{}
+}
+
+type fakeResult struct {
+	records [][]interface{}
+	index   int
+}
+
+func (r *fakeResult) Keys() ([]string, error) { return nil, nil }
+func (r *fakeResult) Err() error              { return nil }
+func (r *fakeResult) Next() bool {
+	r.index++
+	return r.index < len(r.records)
+}
+func (r *fakeResult) Record() neo4j.Record                  { return fakeRecord(r.records[r.index]) }
+func (r *fakeResult) Summary() (neo4j.ResultSummary, error) { return nil, nil }
+func (r *fakeResult) Consume() (neo4j.ResultSummary, error) { return nil, nil }
+
+type fakeRecord []interface{}
+
+func (r fakeRecord) Keys() []string                     { return nil }
+func (r fakeRecord) Values() []interface{}              { return r }
+func (r fakeRecord) Get(key string) (interface{}, bool) { return nil, false }
+func (r fakeRecord) GetByIndex(index int) interface{}   { return r[index] }
+
+type fakeNode map[string]interface{}
+
+func (n fakeNode) Id() int64                     { return 0 }
+func (n fakeNode) Labels() []string              { return nil }
+func (n fakeNode) Props() map[string]interface{} { return n }
+
+func TestLogCypher(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{name: "nil", value: nil},
+		{name: "bool", value: true},
+		{name: "int", value: 1},
+		{name: "int64", value: int64(1)},
+		{name: "string", value: "a"},
+		{name: "strings", value: []string{"a", "b"}},
+		{name: "list", value: []interface{}{"a", int64(1)}},
+		{name: "map", value: map[string]interface{}{"a": nil}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("logCypher panicked on %T: %v", tt.value, r)
+				}
+			}()
+
+			logCypher("RETURN $value", map[string]interface{}{"value": tt.value})
+		})
+	}
+}
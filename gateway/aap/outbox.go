@@ -0,0 +1,180 @@
+package aap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// An event waiting in the outbox to be published on nats
+type OutboxEvent struct {
+	Id            string
+	Type          string
+	Payload       string
+	Attempts      int64
+	NextAttemptAt int64
+}
+
+func marshalNodeToOutboxEvent(node neo4j.Node) (o OutboxEvent) {
+	p := node.Props()
+
+	o.Id = p["id"].(string)
+	o.Type = p["type"].(string)
+	o.Payload = p["payload"].(string)
+
+	if p["attempts"] != nil {
+		o.Attempts = p["attempts"].(int64)
+	}
+
+	if p["next_attempt_at"] != nil {
+		o.NextAttemptAt = p["next_attempt_at"].(int64)
+	}
+
+	return o
+}
+
+// CreateOutboxEvent stores the event in the same transaction as the change causing it. The event id is used by consumers to deduplicate.
+func CreateOutboxEvent(tx neo4j.Transaction, iEvent Event) (rOutboxEvent OutboxEvent, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iEvent.Id == "" {
+		return OutboxEvent{}, errors.New("Missing iEvent.Id")
+	}
+	params["id"] = iEvent.Id
+
+	if iEvent.Type == "" {
+		return OutboxEvent{}, errors.New("Missing iEvent.Type")
+	}
+	params["type"] = iEvent.Type
+
+	payload, err := json.Marshal(iEvent)
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+	params["payload"] = string(payload)
+
+	cypher = fmt.Sprintf(`
+    // CreateOutboxEvent
+
+    CREATE (o:Outbox {id:$id, type:$type, payload:$payload, created_at:datetime().epochSeconds, attempts:0, next_attempt_at:datetime().epochSeconds})
+  `)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return OutboxEvent{}, err
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return OutboxEvent{}, err
+	}
+
+	return OutboxEvent{Id: iEvent.Id, Type: iEvent.Type, Payload: string(payload)}, nil
+}
+
+// FetchPendingOutboxEvents returns the oldest events due for a publish attempt.
+func FetchPendingOutboxEvents(tx neo4j.Transaction, iLimit int64) (rEvents []OutboxEvent, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	params["limit"] = iLimit
+
+	cypher = fmt.Sprintf(`
+    // FetchPendingOutboxEvents
+
+    MATCH (o:Outbox)
+    WHERE o.next_attempt_at <= datetime().epochSeconds
+    RETURN o
+    ORDER BY o.created_at
+    LIMIT $limit
+  `)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		outboxNode := record.GetByIndex(0)
+
+		if outboxNode != nil {
+			rEvents = append(rEvents, marshalNodeToOutboxEvent(outboxNode.(neo4j.Node)))
+		}
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return rEvents, nil
+}
+
+// DeleteOutboxEvent removes a published event from the outbox.
+func DeleteOutboxEvent(tx neo4j.Transaction, iEvent OutboxEvent) (err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iEvent.Id == "" {
+		return errors.New("Missing iEvent.Id")
+	}
+	params["id"] = iEvent.Id
+
+	cypher = fmt.Sprintf(`
+    // DeleteOutboxEvent
+
+    MATCH (o:Outbox {id:$id})
+    DELETE o
+  `)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return err
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RescheduleOutboxEvent records a failed publish attempt and when to try again.
+func RescheduleOutboxEvent(tx neo4j.Transaction, iEvent OutboxEvent, iNextAttemptAt int64, iError string) (err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iEvent.Id == "" {
+		return errors.New("Missing iEvent.Id")
+	}
+	params["id"] = iEvent.Id
+	params["next_attempt_at"] = iNextAttemptAt
+	params["last_error"] = iError
+
+	cypher = fmt.Sprintf(`
+    // RescheduleOutboxEvent
+
+    MATCH (o:Outbox {id:$id})
+    SET o.attempts = o.attempts + 1, o.next_attempt_at = $next_attempt_at, o.last_error = $last_error
+  `)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return err
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
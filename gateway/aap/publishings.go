@@ -46,6 +46,41 @@ func CreatePublishes(tx neo4j.Transaction, requestedBy Identity, newPublish Publ
 	}
 	params["description"] = newPublish.Rule.Description
 
+	var translations = make(map[string]interface{})
+	for tag, translation := range newPublish.Rule.Translations {
+		tag = strings.ToLower(tag)
+
+		if tag == "" || len(stripChars(tag, " :")) != len(tag) {
+			return Publish{}, errors.New("Invalid language tag '" + tag + "' in Publish.Rule.Translations")
+		}
+
+		if translation.Title == "" {
+			return Publish{}, errors.New("Missing Publish.Rule.Translations[" + tag + "].Title")
+		}
+		translations[PUBLISH_RULE_TITLE_PREFIX+tag] = translation.Title
+
+		if translation.Description == "" {
+			return Publish{}, errors.New("Missing Publish.Rule.Translations[" + tag + "].Description")
+		}
+		translations[PUBLISH_RULE_DESCRIPTION_PREFIX+tag] = translation.Description
+	}
+	params["translations"] = translations
+	params["approval_required"] = newPublish.Rule.ApprovalRequired
+
+	// Unset step-up requirements are not stored
+	params["acr"] = nil
+	if len(newPublish.Rule.Acr) > 0 {
+		params["acr"] = newPublish.Rule.Acr
+	}
+	params["amr"] = nil
+	if len(newPublish.Rule.Amr) > 0 {
+		params["amr"] = newPublish.Rule.Amr
+	}
+	params["max_age"] = nil
+	if newPublish.Rule.MaxAge > 0 {
+		params["max_age"] = newPublish.Rule.MaxAge
+	}
+
 	// ensure scope exists
 	_, err = CreateScope(tx, newPublish.Scope, requestedBy)
 	if err != nil {
@@ -69,7 +104,8 @@ func CreatePublishes(tx neo4j.Transaction, requestedBy Identity, newPublish Publ
 
     DETACH DELETE existingPr, existingMgpr, existingRootmgpr
 
-    MERGE (publisher)-[:PUBLISH]-(pr:Publish:Rule {title:$title, description:$description})-[:PUBLISH]->(s)
+    MERGE (publisher)-[:PUBLISH]-(pr:Publish:Rule {title:$title, description:$description, approval_required:$approval_required})-[:PUBLISH]->(s)
+    SET pr += $translations, pr.acr = $acr, pr.amr = $amr, pr.max_age = $max_age
     MERGE (publisher)-[:PUBLISH]-(mgpr:Publish:Rule)-[:PUBLISH]->(mg)
     MERGE (publisher)-[:PUBLISH]-(rootmgpr:Publish:Rule)-[:PUBLISH]->(rootmg)
 
@@ -210,3 +246,151 @@ func FetchPublishes(tx neo4j.Transaction, iFilterPublisher Identity, iFilterScop
 
 	return publishes, nil
 }
+
+func FetchPublishDependents(tx neo4j.Transaction, iPublish Publish) (dependents PublishDependents, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iPublish.Publisher.Id == "" {
+		return PublishDependents{}, errors.New("Missing iPublish.Publisher.Id")
+	}
+	params["publisher_id"] = iPublish.Publisher.Id
+
+	if iPublish.Scope.Name == "" {
+		return PublishDependents{}, errors.New("Missing iPublish.Scope.Name")
+	}
+	params["scope"] = iPublish.Scope.Name
+
+	cypher = fmt.Sprintf(`
+    // Fetch rules depending on a publishing
+
+    MATCH (publisher:Identity {id:$publisher_id})-[:PUBLISH]->(pr:Publish:Rule)-[:PUBLISH]->(scope:Scope {name:$scope})
+
+    // The mg: and 0:mg: companion rules are deleted with the publishing, so their dependents count as well
+    OPTIONAL MATCH (publisher)-[:PUBLISH]->(mgpr:Publish:Rule)-[:PUBLISH]->(:Scope {name:"mg:"+$scope})
+    OPTIONAL MATCH (publisher)-[:PUBLISH]->(rootmgpr:Publish:Rule)-[:PUBLISH]->(:Scope {name:"0:mg:"+$scope})
+
+    WITH collect(DISTINCT pr) + collect(DISTINCT mgpr) + collect(DISTINCT rootmgpr) as rules
+
+    UNWIND rules as rule
+    OPTIONAL MATCH (:Identity)-[:IS_GRANTED]->(gr:Grant:Rule)-[:GRANTS]->(rule)
+    WITH rules, count(DISTINCT gr) as grants
+
+    UNWIND rules as rule
+    OPTIONAL MATCH (subscriber:Identity)-[:SUBSCRIBES]->(sr:Subscribe:Rule)-[:SUBSCRIBES]->(rule)
+    WITH rules, grants, count(DISTINCT sr) as subscriptions, collect(DISTINCT subscriber) as subscribers
+
+    UNWIND rules as rule
+    OPTIONAL MATCH (:Identity)-[:CONSENT]->(cr:Consent:Rule)-[:CONSENT]->(rule)
+
+    RETURN grants, subscriptions, count(DISTINCT cr) as consents, subscribers
+  `)
+
+	logCypher(cypher, params)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return PublishDependents{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		grants := record.GetByIndex(0)
+		subscriptions := record.GetByIndex(1)
+		consents := record.GetByIndex(2)
+		subscriberNodes := record.GetByIndex(3)
+
+		if grants != nil {
+			dependents.Grants = grants.(int64)
+		}
+		if subscriptions != nil {
+			dependents.Subscriptions = subscriptions.(int64)
+		}
+		if consents != nil {
+			dependents.Consents = consents.(int64)
+		}
+		if subscriberNodes != nil {
+			for _, node := range subscriberNodes.([]interface{}) {
+				dependents.Subscribers = append(dependents.Subscribers, marshalNodeToIdentity(node.(neo4j.Node)))
+			}
+		}
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return PublishDependents{}, err
+	}
+
+	return dependents, nil
+}
+
+// DeletePublish removes the publish rule of the scope together with the mg: and 0:mg: companion rules created by CreatePublishes.
+// With cascade all grant, subscribe and consent rules attached to any of the removed rules are removed as well. Without cascade the delete is refused if any exist.
+func DeletePublish(tx neo4j.Transaction, iPublish Publish, cascade bool) (err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iPublish.Publisher.Id == "" {
+		return errors.New("Missing iPublish.Publisher.Id")
+	}
+	params["publisher_id"] = iPublish.Publisher.Id
+
+	if iPublish.Scope.Name == "" {
+		return errors.New("Missing iPublish.Scope.Name")
+	}
+	params["scope"] = iPublish.Scope.Name
+
+	if cascade == false {
+		dependents, err := FetchPublishDependents(tx, iPublish)
+		if err != nil {
+			return err
+		}
+
+		if dependents.Grants > 0 || dependents.Subscriptions > 0 || dependents.Consents > 0 {
+			return errors.New("Publishing has dependents")
+		}
+	}
+
+	cypher = fmt.Sprintf(`
+    // Delete publishing
+
+    MATCH (publisher:Identity {id:$publisher_id})-[:PUBLISH]->(pr:Publish:Rule)-[:PUBLISH]->(scope:Scope {name:$scope})
+
+    OPTIONAL MATCH (publisher)-[:PUBLISH]->(mgpr:Publish:Rule)-[:PUBLISH]->(:Scope {name:"mg:"+$scope})
+    OPTIONAL MATCH (publisher)-[:PUBLISH]->(rootmgpr:Publish:Rule)-[:PUBLISH]->(:Scope {name:"0:mg:"+$scope})
+
+    // The consents are withdrawn with the publishing
+    OPTIONAL MATCH (owner:Identity)-[:CONSENT]->(cr:Consent:Rule)-[:CONSENT]->(pr), (cr)-[:CONSENT]->(subscriber:Identity)
+    FOREACH (_ in CASE WHEN cr IS NULL THEN [] ELSE [1] END | %s)
+
+    WITH collect(DISTINCT pr) + collect(DISTINCT mgpr) + collect(DISTINCT rootmgpr) as rules
+    UNWIND rules as rule
+
+    // Tokens projecting the deleted grants are stale
+    OPTIONAL MATCH (grantee:Identity)-[:IS_GRANTED]->(:Grant:Rule)-[:GRANTS]->(rule)
+    FOREACH (_ in CASE WHEN grantee IS NULL THEN [] ELSE [1] END | SET grantee.grants_rev = coalesce(grantee.grants_rev, 0) + 1)
+
+    WITH DISTINCT rule
+
+    OPTIONAL MATCH (dependent:Rule)--(rule)
+    WHERE dependent:Grant OR dependent:Subscribe OR dependent:Consent
+
+    WITH collect(DISTINCT rule) as rules, collect(DISTINCT dependent) as dependents
+
+    FOREACH (n in dependents + rules | DETACH DELETE n)
+  `, cypCreateConsentHistory(CONSENT_HISTORY_WITHDRAWN))
+
+	logCypher(cypher, params)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return err
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
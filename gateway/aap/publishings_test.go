@@ -0,0 +1,85 @@
+package aap
+
+import (
+	"testing"
+)
+
+func createPublishAnswers() map[string][][]interface{} {
+	scope := fakeNode{"name": "read:things"}
+	publisher := fakeNode{"id": "publisher-id"}
+
+	return map[string][][]interface{}{
+		"create scope and match it to the identity who created it": {{scope}},
+		"Create publishing": {{publisher, fakeNode{"title": "Read things", "description": "Read the things"}, scope, fakeNode{"name": "0:mg:read:things"}}},
+		"CreateGrants":      {{fakeNode{"name": "0:mg:read:things"}, publisher, fakeNode{"id": "requestor-id"}, publisher, fakeNode{"nbf": int64(0), "exp": int64(0)}}},
+	}
+}
+
+func TestCreatePublishesStepUps(t *testing.T) {
+	tests := []struct {
+		name       string
+		rule       PublishRule
+		wantAcr    interface{}
+		wantAmr    interface{}
+		wantMaxAge interface{}
+	}{
+		{
+			name: "without step-up",
+			rule: PublishRule{Title: "Read things", Description: "Read the things"},
+		},
+		{
+			name:       "with step-up",
+			rule:       PublishRule{Title: "Read things", Description: "Read the things", Acr: []string{"loa2"}, Amr: []string{"otp"}, MaxAge: 300},
+			wantAcr:    []string{"loa2"},
+			wantAmr:    []string{"otp"},
+			wantMaxAge: int64(300),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := newFakeTx(createPublishAnswers())
+
+			publish, err := CreatePublishes(tx, Identity{Id: "requestor-id"}, Publish{
+				Publisher: Identity{Id: "publisher-id"},
+				Scope:     Scope{Name: "read:things"},
+				Rule:      tt.rule,
+			})
+			if err != nil {
+				t.Fatalf("CreatePublishes: %v", err)
+			}
+
+			if publish.Scope.Name != "read:things" {
+				t.Errorf("scope = %s, want read:things", publish.Scope.Name)
+			}
+
+			params := tx.run(t, "Create publishing").params
+			if !equalParam(params["acr"], tt.wantAcr) {
+				t.Errorf("acr = %v, want %v", params["acr"], tt.wantAcr)
+			}
+			if !equalParam(params["amr"], tt.wantAmr) {
+				t.Errorf("amr = %v, want %v", params["amr"], tt.wantAmr)
+			}
+			if !equalParam(params["max_age"], tt.wantMaxAge) {
+				t.Errorf("max_age = %v, want %v", params["max_age"], tt.wantMaxAge)
+			}
+		})
+	}
+}
+
+func equalParam(got interface{}, want interface{}) bool {
+	gotStrings, isStrings := got.([]string)
+	wantStrings, wantIsStrings := want.([]string)
+	if isStrings || wantIsStrings {
+		if len(gotStrings) != len(wantStrings) {
+			return false
+		}
+		for i := range gotStrings {
+			if gotStrings[i] != wantStrings[i] {
+				return false
+			}
+		}
+		return true
+	}
+	return got == want
+}
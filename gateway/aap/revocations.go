@@ -0,0 +1,140 @@
+package aap
+
+import (
+	"errors"
+	"fmt"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+func CreateRevocation(tx neo4j.Transaction, iRevocation Revocation) (rRevocation Revocation, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iRevocation.Jti == "" && iRevocation.Subject == "" && iRevocation.ClientId == "" {
+		return Revocation{}, errors.New("Missing iRevocation.Jti, iRevocation.Subject or iRevocation.ClientId")
+	}
+	params["jti"] = iRevocation.Jti
+	params["sub"] = iRevocation.Subject
+	params["client_id"] = iRevocation.ClientId
+
+	params["scopes"] = []string{}
+	if len(iRevocation.Scopes) > 0 {
+		params["scopes"] = iRevocation.Scopes
+	}
+
+	if iRevocation.Expire <= 0 {
+		return Revocation{}, errors.New("Missing iRevocation.Expire")
+	}
+	params["exp"] = iRevocation.Expire
+
+	cypher = fmt.Sprintf(`
+    // CreateRevocation
+
+    CREATE (r:Revocation {id:randomUUID(), jti:$jti, sub:$sub, client_id:$client_id, scopes:$scopes, revoked_at:datetime().epochSeconds, exp:$exp})
+
+    RETURN r
+  `)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return Revocation{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		revocationNode := record.GetByIndex(0)
+
+		if revocationNode != nil {
+			rRevocation = marshalNodeToRevocation(revocationNode.(neo4j.Node))
+		}
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return Revocation{}, err
+	}
+
+	return rRevocation, nil
+}
+
+// FetchRevocations returns all revocations that has not yet expired. Expired revocations cannot match a valid token.
+func FetchRevocations(tx neo4j.Transaction, iFilterRevocation Revocation) (rRevocations []Revocation, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	var cypFilterJti string
+	if iFilterRevocation.Jti != "" {
+		cypFilterJti = `and r.jti = $jti`
+		params["jti"] = iFilterRevocation.Jti
+	}
+
+	var cypFilterSubject string
+	if iFilterRevocation.Subject != "" {
+		cypFilterSubject = `and r.sub = $sub`
+		params["sub"] = iFilterRevocation.Subject
+	}
+
+	var cypFilterClientId string
+	if iFilterRevocation.ClientId != "" {
+		cypFilterClientId = `and r.client_id = $client_id`
+		params["client_id"] = iFilterRevocation.ClientId
+	}
+
+	cypher = fmt.Sprintf(`
+    // FetchRevocations
+
+    MATCH (r:Revocation)
+    WHERE r.exp > datetime().epochSeconds %s %s %s
+    RETURN r
+  `, cypFilterJti, cypFilterSubject, cypFilterClientId)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		revocationNode := record.GetByIndex(0)
+
+		if revocationNode != nil {
+			rRevocations = append(rRevocations, marshalNodeToRevocation(revocationNode.(neo4j.Node)))
+		}
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return rRevocations, nil
+}
+
+// DeleteExpiredRevocations removes revocations for tokens that has expired by now.
+func DeleteExpiredRevocations(tx neo4j.Transaction) (err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	cypher = fmt.Sprintf(`
+    // DeleteExpiredRevocations
+
+    MATCH (r:Revocation)
+    WHERE r.exp <= datetime().epochSeconds
+    DELETE r
+  `)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return err
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package aap
+
+import (
+	"testing"
+)
+
+func TestCreateRevocation(t *testing.T) {
+	tests := []struct {
+		name       string
+		revocation Revocation
+		wantScopes []string
+		wantErr    bool
+	}{
+		{
+			name:       "subject and client",
+			revocation: Revocation{Subject: "subject-id", ClientId: "client-id", Expire: 1},
+			wantScopes: []string{},
+		},
+		{
+			name:       "withdrawn scopes",
+			revocation: Revocation{Subject: "subject-id", ClientId: "client-id", Scopes: []string{"read:things"}, Expire: 1},
+			wantScopes: []string{"read:things"},
+		},
+		{
+			name:       "nothing to match",
+			revocation: Revocation{Scopes: []string{"read:things"}, Expire: 1},
+			wantErr:    true,
+		},
+		{
+			name:       "missing expire",
+			revocation: Revocation{Subject: "subject-id"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := newFakeTx(map[string][][]interface{}{
+				"CreateRevocation": {{fakeNode{"id": "revocation-id", "sub": "subject-id", "client_id": "client-id", "scopes": []interface{}{"read:things"}, "revoked_at": int64(1), "exp": int64(1)}}},
+			})
+
+			revocation, err := CreateRevocation(tx, tt.revocation)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if !equalParam(tx.run(t, "CreateRevocation").params["scopes"], tt.wantScopes) {
+				t.Errorf("scopes = %v, want %v", tx.run(t, "CreateRevocation").params["scopes"], tt.wantScopes)
+			}
+
+			if len(revocation.Scopes) != 1 || revocation.Scopes[0] != "read:things" {
+				t.Errorf("revocation scopes = %v, want [read:things]", revocation.Scopes)
+			}
+		})
+	}
+}
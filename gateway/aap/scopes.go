@@ -58,6 +58,85 @@ func CreateScope(tx neo4j.Transaction, iScope Scope, iRequest Identity) (rScope
 	return rScope, nil
 }
 
+// UpdateScope sets the metadata of a scope given in iUpdate, leaving the rest unchanged. The metadata is copied to the mg: and 0:mg: companion scopes created by CreateScope.
+func UpdateScope(tx neo4j.Transaction, iScope Scope, iUpdate ScopeUpdate) (rScope Scope, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params map[string]interface{}
+
+	if iScope.Name == "" {
+		return Scope{}, errors.New("Missing iScope.Name")
+	}
+
+	cypher = `
+    // UpdateScope
+
+    MATCH (scope:Scope {name: $name})
+    OPTIONAL MATCH (mgscope:Scope {name: "mg:"+$name})-[:MAY_GRANT]->(scope)
+    OPTIONAL MATCH (mmgscope:Scope {name: "0:mg:"+$name})-[:MAY_GRANT]->(mgscope)
+
+    // Null parameters are not given, keep the stored value
+    SET scope.title = coalesce($title, scope.title),
+        scope.description = coalesce($description, scope.description),
+        scope.sensitivity = coalesce($sensitivity, scope.sensitivity),
+        scope.deprecated = coalesce($deprecated, scope.deprecated, false)
+
+    FOREACH (companion in [n in [mgscope, mmgscope] WHERE n IS NOT NULL] |
+      SET companion.title = scope.title, companion.description = scope.description, companion.sensitivity = scope.sensitivity, companion.deprecated = scope.deprecated
+    )
+
+    // Conclude
+    return scope
+  `
+
+	params = map[string]interface{}{
+		"name":        iScope.Name,
+		"title":       nil,
+		"description": nil,
+		"sensitivity": nil,
+		"deprecated":  nil,
+	}
+
+	if iUpdate.Title != nil {
+		params["title"] = *iUpdate.Title
+	}
+
+	if iUpdate.Description != nil {
+		params["description"] = *iUpdate.Description
+	}
+
+	if iUpdate.Sensitivity != nil {
+		params["sensitivity"] = *iUpdate.Sensitivity
+	}
+
+	if iUpdate.Deprecated != nil {
+		params["deprecated"] = *iUpdate.Deprecated
+	}
+
+	logCypher(cypher, params)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return Scope{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+
+		scopeNode := record.GetByIndex(0)
+
+		if scopeNode != nil {
+			rScope = marshalNodeToScope(scopeNode.(neo4j.Node))
+		}
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return Scope{}, err
+	}
+
+	return rScope, nil
+}
+
 func FetchScopes(driver neo4j.Driver, inputScopes []Scope) ([]Scope, error) {
 	var err error
 	var session neo4j.Session
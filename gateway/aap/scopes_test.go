@@ -0,0 +1,76 @@
+package aap
+
+import (
+	"testing"
+)
+
+func TestUpdateScopePartial(t *testing.T) {
+	title := "Read things"
+	description := "Read the things"
+	sensitivity := "high"
+	deprecated := true
+
+	tests := []struct {
+		name   string
+		update ScopeUpdate
+		want   map[string]interface{}
+	}{
+		{
+			name:   "nothing",
+			update: ScopeUpdate{},
+			want:   map[string]interface{}{"title": nil, "description": nil, "sensitivity": nil, "deprecated": nil},
+		},
+		{
+			name:   "title only",
+			update: ScopeUpdate{Title: &title},
+			want:   map[string]interface{}{"title": title, "description": nil, "sensitivity": nil, "deprecated": nil},
+		},
+		{
+			name:   "deprecated only",
+			update: ScopeUpdate{Deprecated: &deprecated},
+			want:   map[string]interface{}{"title": nil, "description": nil, "sensitivity": nil, "deprecated": true},
+		},
+		{
+			name:   "all",
+			update: ScopeUpdate{Title: &title, Description: &description, Sensitivity: &sensitivity, Deprecated: &deprecated},
+			want:   map[string]interface{}{"title": title, "description": description, "sensitivity": sensitivity, "deprecated": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := newFakeTx(map[string][][]interface{}{
+				"UpdateScope": {{fakeNode{"name": "read:things", "title": "Read things"}}},
+			})
+
+			scope, err := UpdateScope(tx, Scope{Name: "read:things"}, tt.update)
+			if err != nil {
+				t.Fatalf("UpdateScope: %v", err)
+			}
+
+			if scope.Name != "read:things" {
+				t.Errorf("scope = %s, want read:things", scope.Name)
+			}
+
+			params := tx.run(t, "UpdateScope").params
+			for key, want := range tt.want {
+				if params[key] != want {
+					t.Errorf("%s = %v, want %v", key, params[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateScopeMissingName(t *testing.T) {
+	tx := newFakeTx(nil)
+
+	_, err := UpdateScope(tx, Scope{}, ScopeUpdate{})
+	if err == nil {
+		t.Error("expected error on missing name")
+	}
+
+	if len(tx.runs) > 0 {
+		t.Error("expected no statements run")
+	}
+}
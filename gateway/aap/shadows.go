@@ -95,10 +95,10 @@ func DeleteShadow(tx neo4j.Transaction, iShadow Shadow) (err error) {
 	cypher = fmt.Sprintf(`
     // DeleteShadows
 
-    MATCH (identity:Identity {id:$id})
-    MATCH (shadow:Identity {id:$shadow}
+    MATCH (identity:Identity {id:$identity})
+    MATCH (shadow:Identity {id:$shadow})
 
-    MATCH (identity)-[:IS_GRANTED]-(gr:Grant:Rule)-[:GRANTS]->(shadow)
+    MATCH (identity)-[:IS_GRANTED]->(gr:Grant:Rule)-[:GRANTS]->(shadow)
 
     DETACH DELETE gr
   `)
@@ -26,6 +26,8 @@ func CreateSubscription(tx neo4j.Transaction, iSubscription Subscription, iReque
 		return Subscription{}, errors.New("Missing iSubscription.Scope.Name")
 	}
 	params["scope"] = iSubscription.Scope.Name
+	params["required"] = iSubscription.SubscribeRule.Required
+	params["trusted"] = iSubscription.SubscribeRule.Trusted
 
 	cypher = fmt.Sprintf(`
     // Subscribe to a publish rule
@@ -44,12 +46,21 @@ func CreateSubscription(tx neo4j.Transaction, iSubscription Subscription, iReque
 
     OPTIONAL MATCH (subscriber)-[:SUBSCRIBES]-(existingSr:Subscribe:Rule)-[:SUBSCRIBES]->(pr)
 
+    // Subscribing again keeps an approval, but a rejected subscription must be approved again.
+    WITH publisher, subscriber, scope, pr, existingSr,
+      CASE
+        WHEN coalesce(pr.approval_required, false) = false THEN {state:"approved", decided_by:null, decided_at:null}
+        WHEN existingSr IS NOT NULL AND coalesce(existingSr.state, "approved") = "approved" THEN {state:"approved", decided_by:existingSr.decided_by, decided_at:existingSr.decided_at}
+        ELSE {state:"pending", decided_by:null, decided_at:null}
+      END as approval
+
     DETACH DELETE existingSr
 
     // Make the connection
-    MERGE (subscriber)-[:SUBSCRIBES]-(sr:Subscribe:Rule)-[:SUBSCRIBES]->(pr)
+    MERGE (subscriber)-[:SUBSCRIBES]-(sr:Subscribe:Rule {required:$required, trusted:$trusted, state:approval.state})-[:SUBSCRIBES]->(pr)
+    SET sr.decided_by = approval.decided_by, sr.decided_at = approval.decided_at
 
-    RETURN subscriber, publisher, scope
+    RETURN subscriber, publisher, scope, sr
   `)
 
 	logCypher(cypher, params)
@@ -63,6 +74,7 @@ func CreateSubscription(tx neo4j.Transaction, iSubscription Subscription, iReque
 		subscriberNode := record.GetByIndex(0)
 		publisherNode := record.GetByIndex(1)
 		scopeNode := record.GetByIndex(2)
+		subscribeRuleNode := record.GetByIndex(3)
 
 		if subscriberNode != nil {
 			rSubscription.Subscriber = marshalNodeToIdentity(subscriberNode.(neo4j.Node))
@@ -73,6 +85,9 @@ func CreateSubscription(tx neo4j.Transaction, iSubscription Subscription, iReque
 		if scopeNode != nil {
 			rSubscription.Scope = marshalNodeToScope(scopeNode.(neo4j.Node))
 		}
+		if subscribeRuleNode != nil {
+			rSubscription.SubscribeRule = marshalNodeToSubscribeRule(subscribeRuleNode.(neo4j.Node))
+		}
 
 	} else {
 		return Subscription{}, errors.New("Unable to create Subscription")
@@ -86,6 +101,85 @@ func CreateSubscription(tx neo4j.Transaction, iSubscription Subscription, iReque
 	return rSubscription, nil
 }
 
+// DeleteSubscription removes the subscribe rule between subscriber and the publish rule of the scope.
+// Consents given to the subscriber on the publish rule are only removed if iDeleteConsents is true.
+func DeleteSubscription(tx neo4j.Transaction, iSubscription Subscription, iDeleteConsents bool) (rSubscription Subscription, rDeletedConsents int64, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iSubscription.Subscriber.Id == "" {
+		return Subscription{}, 0, errors.New("Missing iSubscription.Subscriber.Id")
+	}
+	params["subscriber_id"] = iSubscription.Subscriber.Id
+
+	if iSubscription.Publisher.Id == "" {
+		return Subscription{}, 0, errors.New("Missing iSubscription.Publisher.Id")
+	}
+	params["publisher_id"] = iSubscription.Publisher.Id
+
+	if iSubscription.Scope.Name == "" {
+		return Subscription{}, 0, errors.New("Missing iSubscription.Scope.Name")
+	}
+	params["scope"] = iSubscription.Scope.Name
+
+	params["delete_consents"] = iDeleteConsents
+
+	cypher = fmt.Sprintf(`
+    // Unsubscribe from a publish rule
+
+    MATCH (publisher:Identity {id:$publisher_id})-[:PUBLISH]->(pr:Publish:Rule)-[:PUBLISH]->(scope:Scope {name:$scope})
+    MATCH (subscriber:Identity {id:$subscriber_id})-[:SUBSCRIBES]->(sr:Subscribe:Rule)-[:SUBSCRIBES]->(pr)
+
+    // Consents given to the subscriber on the publish rule
+    OPTIONAL MATCH (owner:Identity)-[:CONSENT]->(cr:Consent:Rule)-[:CONSENT]->(pr)
+    WHERE $delete_consents = true AND (cr)-[:CONSENT]->(subscriber)
+
+    // The consents are withdrawn with the subscription
+    FOREACH (_ in CASE WHEN cr IS NULL THEN [] ELSE [1] END | %s)
+
+    WITH subscriber, publisher, scope, collect(DISTINCT sr) as srs, collect(DISTINCT cr) as crs
+
+    FOREACH (n in srs + crs | DETACH DELETE n)
+
+    RETURN subscriber, publisher, scope, size(crs)
+  `, cypCreateConsentHistory(CONSENT_HISTORY_WITHDRAWN))
+
+	logCypher(cypher, params)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return Subscription{}, 0, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		subscriberNode := record.GetByIndex(0)
+		publisherNode := record.GetByIndex(1)
+		scopeNode := record.GetByIndex(2)
+		deletedConsents := record.GetByIndex(3)
+
+		if subscriberNode != nil {
+			rSubscription.Subscriber = marshalNodeToIdentity(subscriberNode.(neo4j.Node))
+		}
+		if publisherNode != nil {
+			rSubscription.Publisher = marshalNodeToIdentity(publisherNode.(neo4j.Node))
+		}
+		if scopeNode != nil {
+			rSubscription.Scope = marshalNodeToScope(scopeNode.(neo4j.Node))
+		}
+		if deletedConsents != nil {
+			rDeletedConsents = deletedConsents.(int64)
+		}
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return Subscription{}, 0, err
+	}
+
+	return rSubscription, rDeletedConsents, nil
+}
+
 func FetchSubscriptions(tx neo4j.Transaction, iFilterSubscriber Identity, iFilterPublisher Identity, iFilterScopes []Scope) (rSubscriptions []Subscription, err error) {
 	var result neo4j.Result
 	var cypher string
@@ -124,7 +218,7 @@ func FetchSubscriptions(tx neo4j.Transaction, iFilterSubscriber Identity, iFilte
     WHERE 1=1 %s
     MATCH (publisher:Identity %s)-[:PUBLISH]->(pr)
 
-    RETURN subscriber, publisher, scope
+    RETURN subscriber, publisher, scope, sr
   `, filterSubscriberCypher, filterScopesCypher, filterPublisherCypher)
 
 	logCypher(cypher, params)
@@ -138,6 +232,7 @@ func FetchSubscriptions(tx neo4j.Transaction, iFilterSubscriber Identity, iFilte
 		subscriberNode := record.GetByIndex(0)
 		publisherNode := record.GetByIndex(1)
 		scopeNode := record.GetByIndex(2)
+		subscribeRuleNode := record.GetByIndex(3)
 
 		var rSubscription Subscription
 
@@ -150,6 +245,9 @@ func FetchSubscriptions(tx neo4j.Transaction, iFilterSubscriber Identity, iFilte
 		if scopeNode != nil {
 			rSubscription.Scope = marshalNodeToScope(scopeNode.(neo4j.Node))
 		}
+		if subscribeRuleNode != nil {
+			rSubscription.SubscribeRule = marshalNodeToSubscribeRule(subscribeRuleNode.(neo4j.Node))
+		}
 
 		rSubscriptions = append(rSubscriptions, rSubscription)
 	}
@@ -161,3 +259,80 @@ func FetchSubscriptions(tx neo4j.Transaction, iFilterSubscriber Identity, iFilte
 
 	return rSubscriptions, nil
 }
+
+// UpdateSubscriptionState approves or rejects the subscription on behalf of the publisher.
+func UpdateSubscriptionState(tx neo4j.Transaction, iSubscription Subscription, iState string, iDecidedBy Identity) (rSubscription Subscription, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iSubscription.Subscriber.Id == "" {
+		return Subscription{}, errors.New("Missing iSubscription.Subscriber.Id")
+	}
+	params["subscriber_id"] = iSubscription.Subscriber.Id
+
+	if iSubscription.Publisher.Id == "" {
+		return Subscription{}, errors.New("Missing iSubscription.Publisher.Id")
+	}
+	params["publisher_id"] = iSubscription.Publisher.Id
+
+	if iSubscription.Scope.Name == "" {
+		return Subscription{}, errors.New("Missing iSubscription.Scope.Name")
+	}
+	params["scope"] = iSubscription.Scope.Name
+
+	if iState != SUBSCRIPTION_APPROVED && iState != SUBSCRIPTION_REJECTED {
+		return Subscription{}, errors.New("Invalid iState, must be approved or rejected")
+	}
+	params["state"] = iState
+
+	if iDecidedBy.Id == "" {
+		return Subscription{}, errors.New("Missing iDecidedBy.Id")
+	}
+	params["decided_by"] = iDecidedBy.Id
+
+	cypher = fmt.Sprintf(`
+    // Approve or reject a subscription
+
+    MATCH (publisher:Identity {id:$publisher_id})-[:PUBLISH]->(pr:Publish:Rule)-[:PUBLISH]->(scope:Scope {name:$scope})
+    MATCH (subscriber:Identity {id:$subscriber_id})-[:SUBSCRIBES]->(sr:Subscribe:Rule)-[:SUBSCRIBES]->(pr)
+
+    SET sr.state = $state, sr.decided_by = $decided_by, sr.decided_at = datetime().epochSeconds
+
+    RETURN subscriber, publisher, scope, sr
+  `)
+
+	logCypher(cypher, params)
+
+	if result, err = tx.Run(cypher, params); err != nil {
+		return Subscription{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		subscriberNode := record.GetByIndex(0)
+		publisherNode := record.GetByIndex(1)
+		scopeNode := record.GetByIndex(2)
+		subscribeRuleNode := record.GetByIndex(3)
+
+		if subscriberNode != nil {
+			rSubscription.Subscriber = marshalNodeToIdentity(subscriberNode.(neo4j.Node))
+		}
+		if publisherNode != nil {
+			rSubscription.Publisher = marshalNodeToIdentity(publisherNode.(neo4j.Node))
+		}
+		if scopeNode != nil {
+			rSubscription.Scope = marshalNodeToScope(scopeNode.(neo4j.Node))
+		}
+		if subscribeRuleNode != nil {
+			rSubscription.SubscribeRule = marshalNodeToSubscribeRule(subscribeRuleNode.(neo4j.Node))
+		}
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return Subscription{}, err
+	}
+
+	return rSubscription, nil
+}
@@ -0,0 +1,113 @@
+package aap
+
+import (
+	"testing"
+)
+
+func subscriptionRow(state interface{}, decidedBy interface{}) []interface{} {
+	sr := fakeNode{"required": false, "trusted": false}
+	if state != nil {
+		sr["state"] = state
+	}
+	if decidedBy != nil {
+		sr["decided_by"] = decidedBy
+		sr["decided_at"] = int64(100)
+	}
+	return []interface{}{fakeNode{"id": "subscriber-id"}, fakeNode{"id": "publisher-id"}, fakeNode{"name": "read"}, sr}
+}
+
+func TestUpdateSubscriptionState(t *testing.T) {
+	subscription := Subscription{Subscriber: Identity{Id: "subscriber-id"}, Publisher: Identity{Id: "publisher-id"}, Scope: Scope{Name: "read"}}
+	decidedBy := Identity{Id: "approver-id"}
+
+	tests := []struct {
+		name         string
+		subscription Subscription
+		state        string
+		decidedBy    Identity
+		rows         [][]interface{}
+		wantState    string
+		wantFound    bool
+		wantErr      bool
+	}{
+		{name: "approve", subscription: subscription, state: SUBSCRIPTION_APPROVED, decidedBy: decidedBy, rows: [][]interface{}{subscriptionRow(SUBSCRIPTION_APPROVED, "approver-id")}, wantState: SUBSCRIPTION_APPROVED, wantFound: true},
+		{name: "reject", subscription: subscription, state: SUBSCRIPTION_REJECTED, decidedBy: decidedBy, rows: [][]interface{}{subscriptionRow(SUBSCRIPTION_REJECTED, "approver-id")}, wantState: SUBSCRIPTION_REJECTED, wantFound: true},
+		{name: "unknown subscription", subscription: subscription, state: SUBSCRIPTION_APPROVED, decidedBy: decidedBy},
+		{name: "back to pending", subscription: subscription, state: SUBSCRIPTION_PENDING, decidedBy: decidedBy, wantErr: true},
+		{name: "unknown state", subscription: subscription, state: "maybe", decidedBy: decidedBy, wantErr: true},
+		{name: "missing decided by", subscription: subscription, state: SUBSCRIPTION_APPROVED, wantErr: true},
+		{name: "missing scope", subscription: Subscription{Subscriber: subscription.Subscriber, Publisher: subscription.Publisher}, state: SUBSCRIPTION_APPROVED, decidedBy: decidedBy, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := newFakeTx(map[string][][]interface{}{"Approve or reject a subscription": tt.rows})
+
+			rSubscription, err := UpdateSubscriptionState(tx, tt.subscription, tt.state, tt.decidedBy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if len(tx.runs) > 0 {
+					t.Error("statement run on invalid input")
+				}
+				return
+			}
+
+			params := tx.run(t, "Approve or reject a subscription").params
+			if params["state"] != tt.state || params["decided_by"] != tt.decidedBy.Id {
+				t.Errorf("params = %v", params)
+			}
+
+			// Unknown subscriptions are returned empty
+			if found := rSubscription.Subscriber.Id != ""; found != tt.wantFound {
+				t.Fatalf("found = %v, want %v", found, tt.wantFound)
+			}
+			if !tt.wantFound {
+				return
+			}
+
+			if rSubscription.SubscribeRule.State != tt.wantState {
+				t.Errorf("state = %s, want %s", rSubscription.SubscribeRule.State, tt.wantState)
+			}
+
+			if rSubscription.SubscribeRule.DecidedBy.Id != "approver-id" || rSubscription.SubscribeRule.DecidedAt != 100 {
+				t.Errorf("decided by %s at %d, want approver-id at 100", rSubscription.SubscribeRule.DecidedBy.Id, rSubscription.SubscribeRule.DecidedAt)
+			}
+		})
+	}
+}
+
+func TestCreateSubscriptionState(t *testing.T) {
+	subscription := Subscription{Subscriber: Identity{Id: "subscriber-id"}, Publisher: Identity{Id: "publisher-id"}, Scope: Scope{Name: "read"}}
+
+	tests := []struct {
+		name      string
+		rows      [][]interface{}
+		wantState string
+		wantErr   bool
+	}{
+		{name: "approval required", rows: [][]interface{}{subscriptionRow(SUBSCRIPTION_PENDING, nil)}, wantState: SUBSCRIPTION_PENDING},
+		{name: "approval kept on subscribing again", rows: [][]interface{}{subscriptionRow(SUBSCRIPTION_APPROVED, "approver-id")}, wantState: SUBSCRIPTION_APPROVED},
+		{name: "subscribed before approval was introduced", rows: [][]interface{}{subscriptionRow(nil, nil)}, wantState: SUBSCRIPTION_APPROVED},
+		{name: "publishing not found", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := newFakeTx(map[string][][]interface{}{"Subscribe to a publish rule": tt.rows})
+
+			rSubscription, err := CreateSubscription(tx, subscription, Identity{Id: "requestor-id"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if rSubscription.SubscribeRule.State != tt.wantState {
+				t.Errorf("state = %s, want %s", rSubscription.SubscribeRule.State, tt.wantState)
+			}
+		})
+	}
+}
@@ -0,0 +1,464 @@
+package aap
+
+import (
+	"errors"
+	"fmt"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+	"strings"
+)
+
+// CreateWebhook registers a webhook on behalf of the publisher in iWebhook.Publisher. Only events concerning the publisher are delivered to it.
+func CreateWebhook(tx neo4j.Transaction, iWebhook Webhook) (rWebhook Webhook, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iWebhook.Url == "" {
+		return Webhook{}, errors.New("Missing iWebhook.Url")
+	}
+	params["url"] = iWebhook.Url
+
+	if len(iWebhook.Events) <= 0 {
+		return Webhook{}, errors.New("Missing iWebhook.Events")
+	}
+	params["events"] = iWebhook.Events
+
+	if iWebhook.Secret == "" {
+		return Webhook{}, errors.New("Missing iWebhook.Secret")
+	}
+	params["secret"] = iWebhook.Secret
+
+	if iWebhook.Publisher.Id == "" {
+		return Webhook{}, errors.New("Missing iWebhook.Publisher.Id")
+	}
+	params["publisher"] = iWebhook.Publisher.Id
+
+	cypher = fmt.Sprintf(`
+    // CreateWebhook
+
+    MATCH (publisher:Identity {id:$publisher})
+    CREATE (w:Webhook {id:randomUUID(), url:$url, events:$events, secret:$secret, created_at:datetime().epochSeconds})-[:ON_BEHALF_OF]->(publisher)
+
+    RETURN w, publisher
+  `)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return Webhook{}, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		webhookNode := record.GetByIndex(0)
+		publisherNode := record.GetByIndex(1)
+
+		if webhookNode != nil {
+			rWebhook = marshalNodeToWebhook(webhookNode.(neo4j.Node))
+
+			if publisherNode != nil {
+				rWebhook.Publisher = marshalNodeToIdentity(publisherNode.(neo4j.Node))
+			}
+		}
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return Webhook{}, err
+	}
+
+	return rWebhook, nil
+}
+
+// FetchWebhooks returns the webhooks with the publisher they are registered on behalf of. Webhooks registered before publishers were required have none and receive no deliveries.
+func FetchWebhooks(tx neo4j.Transaction, iFilterWebhooks []Webhook) (rWebhooks []Webhook, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	var cypFilterWebhooks string
+	if len(iFilterWebhooks) > 0 {
+		var filterWebhooks []string
+		for _, e := range iFilterWebhooks {
+			if e.Id == "" {
+				continue
+			}
+
+			filterWebhooks = append(filterWebhooks, e.Id)
+		}
+
+		if len(filterWebhooks) > 0 {
+			cypFilterWebhooks = `and w.id in split($filterWebhooks, ",")`
+			params["filterWebhooks"] = strings.Join(filterWebhooks, ",")
+		}
+	}
+
+	cypher = fmt.Sprintf(`
+    // FetchWebhooks
+
+    MATCH (w:Webhook)
+    WHERE 1=1 %s
+    OPTIONAL MATCH (w)-[:ON_BEHALF_OF]->(publisher:Identity)
+    RETURN w, publisher
+    ORDER BY w.created_at
+  `, cypFilterWebhooks)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		webhookNode := record.GetByIndex(0)
+		publisherNode := record.GetByIndex(1)
+
+		if webhookNode != nil {
+			webhook := marshalNodeToWebhook(webhookNode.(neo4j.Node))
+
+			if publisherNode != nil {
+				webhook.Publisher = marshalNodeToIdentity(publisherNode.(neo4j.Node))
+			}
+
+			rWebhooks = append(rWebhooks, webhook)
+		}
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return rWebhooks, nil
+}
+
+// DeleteWebhook removes the webhook and all of its deliveries.
+func DeleteWebhook(tx neo4j.Transaction, iWebhook Webhook) (err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iWebhook.Id == "" {
+		return errors.New("Missing iWebhook.Id")
+	}
+	params["id"] = iWebhook.Id
+
+	cypher = fmt.Sprintf(`
+    // DeleteWebhook
+
+    MATCH (w:Webhook {id:$id})
+    OPTIONAL MATCH (w)-[:DELIVERS]->(d:Delivery)
+    OPTIONAL MATCH (d)-[:ATTEMPTED]->(a:DeliveryAttempt)
+    DETACH DELETE a, d, w
+  `)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return err
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateWebhookDelivery queues an event for delivery. Events are delivered once per webhook, redelivered events are ignored.
+func CreateWebhookDelivery(tx neo4j.Transaction, iWebhook Webhook, iEvent OutboxEvent) (err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iWebhook.Id == "" {
+		return errors.New("Missing iWebhook.Id")
+	}
+	params["webhook"] = iWebhook.Id
+
+	if iEvent.Id == "" {
+		return errors.New("Missing iEvent.Id")
+	}
+	params["event_id"] = iEvent.Id
+	params["event_type"] = iEvent.Type
+	params["payload"] = iEvent.Payload
+	params["pending"] = WEBHOOK_DELIVERY_PENDING
+
+	cypher = fmt.Sprintf(`
+    // CreateWebhookDelivery
+
+    MATCH (w:Webhook {id:$webhook})
+    MERGE (w)-[:DELIVERS]->(d:Delivery {event_id:$event_id})
+    ON CREATE SET d.id = randomUUID(), d.event_type = $event_type, d.payload = $payload, d.status = $pending, d.attempts = 0, d.next_attempt_at = datetime().epochSeconds, d.created_at = datetime().epochSeconds
+  `)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return err
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ClaimPendingWebhookDeliveries returns the oldest deliveries due for an attempt and leases them until iLeaseUntil, so the attempts can be made outside the transaction without other dispatchers picking them up.
+// Deliveries of a dispatcher dying before recording its attempts are due again when the lease runs out.
+func ClaimPendingWebhookDeliveries(tx neo4j.Transaction, iLimit int64, iLeaseUntil int64) (rDeliveries []WebhookDelivery, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	params["limit"] = iLimit
+	params["lease_until"] = iLeaseUntil
+	params["pending"] = WEBHOOK_DELIVERY_PENDING
+
+	cypher = fmt.Sprintf(`
+    // ClaimPendingWebhookDeliveries
+
+    MATCH (w:Webhook)-[:DELIVERS]->(d:Delivery {status:$pending})
+    WHERE d.next_attempt_at <= datetime().epochSeconds
+    WITH w, d
+    ORDER BY d.created_at
+    LIMIT $limit
+    SET d.next_attempt_at = $lease_until
+    RETURN w, d
+  `)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		webhookNode := record.GetByIndex(0)
+		deliveryNode := record.GetByIndex(1)
+
+		if webhookNode == nil || deliveryNode == nil {
+			continue
+		}
+
+		delivery := marshalNodeToWebhookDelivery(deliveryNode.(neo4j.Node))
+		delivery.Webhook = marshalNodeToWebhook(webhookNode.(neo4j.Node))
+		rDeliveries = append(rDeliveries, delivery)
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return rDeliveries, nil
+}
+
+// CreateWebhookDeliveryAttempt logs an attempt and moves the delivery to its next status.
+func CreateWebhookDeliveryAttempt(tx neo4j.Transaction, iDelivery WebhookDelivery, iAttempt WebhookDeliveryAttempt, iStatus string, iNextAttemptAt int64) (err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iDelivery.Id == "" {
+		return errors.New("Missing iDelivery.Id")
+	}
+	params["id"] = iDelivery.Id
+
+	if iStatus == "" {
+		return errors.New("Missing iStatus")
+	}
+	params["status"] = iStatus
+	params["next_attempt_at"] = iNextAttemptAt
+
+	params["at"] = iAttempt.At
+	params["status_code"] = iAttempt.StatusCode
+	params["error"] = iAttempt.Error
+	params["duration"] = iAttempt.Duration
+
+	cypher = fmt.Sprintf(`
+    // CreateWebhookDeliveryAttempt
+
+    MATCH (d:Delivery {id:$id})
+    SET d.attempts = d.attempts + 1, d.status = $status, d.next_attempt_at = $next_attempt_at, d.updated_at = $at
+    CREATE (d)-[:ATTEMPTED]->(:DeliveryAttempt {at:$at, status_code:$status_code, error:$error, duration:$duration})
+  `)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return err
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// FetchWebhookDeliveries returns deliveries with their attempts, newest first.
+func FetchWebhookDeliveries(tx neo4j.Transaction, iWebhook Webhook, iFilterStatus string) (rDeliveries []WebhookDelivery, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	var cypFilterWebhook string
+	if iWebhook.Id != "" {
+		cypFilterWebhook = `and w.id = $webhook`
+		params["webhook"] = iWebhook.Id
+	}
+
+	var cypFilterStatus string
+	if iFilterStatus != "" {
+		cypFilterStatus = `and d.status = $status`
+		params["status"] = iFilterStatus
+	}
+
+	cypher = fmt.Sprintf(`
+    // FetchWebhookDeliveries
+
+    MATCH (w:Webhook)-[:DELIVERS]->(d:Delivery)
+    WHERE 1=1 %s %s
+    OPTIONAL MATCH (d)-[:ATTEMPTED]->(a:DeliveryAttempt)
+    WITH w, d, a ORDER BY a.at
+    RETURN w, d, collect(a)
+    ORDER BY d.created_at DESC
+  `, cypFilterWebhook, cypFilterStatus)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return nil, err
+	}
+
+	for result.Next() {
+		record := result.Record()
+		webhookNode := record.GetByIndex(0)
+		deliveryNode := record.GetByIndex(1)
+		attemptNodes := record.GetByIndex(2)
+
+		if webhookNode == nil || deliveryNode == nil {
+			continue
+		}
+
+		delivery := marshalNodeToWebhookDelivery(deliveryNode.(neo4j.Node))
+		delivery.Webhook = marshalNodeToWebhook(webhookNode.(neo4j.Node))
+
+		if attemptNodes != nil {
+			for _, n := range attemptNodes.([]interface{}) {
+				delivery.AttemptLog = append(delivery.AttemptLog, marshalNodeToWebhookDeliveryAttempt(n.(neo4j.Node)))
+			}
+		}
+
+		rDeliveries = append(rDeliveries, delivery)
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return rDeliveries, nil
+}
+
+// ReplayWebhookDeliveries moves dead deliveries back to pending. The attempt log is kept.
+func ReplayWebhookDeliveries(tx neo4j.Transaction, iWebhook Webhook, iDeliveries []WebhookDelivery) (rReplayed int64, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iWebhook.Id == "" {
+		return 0, errors.New("Missing iWebhook.Id")
+	}
+	params["webhook"] = iWebhook.Id
+	params["dead"] = WEBHOOK_DELIVERY_DEAD
+	params["pending"] = WEBHOOK_DELIVERY_PENDING
+
+	var cypFilterDeliveries string
+	if len(iDeliveries) > 0 {
+		var filterDeliveries []string
+		for _, e := range iDeliveries {
+			if e.Id == "" {
+				continue
+			}
+
+			filterDeliveries = append(filterDeliveries, e.Id)
+		}
+
+		if len(filterDeliveries) > 0 {
+			cypFilterDeliveries = `and d.id in split($filterDeliveries, ",")`
+			params["filterDeliveries"] = strings.Join(filterDeliveries, ",")
+		}
+	}
+
+	cypher = fmt.Sprintf(`
+    // ReplayWebhookDeliveries
+
+    MATCH (w:Webhook {id:$webhook})-[:DELIVERS]->(d:Delivery {status:$dead})
+    WHERE 1=1 %s
+    SET d.status = $pending, d.attempts = 0, d.next_attempt_at = datetime().epochSeconds
+    RETURN count(d)
+  `, cypFilterDeliveries)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return 0, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		count := record.GetByIndex(0)
+		if count != nil {
+			rReplayed = count.(int64)
+		}
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return 0, err
+	}
+
+	return rReplayed, nil
+}
+
+// DeleteWebhookDeliveries prunes deliveries with status iStatus and their attempts, when last attempted before iBefore.
+func DeleteWebhookDeliveries(tx neo4j.Transaction, iStatus string, iBefore int64, iLimit int64) (rDeleted int64, err error) {
+	var result neo4j.Result
+	var cypher string
+	var params = make(map[string]interface{})
+
+	if iStatus == "" {
+		return 0, errors.New("Missing iStatus")
+	}
+	params["status"] = iStatus
+	params["before"] = iBefore
+	params["limit"] = iLimit
+
+	cypher = fmt.Sprintf(`
+    // DeleteWebhookDeliveries
+
+    MATCH (d:Delivery {status:$status})
+    WHERE coalesce(d.updated_at, d.created_at) < $before
+    WITH d LIMIT $limit
+    OPTIONAL MATCH (d)-[:ATTEMPTED]->(a:DeliveryAttempt)
+    DETACH DELETE a, d
+    RETURN count(DISTINCT d)
+  `)
+
+	logCypher(cypher, params)
+	if result, err = tx.Run(cypher, params); err != nil {
+		return 0, err
+	}
+
+	if result.Next() {
+		record := result.Record()
+		rDeleted = record.GetByIndex(0).(int64)
+	}
+
+	// Check if we encountered any error during record streaming
+	if err = result.Err(); err != nil {
+		return 0, err
+	}
+
+	return rDeleted, nil
+}
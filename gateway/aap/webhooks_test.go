@@ -0,0 +1,73 @@
+package aap
+
+import (
+	"testing"
+)
+
+func TestCreateWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		webhook Webhook
+		wantErr bool
+	}{
+		{
+			name:    "on behalf of publisher",
+			webhook: Webhook{Url: "https://example.com/hook", Events: []string{"*"}, Secret: "secret", Publisher: Identity{Id: "publisher-id"}},
+		},
+		{
+			name:    "missing publisher",
+			webhook: Webhook{Url: "https://example.com/hook", Events: []string{"*"}, Secret: "secret"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := newFakeTx(map[string][][]interface{}{
+				"CreateWebhook": {{fakeNode{"id": "webhook-id", "url": "https://example.com/hook", "events": []interface{}{"*"}}, fakeNode{"id": "publisher-id"}}},
+			})
+
+			webhook, err := CreateWebhook(tx, tt.webhook)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if publisher := tx.run(t, "CreateWebhook").params["publisher"]; publisher != "publisher-id" {
+				t.Errorf("publisher param = %v, want publisher-id", publisher)
+			}
+
+			if webhook.Publisher.Id != "publisher-id" {
+				t.Errorf("publisher = %s, want publisher-id", webhook.Publisher.Id)
+			}
+		})
+	}
+}
+
+func TestFetchWebhooksPublisher(t *testing.T) {
+	tx := newFakeTx(map[string][][]interface{}{
+		"FetchWebhooks": {
+			{fakeNode{"id": "owned-id", "url": "https://example.com/owned"}, fakeNode{"id": "publisher-id"}},
+			{fakeNode{"id": "legacy-id", "url": "https://example.com/legacy"}, nil},
+		},
+	})
+
+	webhooks, err := FetchWebhooks(tx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(webhooks) != 2 {
+		t.Fatalf("webhooks = %d, want 2", len(webhooks))
+	}
+
+	if webhooks[0].Publisher.Id != "publisher-id" {
+		t.Errorf("publisher = %s, want publisher-id", webhooks[0].Publisher.Id)
+	}
+
+	if webhooks[1].Publisher.Id != "" {
+		t.Errorf("publisher of webhook registered without one = %s, want none", webhooks[1].Publisher.Id)
+	}
+}
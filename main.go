@@ -19,13 +19,16 @@ import (
 	"github.com/opensentry/aap/app"
 	"github.com/opensentry/aap/config"
 
+	"github.com/opensentry/aap/endpoints/apps"
 	"github.com/opensentry/aap/endpoints/consents"
 	"github.com/opensentry/aap/endpoints/entities"
 	"github.com/opensentry/aap/endpoints/grants"
 	"github.com/opensentry/aap/endpoints/publishings"
+	"github.com/opensentry/aap/endpoints/revocations"
 	"github.com/opensentry/aap/endpoints/scopes"
 	"github.com/opensentry/aap/endpoints/shadows"
 	"github.com/opensentry/aap/endpoints/subscriptions"
+	"github.com/opensentry/aap/endpoints/webhooks"
 	"github.com/opensentry/aap/migration"
 
 	E "github.com/opensentry/aap/client/errors"
@@ -167,7 +170,50 @@ func main() {
 			IdTokenKey:     IdTokenKey,
 			RequestIdKey:   RequestIdKey,
 		},
-		Nats: natsConnection,
+		Nats:         natsConnection,
+		Revocations:  app.NewRevocationList(),
+		VerdictCache: app.NewVerdictCache(),
+		Outbox:       app.NewOutboxRelay(),
+		Webhooks:     app.NewWebhookDispatcher(),
+		Usage:        app.NewUsageTracker(),
+	}
+
+	if *optServe {
+		err = app.LoadRevocations(env)
+		if err != nil {
+			log.WithFields(appFields).Panic(err.Error())
+			return
+		}
+
+		revocationSubscription, err := app.SubscribeToRevocations(env)
+		if err != nil {
+			log.WithFields(appFields).Panic(err.Error())
+			return
+		}
+		defer revocationSubscription.Unsubscribe()
+
+		verdictSubscription, err := app.SubscribeToVerdictInvalidations(env)
+		if err != nil {
+			log.WithFields(appFields).Panic(err.Error())
+			return
+		}
+		defer verdictSubscription.Unsubscribe()
+
+		stopOutboxRelay := make(chan struct{})
+		go env.Outbox.Run(env, log.WithFields(appFields).WithFields(logrus.Fields{"func": "OutboxRelay"}), stopOutboxRelay)
+		defer close(stopOutboxRelay)
+
+		stopWebhookDispatcher := make(chan struct{})
+		go env.Webhooks.Run(env, log.WithFields(appFields).WithFields(logrus.Fields{"func": "WebhookDispatcher"}), stopWebhookDispatcher)
+		defer close(stopWebhookDispatcher)
+
+		stopConsentSweeper := make(chan struct{})
+		go app.RunConsentSweeper(env, log.WithFields(appFields).WithFields(logrus.Fields{"func": "ConsentSweeper"}), stopConsentSweeper)
+		defer close(stopConsentSweeper)
+
+		stopUsageFlusher := make(chan struct{})
+		go app.RunUsageFlusher(env, log.WithFields(appFields).WithFields(logrus.Fields{"func": "UsageFlusher"}), stopUsageFlusher)
+		defer close(stopUsageFlusher)
 	}
 
 	if *optServe {
@@ -200,9 +246,10 @@ func serve(env *app.Environment) {
 
 	// Authenticated endpoints
 	ep := r.Group("/")
-	ep.Use(app.AuthenticationRequired(env.Constants.LogKey, env.Constants.AccessTokenKey))
+	ep.Use(app.AuthenticationRequired(env))
 	{
 		ep.GET("/entities/judge", app.AuthorizationRequired(env, ""), entities.GetEntitiesJudge(env)) // Look for authenticated access token.
+		ep.GET("/entities/judge/cache", app.AuthorizationRequired(env, "aap:read:entities:judge:cache"), entities.GetEntitiesJudgeCache(env))
 
 		ep.POST("/entities", app.AuthorizationRequired(env, "aap:create:entities"), entities.PostEntities(env))
 
@@ -224,6 +271,11 @@ func serve(env *app.Environment) {
 		ep.GET("/consents/authorize", app.AuthorizationRequired(env, "aap:read:consents:authorize"), consents.GetAuthorize(env))
 		ep.POST("/consents/authorize", app.AuthorizationRequired(env, "aap:create:consents:authorize"), consents.PostAuthorize(env))
 		ep.POST("/consents/reject", app.AuthorizationRequired(env, "aap:create:consents:reject"), consents.PostReject(env))
+		ep.GET("/consents/history", app.AuthorizationRequired(env, "aap:read:consents"), consents.GetConsentsHistory(env))
+		ep.GET("/consents/receipts", app.AuthorizationRequired(env, "aap:read:consents"), consents.GetConsentsReceipts(env))
+
+		ep.GET("/apps", app.AuthorizationRequired(env, "aap:read:apps"), apps.GetApps(env))
+		ep.DELETE("/apps", app.AuthorizationRequired(env, "aap:delete:apps"), apps.DeleteApps(env))
 
 		ep.POST("/publishes", app.AuthorizationRequired(env, "aap:create:publishes"), publishings.PostPublishes(env))
 		ep.GET("/publishes", app.AuthorizationRequired(env, "aap:read:publishes"), publishings.GetPublishes(env))
@@ -232,6 +284,17 @@ func serve(env *app.Environment) {
 		ep.POST("/subscriptions", app.AuthorizationRequired(env, "aap:create:subscriptions"), subscriptions.PostSubscriptions(env))
 		ep.GET("/subscriptions", app.AuthorizationRequired(env, "aap:read:subscriptions"), subscriptions.GetSubscriptions(env))
 		ep.DELETE("/subscriptions", app.AuthorizationRequired(env, "aap:delete:subscriptions"), subscriptions.DeleteSubscriptions(env))
+		ep.PUT("/subscriptions/approvals", app.AuthorizationRequired(env, "aap:update:subscriptions:approvals"), subscriptions.PutSubscriptionsApprovals(env))
+
+		ep.POST("/revocations", app.AuthorizationRequired(env, "aap:create:revocations"), revocations.PostRevocations(env))
+		ep.GET("/revocations", app.AuthorizationRequired(env, "aap:read:revocations"), revocations.GetRevocations(env))
+
+		ep.POST("/webhooks", app.AuthorizationRequired(env, "aap:create:webhooks"), webhooks.PostWebhooks(env))
+		ep.GET("/webhooks", app.AuthorizationRequired(env, "aap:read:webhooks"), webhooks.GetWebhooks(env))
+		ep.DELETE("/webhooks", app.AuthorizationRequired(env, "aap:delete:webhooks"), webhooks.DeleteWebhooks(env))
+
+		ep.GET("/webhooks/deliveries", app.AuthorizationRequired(env, "aap:read:webhooks"), webhooks.GetWebhookDeliveries(env))
+		ep.POST("/webhooks/deliveries/replay", app.AuthorizationRequired(env, "aap:update:webhooks"), webhooks.PostWebhookDeliveriesReplay(env))
 	}
 
 	r.RunTLS(":"+config.GetString("serve.public.port"), config.GetString("serve.tls.cert.path"), config.GetString("serve.tls.key.path"))